@@ -0,0 +1,214 @@
+package luddite
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultAdminURIPath = "/admin"
+	redactedValue       = "REDACTED"
+)
+
+// secretKeyParts identify config keys whose values are redacted when the
+// config is dumped.
+var secretKeyParts = []string{"credential", "key", "passphrase", "password", "secret", "token"}
+
+// SetEffectiveConfig allows a service to report its own config, typically a
+// struct embedding ServiceConfig with any environment or runtime overrides
+// applied, from the admin config endpoint. By default the endpoint reports the
+// ServiceConfig that the service was created with.
+func (s *Service) SetEffectiveConfig(config interface{}) {
+	s.effectiveConfig = config
+}
+
+// redactedHeaders are request headers whose values are redacted when echoed,
+// in addition to those whose names look like secret keys (e.g. X-Api-Key).
+var redactedHeaders = map[string]bool{
+	HeaderAuthorization:      true,
+	HeaderCookie:             true,
+	HeaderProxyAuthorization: true,
+}
+
+// echo is a diagnostic transfer object describing a request as the service saw
+// it after negotiation and authentication.
+type echo struct {
+	XMLName         xml.Name     `json:"-" xml:"echo"`
+	Method          string       `json:"method" xml:"method"`
+	URI             string       `json:"uri" xml:"uri"`
+	Proto           string       `json:"proto" xml:"proto"`
+	Host            string       `json:"host" xml:"host"`
+	ExternalHost    string       `json:"external_host" xml:"external_host"`
+	ClientAddr      string       `json:"client_addr" xml:"client_addr"`
+	ForwardedFor    string       `json:"forwarded_for,omitempty" xml:"forwarded_for,omitempty"`
+	ContentType     string       `json:"content_type" xml:"content_type"`
+	ApiVersion      int          `json:"api_version" xml:"api_version"`
+	RequestId       string       `json:"request_id" xml:"request_id"`
+	ParentRequestId string       `json:"parent_request_id,omitempty" xml:"parent_request_id,omitempty"`
+	SessionId       string       `json:"session_id,omitempty" xml:"session_id,omitempty"`
+	Principal       string       `json:"principal,omitempty" xml:"principal,omitempty"`
+	Scopes          []string     `json:"scopes,omitempty" xml:"scopes>scope,omitempty"`
+	Headers         []echoHeader `json:"headers" xml:"headers>header"`
+}
+
+type echoHeader struct {
+	Name  string `json:"name" xml:"name,attr"`
+	Value string `json:"value" xml:",chardata"`
+}
+
+func (s *Service) addAdminRoutes() {
+	s.globalRouter.GET(path.Join(s.config.Admin.URIPath, "config"), s.requireAdmin(s.serveConfig))
+	if s.config.Debug.Echo {
+		s.globalRouter.GET(path.Join(s.config.Admin.URIPath, "echo"), s.requireAdmin(s.serveEcho))
+	}
+}
+
+// requireAdmin restricts an admin endpoint to authenticated principals that
+// have the configured admin scope, if any.
+func (s *Service) requireAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		p := ContextPrincipal(req.Context())
+		if p == nil {
+			_ = WriteResponse(rw, http.StatusUnauthorized, NewError(nil, EcodeUnauthenticated))
+			return
+		}
+		if scope := s.config.Admin.Scope; scope != "" && !p.HasScope(scope) {
+			e := NewError(nil, EcodeMissingScope, scope)
+			e.Details = []string{scope}
+			_ = WriteResponse(rw, http.StatusForbidden, e)
+			return
+		}
+		h(rw, req)
+	}
+}
+
+// serveConfig dumps the effective config as YAML, using the same keys as a
+// config file, with secrets redacted. The config is marshaled on each request
+// so that runtime changes are reflected.
+func (s *Service) serveConfig(rw http.ResponseWriter, req *http.Request) {
+	config := s.effectiveConfig
+	if config == nil {
+		config = s.config
+	}
+	b, err := redactConfig(config)
+	if err != nil {
+		_ = WriteResponse(rw, http.StatusInternalServerError, NewError(nil, EcodeSerializationFailed, err))
+		return
+	}
+	rw.Header().Set(HeaderContentType, ContentTypeYaml)
+	rw.Header().Set(HeaderCacheControl, "no-store")
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write(b)
+}
+
+// serveEcho describes the request in the negotiated content type, which is
+// useful when debugging proxies, CDNs and client negotiation. Credentials in the
+// request headers are redacted.
+func (s *Service) serveEcho(rw http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	e := &echo{
+		Method:          req.Method,
+		URI:             req.RequestURI,
+		Proto:           req.Proto,
+		Host:            req.Host,
+		ExternalHost:    RequestExternalHost(req),
+		ClientAddr:      req.RemoteAddr,
+		ForwardedFor:    req.Header.Get(HeaderForwardedFor),
+		ContentType:     ResponseMediaType(rw).Base(),
+		ApiVersion:      ContextApiVersion(ctx),
+		RequestId:       ContextRequestId(ctx),
+		ParentRequestId: req.Header.Get(HeaderRequestId),
+		SessionId:       ContextSessionId(ctx),
+		Headers:         []echoHeader{},
+	}
+	if p := ContextPrincipal(ctx); p != nil {
+		e.Principal = p.Id
+		e.Scopes = p.Scopes
+	}
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range req.Header[name] {
+			if redactedHeaders[name] || isSecretKey(name) {
+				value = redactedValue
+			}
+			e.Headers = append(e.Headers, echoHeader{name, value})
+		}
+	}
+	rw.Header().Set(HeaderCacheControl, "no-store")
+	_ = WriteResponse(rw, http.StatusOK, e)
+}
+
+// redactConfig marshals a config as YAML, redacting the values of secret keys
+// and the passwords of URLs.
+func redactConfig(config interface{}) ([]byte, error) {
+	b, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err = yaml.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(redact(v, false))
+}
+
+func redact(v interface{}, secret bool) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		for k, elem := range v {
+			key, _ := k.(string)
+			v[k] = redact(elem, secret || isSecretKey(key))
+		}
+		return v
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = redact(elem, secret)
+		}
+		return v
+	case string:
+		if secret && v != "" {
+			return redactedValue
+		}
+		return redactURL(v)
+	default:
+		// Numbers and booleans aren't secrets
+		return v
+	}
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, part := range secretKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactURL redacts the password of a URL such as a mirror backend's. Other
+// strings are returned unchanged.
+func redactURL(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	u.User = url.UserPassword(u.User.Username(), redactedValue)
+	return u.String()
+}
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"encoding/xml"
-	"mime"
+	"errors"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"reflect"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/schema"
@@ -18,20 +21,41 @@ const (
 	ContentTypeGif               = "image/gif"
 	ContentTypeHtml              = "text/html"
 	ContentTypeJson              = "application/json"
+	ContentTypeJsonPatch         = "application/json-patch+json"
+	ContentTypeMergePatch        = "application/merge-patch+json"
 	ContentTypeMsgpack           = "application/msgpack"
 	ContentTypeMultipartFormData = "multipart/form-data"
+	ContentTypeNdjson            = "application/x-ndjson"
 	ContentTypeOctetStream       = "application/octet-stream"
 	ContentTypePlain             = "text/plain"
 	ContentTypePng               = "image/png"
 	ContentTypeProtobuf          = "application/protobuf"
 	ContentTypeWwwFormUrlencoded = "application/x-www-form-urlencoded"
 	ContentTypeXml               = "application/xml"
+	ContentTypeYaml              = "application/x-yaml"
 
 	maxFormDataMemoryUsage = 10 * 1024 * 1024
+
+	defaultMaxBufferedBodySize = 1024 * 1024
 )
 
+// ErrRequestBodyTooLarge is returned by BufferRequestBody when a request body
+// exceeds the maximum buffered body size.
+var ErrRequestBodyTooLarge = errors.New("request body exceeds maximum buffered size")
+
 var formDecoder = schema.NewDecoder()
 
+// supportedRequestTypes are the request body content types that ReadRequest
+// deserializes.
+var supportedRequestTypes = map[string]bool{
+	ContentTypeJson:              true,
+	ContentTypeJsonPatch:         true,
+	ContentTypeMergePatch:        true,
+	ContentTypeMultipartFormData: true,
+	ContentTypeWwwFormUrlencoded: true,
+	ContentTypeXml:               true,
+}
+
 func init() {
 	t := time.Time{}
 	formDecoder.RegisterConverter(t, convertTime)
@@ -44,12 +68,86 @@ func convertTime(value string) reflect.Value {
 	return reflect.Value{}
 }
 
+// countingBody counts the bytes read from a request body of unknown length.
+type countingBody struct {
+	io.ReadCloser
+	size int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.size += int64(n)
+	return n, err
+}
+
+// readCloser replaces a request body's reader while retaining its Close method.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// BufferRequestBody reads a request's entire body into memory and rewinds the
+// body so that it can be consumed again by downstream handlers, e.g. by
+// middleware that verifies a request signature and then by ReadRequest.
+// Repeated calls return the same buffer. Bodies larger than the service's
+// MaxBufferedBodySize config value (1 MB by default) are rejected with
+// ErrRequestBodyTooLarge; the body remains readable from the start in that
+// case too.
+func BufferRequestBody(req *http.Request) ([]byte, error) {
+	d := contextHandlerDetails(req.Context())
+	if d != nil && d.body != nil {
+		req.Body = readCloser{bytes.NewReader(d.body), req.Body}
+		return d.body, nil
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return []byte{}, nil
+	}
+
+	maxSize := int64(defaultMaxBufferedBodySize)
+	if d != nil && d.s != nil && d.s.config.Limits.MaxBufferedBodySize > 0 {
+		maxSize = d.s.config.Limits.MaxBufferedBodySize
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(req.Body, maxSize+1))
+	if err != nil || int64(len(b)) > maxSize {
+		req.Body = readCloser{io.MultiReader(bytes.NewReader(b), req.Body), req.Body}
+		if err == nil {
+			err = ErrRequestBodyTooLarge
+		}
+		return nil, err
+	}
+	if d != nil {
+		d.body = b
+	}
+	req.Body = readCloser{bytes.NewReader(b), req.Body}
+	return b, nil
+}
+
 // ReadRequest deserializes a request body according to the Content-Type header.
+// JSON Patch and JSON Merge Patch documents are deserialized as JSON.
 func ReadRequest(req *http.Request, v interface{}) error {
 	SetContextRequestProgress(req.Context(), "luddite.ReadRequest.begin")
+	start := time.Now()
+	if t := contextTiming(ContextResponseWriter(req.Context())); t != nil {
+		defer func() { t.add(ServerTimingDecode, time.Since(start)) }()
+	}
 
 	ct := req.Header.Get(HeaderContentType)
-	switch mt, _, _ := mime.ParseMediaType(ct); mt {
+	if ct == "" {
+		return nil
+	}
+	mt, err := ParseMediaType(ct)
+	if err != nil {
+		observeUnsupportedContentType(ct)
+		return NewError(nil, EcodeUnsupportedMediaType, ct)
+	}
+	if d := contextHandlerDetails(req.Context()); d != nil && req.Body != nil && supportedRequestTypes[mt.Base()] {
+		body := &countingBody{ReadCloser: req.Body}
+		req.Body = body
+		defer func() {
+			observeSerialization(serializationDecode, mt.Base(), routeLabel(d), time.Since(start), body.size)
+		}()
+	}
+	switch mt.Base() {
 	case ContentTypeMultipartFormData:
 		if err := req.ParseMultipartForm(maxFormDataMemoryUsage); err != nil {
 			return NewError(nil, EcodeDeserializationFailed, err)
@@ -66,7 +164,7 @@ func ReadRequest(req *http.Request, v interface{}) error {
 			return NewError(nil, EcodeDeserializationFailed, err)
 		}
 		return nil
-	case ContentTypeJson:
+	case ContentTypeJson, ContentTypeJsonPatch, ContentTypeMergePatch:
 		decoder := json.NewDecoder(req.Body)
 		err := decoder.Decode(v)
 		if err != nil {
@@ -80,41 +178,53 @@ func ReadRequest(req *http.Request, v interface{}) error {
 			return NewError(nil, EcodeDeserializationFailed, err)
 		}
 		return nil
-	case "":
-		return nil
 	default:
+		observeUnsupportedContentType(ct)
 		return NewError(nil, EcodeUnsupportedMediaType, ct)
 	}
 }
 
-// WriteResponse serializes a response body according to the negotiated Content-Type.
+// WriteResponse serializes a response body according to the negotiated
+// Content-Type. It also adds the request headers used for negotiation to the
+// response's Vary header. The body is serialized in full before anything is
+// written, so serialization failures produce a complete 500 response rather
+// than a truncated one.
 func WriteResponse(rw http.ResponseWriter, status int, v interface{}) (err error) {
+	// Responses are negotiated by both content type and API version
+	addVary(rw.Header(), negotiatedHeaders...)
+
+	start := time.Now()
+
 	var b []byte
 	if v != nil {
-		switch v.(type) {
+		switch x := v.(type) {
 		case *Error:
+		case *MultiStatus:
+			x.normalize()
 		case error:
 			v = NewError(nil, EcodeInternal, v)
 		}
-		switch ct := rw.Header().Get(HeaderContentType); ct {
+		if e, ok := v.(*Error); ok {
+			setErrorContentType(rw)
+			if e.RateLimit != nil {
+				SetRateLimitHeaders(rw, e.RateLimit)
+			}
+		}
+		ct := ResponseMediaType(rw)
+		switch ct.Base() {
 		case ContentTypeJson:
 			b, err = json.Marshal(v)
+			if _, ok := v.(*Error); !ok && err == nil {
+				b, err = applyNullPolicy(b, responseNullPolicy(rw))
+			}
 			if err != nil {
-				rw.WriteHeader(http.StatusInternalServerError)
-				b, err = json.Marshal(NewError(nil, EcodeSerializationFailed, err))
-				if err != nil {
-					_, _ = rw.Write(b)
-				}
+				_ = WriteResponse(rw, http.StatusInternalServerError, NewError(nil, EcodeSerializationFailed, err))
 				return
 			}
 		case ContentTypeXml:
 			b, err = xml.Marshal(v)
 			if err != nil {
-				rw.WriteHeader(http.StatusInternalServerError)
-				b, err = xml.Marshal(NewError(nil, EcodeSerializationFailed, err))
-				if err != nil {
-					_, _ = rw.Write(b)
-				}
+				_ = WriteResponse(rw, http.StatusInternalServerError, NewError(nil, EcodeSerializationFailed, err))
 				return
 			}
 		case ContentTypeHtml:
@@ -126,11 +236,7 @@ func WriteResponse(rw http.ResponseWriter, status int, v interface{}) (err error
 			default:
 				b, err = json.Marshal(v)
 				if err != nil {
-					rw.WriteHeader(http.StatusInternalServerError)
-					b, err = json.Marshal(NewError(nil, EcodeSerializationFailed, err))
-					if err != nil {
-						_, _ = rw.Write(b)
-					}
+					_ = WriteResponse(rw, http.StatusInternalServerError, NewError(nil, EcodeSerializationFailed, err))
 					return
 				}
 				esc := new(bytes.Buffer)
@@ -138,26 +244,74 @@ func WriteResponse(rw http.ResponseWriter, status int, v interface{}) (err error
 				b = esc.Bytes()
 			}
 		default:
-			switch v.(type) {
+			switch x := v.(type) {
+			case *Error:
+				// Errors fall back to plain text
+				b = []byte(x.text())
+				rw.Header().Set(HeaderContentType, ContentTypePlain)
 			case []byte:
 				b = v.([]byte)
-				if ct == "" {
+				if rw.Header().Get(HeaderContentType) == "" {
 					rw.Header().Set(HeaderContentType, ContentTypeOctetStream)
 				}
 			case string:
 				b = []byte(v.(string))
-				if ct == "" {
+				if rw.Header().Get(HeaderContentType) == "" {
 					rw.Header().Set(HeaderContentType, ContentTypePlain)
 				}
 			default:
-				rw.WriteHeader(http.StatusNotAcceptable)
+				if res, ok := rw.(*responseWriter); ok {
+					observeNotAcceptable(res.accept)
+				}
+				err = WriteResponse(rw, http.StatusNotAcceptable, NewError(nil, EcodeNotAcceptable))
 				return
 			}
 		}
 	}
+	if res, ok := rw.(*responseWriter); ok && res.listGuard != nil && b != nil {
+		// Enforce the unpaginated list size limit on the serialized list
+		g := res.listGuard
+		res.listGuard = nil
+		var e *Error
+		if b, e = g.apply(rw, ResponseMediaType(rw).Base(), b); e != nil {
+			err = WriteResponse(rw, http.StatusInternalServerError, e)
+			return
+		}
+	}
+	if res, ok := rw.(*responseWriter); ok && res.exceedsMaxSize(int64(len(b))) {
+		// The error response itself is exempt from the size limit
+		maxSize := res.maxSize
+		res.maxSize = 0
+		err = WriteResponse(rw, http.StatusInternalServerError, NewError(nil, EcodeResponseTooLarge, maxSize))
+		res.maxSize = maxSize
+		return
+	}
+	if v != nil {
+		elapsed := time.Since(start)
+		if t := contextTiming(rw); t != nil {
+			t.add(ServerTimingEncode, elapsed)
+		}
+		if res, ok := rw.(*responseWriter); ok {
+			route := res.route
+			if route == "" {
+				route = "other"
+			}
+			observeSerialization(serializationEncode, ResponseMediaType(rw).Base(), route, elapsed, int64(len(b)))
+		}
+	}
+	if b != nil && bodyAllowedForStatus(status) {
+		// Declare the length so that clients can detect a truncated body
+		rw.Header().Set(HeaderContentLength, strconv.Itoa(len(b)))
+	}
 	rw.WriteHeader(status)
 	if b != nil {
 		_, err = rw.Write(b)
 	}
 	return
 }
+
+// bodyAllowedForStatus returns true if a response with the given status may
+// have a body.
+func bodyAllowedForStatus(status int) bool {
+	return status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified
+}
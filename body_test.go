@@ -3,9 +3,12 @@ package luddite
 import (
 	"bytes"
 	"encoding/xml"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -226,3 +229,188 @@ func TestReadUrlencoded(t *testing.T) {
 		t.Error("Urlencoded date deserialization failed")
 	}
 }
+
+func TestWriteMaxResponseSize(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.Header().Add(HeaderContentType, ContentTypeJson)
+	rw := new(responseWriter)
+	rw.init(rec)
+	rw.maxSize = 32
+
+	s := &sample{
+		Id:        sampleId,
+		Name:      sampleName,
+		Flag:      true,
+		Data:      []byte(sampleData),
+		Timestamp: sampleTimestamp,
+	}
+	if err := WriteResponse(rw, http.StatusOK, s); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500/Internal Server Error response for oversized body, got: %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), EcodeResponseTooLarge) {
+		t.Errorf("unexpected error body: %s", rec.Body.String())
+	}
+	if rw.overflow != int64(len(sampleJsonBody)) {
+		t.Errorf("oversized body not recorded: %d", rw.overflow)
+	}
+	if _, err := rw.Write(make([]byte, 64)); err != ErrResponseTooLarge {
+		t.Errorf("oversized write not rejected: %v", err)
+	}
+}
+
+func TestWriteSerializationFailure(t *testing.T) {
+	rw := httptest.NewRecorder()
+	rw.Header().Add(HeaderContentType, ContentTypeJson)
+
+	if err := WriteResponse(rw, http.StatusOK, map[string]interface{}{"c": make(chan int)}); err == nil {
+		t.Error("expected serialization error")
+	}
+	if rw.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500/Internal Server Error response, got: %d", rw.Code)
+	}
+	if !strings.Contains(rw.Body.String(), EcodeSerializationFailed) {
+		t.Errorf("unexpected error body: %s", rw.Body.String())
+	}
+	if cl := rw.Header().Get(HeaderContentLength); cl != strconv.Itoa(rw.Body.Len()) {
+		t.Errorf("expected Content-Length %d, got: %s", rw.Body.Len(), cl)
+	}
+}
+
+type partialResource struct{}
+
+func (r *partialResource) Get(req *http.Request) (int, interface{}) {
+	rw := ContextResponseWriter(req.Context())
+	rw.Header().Set(HeaderContentType, ContentTypeNdjson)
+	_, _ = rw.Write([]byte("{\"id\":1}\n{\"id\":"))
+	panic("encoder failed")
+}
+
+func TestTruncatedResponse(t *testing.T) {
+	s := newTestService(t, nil)
+	logs := new(bytes.Buffer)
+	s.defaultLogger.Out = logs
+	mustAddResource(t, s, 1, "/partial", &partialResource{})
+
+	req, _ := http.NewRequest("GET", "/partial", nil)
+	rw := &truncationRecorder{ResponseRecorder: httptest.NewRecorder()}
+	s.ServeHTTP(rw, req)
+	if body := rw.Body.String(); body != "{\"id\":1}\n{\"id\":" {
+		t.Errorf("expected error body to be withheld, got: %s", body)
+	}
+	if !rw.truncated {
+		t.Error("expected truncation to be reported to the writer")
+	}
+	if !strings.Contains(logs.String(), "response body truncated") {
+		t.Errorf("expected truncation to be logged, got: %s", logs.String())
+	}
+
+	// Writers that aren't net/http's own never see the abort panic
+	s.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Clients of a real server see the connection close rather than a
+	// complete response
+	server := httptest.NewServer(s)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/partial")
+	if err == nil {
+		_, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if err == nil {
+		t.Error("expected truncated response to fail")
+	}
+}
+
+type truncationRecorder struct {
+	*httptest.ResponseRecorder
+	truncated bool
+}
+
+func (rw *truncationRecorder) ResponseTruncated() {
+	rw.truncated = true
+}
+
+func TestShortWriteTruncation(t *testing.T) {
+	rw := new(responseWriter)
+	rw.init(httptest.NewRecorder())
+	rw.Header().Set(HeaderContentLength, "10")
+	_, _ = rw.Write([]byte("short"))
+	if !rw.truncated() {
+		t.Error("expected body shorter than Content-Length to be truncated")
+	}
+
+	rw.init(httptest.NewRecorder())
+	rw.maxSize = 8
+	_, _ = rw.Write([]byte("partial"))
+	if rw.truncated() {
+		t.Error("unexpected truncation")
+	}
+	if _, err := rw.Write([]byte("overflow")); err != ErrResponseTooLarge {
+		t.Errorf("oversized write not rejected: %v", err)
+	}
+	if !rw.truncated() {
+		t.Error("expected oversized body to be truncated")
+	}
+}
+
+type brokenPipeWriter struct {
+	*httptest.ResponseRecorder
+	writes int
+}
+
+func (w *brokenPipeWriter) Write(b []byte) (int, error) {
+	w.writes++
+	return 0, syscall.EPIPE
+}
+
+func TestWriteClientAbort(t *testing.T) {
+	base := &brokenPipeWriter{ResponseRecorder: httptest.NewRecorder()}
+	rw := &responseWriter{}
+	rw.init(base)
+	rw.Header().Set(HeaderContentType, ContentTypeJson)
+	if err := WriteResponse(rw, http.StatusOK, "hello"); err != syscall.EPIPE {
+		t.Errorf("expected write error, got: %v", err)
+	}
+	if _, err := rw.Write([]byte("more")); err != syscall.EPIPE {
+		t.Errorf("expected sticky write error, got: %v", err)
+	}
+	if base.writes != 1 {
+		t.Errorf("writes continued after client abort: %d", base.writes)
+	}
+	if !rw.clientAborted() {
+		t.Error("client abort not recorded")
+	}
+}
+
+func TestBufferRequestBody(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/", strings.NewReader(`{"id":1}`))
+	req = req.WithContext(withHandlerDetails(req.Context(), &handlerDetails{}))
+	req.Header.Set(HeaderContentType, ContentTypeJson)
+
+	b, err := BufferRequestBody(req)
+	if err != nil || string(b) != `{"id":1}` {
+		t.Fatalf("incorrect buffered body: %q %v", b, err)
+	}
+	if b, err = BufferRequestBody(req); err != nil || string(b) != `{"id":1}` {
+		t.Errorf("incorrect rebuffered body: %q %v", b, err)
+	}
+	var v sample
+	if err = ReadRequest(req, &v); err != nil || v.Id != 1 {
+		t.Errorf("buffered body not readable: %v", err)
+	}
+
+	s := &Service{config: new(ServiceConfig)}
+	s.config.Limits.MaxBufferedBodySize = 4
+	req, _ = http.NewRequest("POST", "/", strings.NewReader("too large"))
+	req = req.WithContext(withHandlerDetails(req.Context(), &handlerDetails{s: s}))
+	if _, err = BufferRequestBody(req); err != ErrRequestBodyTooLarge {
+		t.Errorf("expected ErrRequestBodyTooLarge, got: %v", err)
+	}
+	if b, _ = ioutil.ReadAll(req.Body); string(b) != "too large" {
+		t.Errorf("oversized body not restored: %q", b)
+	}
+}
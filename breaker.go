@@ -0,0 +1,188 @@
+package luddite
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerErrorRate   = 0.5
+	defaultBreakerMinRequests = 20
+	defaultBreakerWindow      = 10 * time.Second
+	defaultBreakerCoolDown    = 30 * time.Second
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker tracks the failures of a single route. Once the failure rate
+// within a window reaches the threshold, the breaker opens and the route fails
+// fast for a cool-down period. A single trial request is then let through: its
+// success closes the breaker and its failure reopens it.
+type circuitBreaker struct {
+	set         *breakerSet
+	method      string
+	route       string
+	mu          sync.Mutex
+	state       breakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openUntil   time.Time
+}
+
+// allow reports whether a request may be handled and, if not, how long until
+// the route may be retried.
+func (b *circuitBreaker) allow(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if now.Before(b.openUntil) {
+			return false, b.openUntil.Sub(now)
+		}
+		b.state = breakerHalfOpen
+		return true, 0
+	case breakerHalfOpen:
+		// A trial request is already in flight
+		return false, b.set.coolDown
+	}
+	return true, 0
+}
+
+// record counts a request's outcome. Requests fail if they produce a 5xx
+// response or, when a latency threshold is configured, are too slow.
+func (b *circuitBreaker) record(status int, latency time.Duration, now time.Time) {
+	failed := status == 0 || status/100 == 5 || (b.set.latency > 0 && latency > b.set.latency)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerHalfOpen:
+		if failed {
+			b.open(now)
+		} else {
+			b.close(now)
+		}
+		return
+	case breakerOpen:
+		return
+	}
+	if now.Sub(b.windowStart) > b.set.window {
+		b.windowStart, b.requests, b.failures = now, 0, 0
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+	if b.requests >= b.set.minRequests && float64(b.failures)/float64(b.requests) >= b.set.errorRate {
+		b.open(now)
+	}
+}
+
+func (b *circuitBreaker) open(now time.Time) {
+	b.state = breakerOpen
+	b.openUntil = now.Add(b.set.coolDown)
+	httpCircuitBreakerOpen.WithLabelValues(b.method, b.route).Set(1)
+}
+
+func (b *circuitBreaker) close(now time.Time) {
+	b.state = breakerClosed
+	b.windowStart, b.requests, b.failures = now, 0, 0
+	httpCircuitBreakerOpen.WithLabelValues(b.method, b.route).Set(0)
+}
+
+// breakerSet holds a service's per-route circuit breakers.
+type breakerSet struct {
+	errorRate   float64
+	latency     time.Duration
+	minRequests int
+	window      time.Duration
+	coolDown    time.Duration
+	mu          sync.Mutex
+	breakers    map[string]*circuitBreaker
+}
+
+func newBreakerSet(errorRate float64, latency time.Duration, minRequests int, window, coolDown time.Duration) *breakerSet {
+	return &breakerSet{
+		errorRate:   errorRate,
+		latency:     latency,
+		minRequests: minRequests,
+		window:      window,
+		coolDown:    coolDown,
+		breakers:    make(map[string]*circuitBreaker),
+	}
+}
+
+func (bs *breakerSet) get(method, route string) *circuitBreaker {
+	key := method + " " + route
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b, ok := bs.breakers[key]
+	if !ok {
+		b = &circuitBreaker{set: bs, method: method, route: route, windowStart: time.Now()}
+		bs.breakers[key] = b
+	}
+	return b
+}
+
+// openCircuit describes a route whose breaker is open.
+type openCircuit struct {
+	Method string    `json:"method" xml:"method"`
+	Route  string    `json:"route" xml:"route"`
+	Until  time.Time `json:"until" xml:"until"`
+}
+
+// open returns the routes whose breakers are open or half-open.
+func (bs *breakerSet) open() []*openCircuit {
+	bs.mu.Lock()
+	breakers := make([]*circuitBreaker, 0, len(bs.breakers))
+	for _, b := range bs.breakers {
+		breakers = append(breakers, b)
+	}
+	bs.mu.Unlock()
+
+	var circuits []*openCircuit
+	for _, b := range breakers {
+		b.mu.Lock()
+		if b.state != breakerClosed {
+			circuits = append(circuits, &openCircuit{b.method, b.route, b.openUntil})
+		}
+		b.mu.Unlock()
+	}
+	sort.Slice(circuits, func(i, j int) bool {
+		if circuits[i].Route != circuits[j].Route {
+			return circuits[i].Route < circuits[j].Route
+		}
+		return circuits[i].Method < circuits[j].Method
+	})
+	return circuits
+}
+
+// checkBreaker fails fast with a 503 response while the breaker of the route
+// handling a request is open. It returns false if a response was written.
+func checkBreaker(rw http.ResponseWriter, req *http.Request, method, pattern string) bool {
+	d := contextHandlerDetails(req.Context())
+	if d == nil || d.s == nil || d.s.breakers == nil {
+		return true
+	}
+	b := d.s.breakers.get(method, pattern)
+	ok, retryAfter := b.allow(time.Now())
+	if !ok {
+		httpCircuitBreakerRejections.WithLabelValues(method, pattern).Inc()
+		rw.Header().Set(HeaderRetryAfter, strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		_ = WriteResponse(rw, http.StatusServiceUnavailable, NewError(nil, EcodeRouteUnavailable, method, pattern))
+		return false
+	}
+	d.breaker = b
+	return true
+}
@@ -0,0 +1,70 @@
+package luddite
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type flakyResource struct {
+	status int32
+}
+
+func (r *flakyResource) Get(req *http.Request) (int, interface{}) {
+	return int(atomic.LoadInt32(&r.status)), "flaky"
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.CircuitBreaker.Enabled = true
+		config.CircuitBreaker.MinRequests = 4
+		config.CircuitBreaker.CoolDown = 50 * time.Millisecond
+		config.Ready.Enabled = true
+	})
+	s.addReadyRoute()
+	r := &flakyResource{status: http.StatusBadGateway}
+	mustAddResource(t, s, 1, "/flaky", r)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set(HeaderAccept, ContentTypeJson)
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, req)
+		return rw
+	}
+
+	for i := 0; i < 4; i++ {
+		if rw := get("/flaky"); rw.Code != http.StatusBadGateway {
+			t.Fatalf("expected handler's 502 response, got: %d", rw.Code)
+		}
+	}
+
+	// The breaker is now open
+	rw := get("/flaky")
+	if rw.Code != http.StatusServiceUnavailable || rw.Header().Get(HeaderRetryAfter) == "" {
+		t.Errorf("expected 503 response with Retry-After, got: %d", rw.Code)
+	}
+	var ready readiness
+	if err := json.Unmarshal(get("/ready").Body.Bytes(), &ready); err != nil {
+		t.Fatal(err)
+	}
+	if !ready.Ready || len(ready.OpenCircuits) != 1 || ready.OpenCircuits[0].Route != "/flaky" {
+		t.Errorf("expected open circuit to be reported, got: %+v", ready)
+	}
+
+	// After the cool-down a successful trial request closes the breaker
+	time.Sleep(60 * time.Millisecond)
+	atomic.StoreInt32(&r.status, http.StatusOK)
+	if rw = get("/flaky"); rw.Code != http.StatusOK {
+		t.Errorf("expected trial request to succeed, got: %d", rw.Code)
+	}
+	if rw = get("/flaky"); rw.Code != http.StatusOK {
+		t.Errorf("expected closed breaker, got: %d", rw.Code)
+	}
+	if circuits := s.breakers.open(); len(circuits) != 0 {
+		t.Errorf("expected no open circuits, got: %d", len(circuits))
+	}
+}
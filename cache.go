@@ -0,0 +1,95 @@
+package luddite
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheController is implemented by resources that declare the cacheability
+// of their GET responses. Luddite uses the declaration to emit consistent
+// Cache-Control and Expires headers.
+type CacheController interface {
+	// CacheControl returns the maximum age of successful responses and
+	// whether they may be stored by shared caches. A zero maximum age
+	// requires clients to revalidate before reuse.
+	CacheControl() (maxAge time.Duration, public bool)
+}
+
+// StaleCacheController is optionally implemented by CacheControllers whose
+// responses remain usable for a while after they expire. Caches (including
+// luddite's own server-side cache) may serve stale responses while they refresh
+// them, for up to staleWhileRevalidate, and in place of errors, for up to
+// staleIfError.
+type StaleCacheController interface {
+	StaleCacheControl() (staleWhileRevalidate, staleIfError time.Duration)
+}
+
+// negotiatedHeaders lists the request headers that select a response's
+// representation.
+var negotiatedHeaders = []string{HeaderAccept, HeaderSpirentApiVersion}
+
+// setCacheHeaders adds Cache-Control and Expires headers to a GET response
+// according to a resource's cache policy. Unsuccessful responses are never
+// stored.
+func setCacheHeaders(rw http.ResponseWriter, status int, r interface{}) {
+	c, ok := r.(CacheController)
+	if !ok {
+		return
+	}
+
+	header := rw.Header()
+	if status/100 != 2 && status != http.StatusNotModified {
+		header.Set(HeaderCacheControl, "no-store")
+		return
+	}
+
+	maxAge, public := c.CacheControl()
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	directives := make([]string, 0, 5)
+	if public {
+		directives = append(directives, "public")
+	} else {
+		directives = append(directives, "private")
+	}
+	if maxAge == 0 {
+		directives = append(directives, "no-cache")
+	}
+	directives = append(directives, "max-age="+strconv.FormatInt(int64(maxAge/time.Second), 10))
+	if s, ok := r.(StaleCacheController); ok && maxAge > 0 {
+		staleWhileRevalidate, staleIfError := s.StaleCacheControl()
+		if staleWhileRevalidate > 0 {
+			directives = append(directives, "stale-while-revalidate="+strconv.FormatInt(int64(staleWhileRevalidate/time.Second), 10))
+		}
+		if staleIfError > 0 {
+			directives = append(directives, "stale-if-error="+strconv.FormatInt(int64(staleIfError/time.Second), 10))
+		}
+	}
+	header.Set(HeaderCacheControl, strings.Join(directives, ", "))
+	header.Set(HeaderExpires, time.Now().Add(maxAge).UTC().Format(http.TimeFormat))
+}
+
+// addVary merges header names into a response's Vary header.
+func addVary(header http.Header, names ...string) {
+	vary := header[HeaderVary]
+	for _, name := range names {
+		found := false
+		for _, v := range vary {
+			for _, existing := range strings.Split(v, ",") {
+				if existing = strings.TrimSpace(existing); existing == "*" || strings.EqualFold(existing, name) {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			vary = append(vary, name)
+		}
+	}
+	if len(vary) > 0 {
+		header[HeaderVary] = []string{strings.Join(vary, ", ")}
+	}
+}
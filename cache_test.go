@@ -0,0 +1,58 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type cachedResource struct {
+	maxAge time.Duration
+	public bool
+}
+
+func (r *cachedResource) CacheControl() (time.Duration, bool) {
+	return r.maxAge, r.public
+}
+
+func TestCacheHeaders(t *testing.T) {
+	rw := httptest.NewRecorder()
+	setCacheHeaders(rw, http.StatusOK, &cachedResource{maxAge: time.Minute, public: true})
+	if cc := rw.Header().Get(HeaderCacheControl); cc != "public, max-age=60" {
+		t.Errorf("incorrect Cache-Control header: %s", cc)
+	}
+	if _, err := http.ParseTime(rw.Header().Get(HeaderExpires)); err != nil {
+		t.Errorf("invalid Expires header: %v", err)
+	}
+
+	rw = httptest.NewRecorder()
+	setCacheHeaders(rw, http.StatusOK, &cachedResource{})
+	if cc := rw.Header().Get(HeaderCacheControl); cc != "private, no-cache, max-age=0" {
+		t.Errorf("incorrect Cache-Control header: %s", cc)
+	}
+
+	rw = httptest.NewRecorder()
+	setCacheHeaders(rw, http.StatusNotFound, &cachedResource{maxAge: time.Minute, public: true})
+	if cc := rw.Header().Get(HeaderCacheControl); cc != "no-store" {
+		t.Errorf("error response marked cacheable: %s", cc)
+	}
+
+	rw = httptest.NewRecorder()
+	setCacheHeaders(rw, http.StatusOK, struct{}{})
+	if _, ok := rw.Header()[HeaderCacheControl]; ok {
+		t.Error("Cache-Control header added without a cache policy")
+	}
+}
+
+func TestVaryHeader(t *testing.T) {
+	rw := httptest.NewRecorder()
+	rw.Header().Set(HeaderContentType, ContentTypeJson)
+	rw.Header().Set(HeaderVary, "accept-encoding, accept")
+	if err := WriteResponse(rw, http.StatusOK, "ok"); err != nil {
+		t.Fatal(err)
+	}
+	if vary := rw.Header().Get(HeaderVary); vary != "accept-encoding, accept, X-Spirent-Api-Version" {
+		t.Errorf("incorrect Vary header: %s", vary)
+	}
+}
@@ -0,0 +1,60 @@
+package luddite
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Reasons that a request's context was cancelled before it completed.
+const (
+	CancelReasonClientDisconnect = "client_disconnect"
+	CancelReasonTimeout          = "timeout"
+	CancelReasonShutdown         = "shutdown"
+)
+
+// setDraining marks the service as shutting down, e.g. so that its readiness
+// endpoint reports that it's unavailable.
+func (s *Service) setDraining() {
+	atomic.StoreInt32(&s.draining, 1)
+}
+
+func (s *Service) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) != 0
+}
+
+// contextBaseKey marks the service's base context with the service itself.
+const contextBaseKey = contextKey(3)
+
+// withBaseContext derives a request's context from the service's base context
+// as well as from its parent, so that the requests still in flight when the
+// drain timeout expires are cancelled. Requests served by the service's own
+// HTTP server already descend from the base context and are returned as is.
+func (s *Service) withBaseContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.baseCtx == nil || ctx.Value(contextBaseKey) == s {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-s.baseCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// cancelReason classifies the cancellation of a request's context. It returns
+// an empty string if the context wasn't cancelled.
+func (s *Service) cancelReason(ctx context.Context) string {
+	switch ctx.Err() {
+	case nil:
+		return ""
+	case context.DeadlineExceeded:
+		return CancelReasonTimeout
+	}
+	if s.baseCtx != nil && s.baseCtx.Err() != nil {
+		return CancelReasonShutdown
+	}
+	return CancelReasonClientDisconnect
+}
@@ -0,0 +1,66 @@
+package luddite
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCancelReason(t *testing.T) {
+	s := &Service{}
+
+	if reason := s.cancelReason(context.Background()); reason != "" {
+		t.Errorf("expected no reason for a live context, got %q", reason)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	if reason := s.cancelReason(ctx); reason != CancelReasonTimeout {
+		t.Errorf("expected %q, got %q", CancelReasonTimeout, reason)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if reason := s.cancelReason(ctx); reason != CancelReasonClientDisconnect {
+		t.Errorf("expected %q, got %q", CancelReasonClientDisconnect, reason)
+	}
+
+	// Draining alone doesn't attribute client disconnects to the shutdown
+	s.baseCtx, s.cancelBase = context.WithCancel(context.Background())
+	s.setDraining()
+	if reason := s.cancelReason(ctx); reason != CancelReasonClientDisconnect {
+		t.Errorf("expected %q while draining, got %q", CancelReasonClientDisconnect, reason)
+	}
+
+	reqCtx, reqCancel := s.withBaseContext(context.Background())
+	defer reqCancel()
+	s.cancelBase()
+	select {
+	case <-reqCtx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected request context to be cancelled with the base context")
+	}
+	if reason := s.cancelReason(reqCtx); reason != CancelReasonShutdown {
+		t.Errorf("expected %q, got %q", CancelReasonShutdown, reason)
+	}
+}
+
+func TestServerBaseContext(t *testing.T) {
+	s := newTestService(t, nil)
+	srvCtx, srvCancel := context.WithCancel(s.baseCtx)
+	defer srvCancel()
+	if ctx, _ := s.withBaseContext(srvCtx); ctx != srvCtx {
+		t.Error("expected contexts derived from the base context to be used as is")
+	}
+
+	other := newTestService(t, nil)
+	ctx, cancel := other.withBaseContext(srvCtx)
+	defer cancel()
+	other.cancelBase()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected another service's base context to be watched")
+	}
+}
@@ -0,0 +1,73 @@
+package luddite
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	RequestClassInteractive = "interactive"
+	RequestClassBatch       = "batch"
+	RequestClassHealth      = "health"
+)
+
+// ClassificationRule assigns a class to requests that match all of its
+// non-empty criteria.
+type ClassificationRule struct {
+	// Class is the class assigned to matching requests, e.g. "batch".
+	Class string
+	// Method, if set, matches the request method.
+	Method string
+	// PathPrefix, if set, matches the beginning of the request path.
+	PathPrefix string `yaml:"path_prefix"`
+	// Header, if set, matches requests that include the named header.
+	Header string
+	// HeaderValue, if set, additionally matches the header's value (case-insensitive).
+	HeaderValue string `yaml:"header_value"`
+}
+
+func (rule *ClassificationRule) match(req *http.Request) bool {
+	if rule.Method != "" && rule.Method != req.Method {
+		return false
+	}
+	if rule.PathPrefix != "" && !strings.HasPrefix(req.URL.Path, rule.PathPrefix) {
+		return false
+	}
+	if rule.Header != "" {
+		value, ok := req.Header[http.CanonicalHeaderKey(rule.Header)]
+		if !ok {
+			return false
+		}
+		if rule.HeaderValue != "" && (len(value) == 0 || !strings.EqualFold(value[0], rule.HeaderValue)) {
+			return false
+		}
+	}
+	return true
+}
+
+type classifier struct {
+	rules        []ClassificationRule
+	defaultClass string
+}
+
+func newClassifierHandler(rules []ClassificationRule, defaultClass string) http.Handler {
+	return &classifier{
+		rules:        rules,
+		defaultClass: defaultClass,
+	}
+}
+
+func (c *classifier) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	class := c.defaultClass
+	for i := range c.rules {
+		if c.rules[i].match(req) {
+			class = c.rules[i].Class
+			break
+		}
+	}
+
+	// Add the request class to handler context so that downstream handlers can access
+	if d := contextHandlerDetails(req.Context()); d != nil {
+		d.requestClass = class
+	}
+}
@@ -0,0 +1,45 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var classificationRules = []ClassificationRule{
+	{Class: RequestClassHealth, Method: "GET", PathPrefix: "/metrics"},
+	{Class: RequestClassBatch, Header: "X-Batch"},
+	{Class: RequestClassBatch, PathPrefix: "/reports", Header: HeaderUserAgent, HeaderValue: "exporter"},
+}
+
+func classify(req *http.Request) string {
+	req = req.WithContext(withHandlerDetails(req.Context(), &handlerDetails{}))
+	c := newClassifierHandler(classificationRules, RequestClassInteractive)
+	c.ServeHTTP(httptest.NewRecorder(), req)
+	return ContextRequestClass(req.Context())
+}
+
+func TestRequestClassification(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	if class := classify(req); class != RequestClassHealth {
+		t.Errorf("incorrect request class: %s", class)
+	}
+
+	req, _ = http.NewRequest("POST", "/things", nil)
+	req.Header.Set("X-Batch", "1")
+	if class := classify(req); class != RequestClassBatch {
+		t.Errorf("incorrect request class: %s", class)
+	}
+
+	req, _ = http.NewRequest("GET", "/reports/daily", nil)
+	req.Header.Set(HeaderUserAgent, "Exporter")
+	if class := classify(req); class != RequestClassBatch {
+		t.Errorf("incorrect request class: %s", class)
+	}
+
+	req, _ = http.NewRequest("GET", "/reports/daily", nil)
+	req.Header.Set(HeaderUserAgent, "browser")
+	if class := classify(req); class != RequestClassInteractive {
+		t.Errorf("default request class not assigned: %s", class)
+	}
+}
@@ -0,0 +1,199 @@
+package luddite
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gopkg.in/SpirentOrion/trace.v2"
+)
+
+const (
+	defaultHTTPClientName                = "default"
+	defaultHTTPClientTimeout             = 30 * time.Second
+	defaultHTTPClientDialTimeout         = 10 * time.Second
+	defaultHTTPClientTLSHandshakeTimeout = 10 * time.Second
+	defaultHTTPClientIdleConnTimeout     = 90 * time.Second
+	defaultHTTPClientMaxIdleConns        = 100
+	defaultHTTPClientMaxIdleConnsPerHost = 10
+	defaultHTTPClientHedgeDelay          = 100 * time.Millisecond
+)
+
+// HTTPClientConfig holds the config values for an outbound HTTP client. Zero
+// values fall back to the "default" client's config and then to built-in
+// defaults.
+type HTTPClientConfig struct {
+	// Timeout limits the total time taken by a request, including reading the response body. Defaults to 30s.
+	Timeout time.Duration
+	// DialTimeout limits the time spent establishing a connection. Defaults to 10s.
+	DialTimeout time.Duration `yaml:"dial_timeout"`
+	// TLSHandshakeTimeout limits the time spent performing a TLS handshake. Defaults to 10s.
+	TLSHandshakeTimeout time.Duration `yaml:"tls_handshake_timeout"`
+	// ResponseHeaderTimeout limits the time spent waiting for response headers. Defaults to no limit other than Timeout.
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"`
+	// IdleConnTimeout sets how long idle pooled connections are kept. Defaults to 90s.
+	IdleConnTimeout time.Duration `yaml:"idle_conn_timeout"`
+	// MaxIdleConns sets the maximum number of idle pooled connections across all hosts. Defaults to 100.
+	MaxIdleConns int `yaml:"max_idle_conns"`
+	// MaxIdleConnsPerHost sets the maximum number of idle pooled connections per host. Defaults to 10.
+	MaxIdleConnsPerHost int `yaml:"max_idle_conns_per_host"`
+	// HedgeDelay sets how long Service.HedgedDo waits for a response before sending a second attempt. Defaults to 100ms.
+	HedgeDelay time.Duration `yaml:"hedge_delay"`
+}
+
+func (c *HTTPClientConfig) merge(defaults *HTTPClientConfig) {
+	if c.Timeout == 0 {
+		c.Timeout = defaults.Timeout
+	}
+	if c.DialTimeout == 0 {
+		c.DialTimeout = defaults.DialTimeout
+	}
+	if c.TLSHandshakeTimeout == 0 {
+		c.TLSHandshakeTimeout = defaults.TLSHandshakeTimeout
+	}
+	if c.ResponseHeaderTimeout == 0 {
+		c.ResponseHeaderTimeout = defaults.ResponseHeaderTimeout
+	}
+	if c.IdleConnTimeout == 0 {
+		c.IdleConnTimeout = defaults.IdleConnTimeout
+	}
+	if c.MaxIdleConns == 0 {
+		c.MaxIdleConns = defaults.MaxIdleConns
+	}
+	if c.MaxIdleConnsPerHost == 0 {
+		c.MaxIdleConnsPerHost = defaults.MaxIdleConnsPerHost
+	}
+	if c.HedgeDelay == 0 {
+		c.HedgeDelay = defaults.HedgeDelay
+	}
+}
+
+var builtinHTTPClientConfig = HTTPClientConfig{
+	Timeout:             defaultHTTPClientTimeout,
+	DialTimeout:         defaultHTTPClientDialTimeout,
+	TLSHandshakeTimeout: defaultHTTPClientTLSHandshakeTimeout,
+	IdleConnTimeout:     defaultHTTPClientIdleConnTimeout,
+	MaxIdleConns:        defaultHTTPClientMaxIdleConns,
+	MaxIdleConnsPerHost: defaultHTTPClientMaxIdleConnsPerHost,
+	HedgeDelay:          defaultHTTPClientHedgeDelay,
+}
+
+// HTTPClient returns a named, instrumented HTTP client for outbound requests.
+// Clients are created on first use and shared thereafter. Their timeouts and
+// connection pools are configured from the service config's HTTPClients map.
+//
+// Requests made with a context derived from an inbound request's context
+// propagate the X-Request-Id and X-Session-Id headers, are traced, and are
+// counted in the service's metrics.
+func (s *Service) HTTPClient(name string) *http.Client {
+	if name == "" {
+		name = defaultHTTPClientName
+	}
+
+	s.httpClientsMu.Lock()
+	defer s.httpClientsMu.Unlock()
+
+	if c, ok := s.httpClients[name]; ok {
+		return c
+	}
+
+	config := s.httpClientConfig(name)
+	c := &http.Client{
+		Transport: &clientTransport{
+			name: name,
+			base: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				DialContext: (&net.Dialer{
+					Timeout:   config.DialTimeout,
+					KeepAlive: 30 * time.Second,
+				}).DialContext,
+				TLSHandshakeTimeout:   config.TLSHandshakeTimeout,
+				ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+				IdleConnTimeout:       config.IdleConnTimeout,
+				MaxIdleConns:          config.MaxIdleConns,
+				MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+				ExpectContinueTimeout: time.Second,
+			},
+		},
+		Timeout: config.Timeout,
+	}
+	if s.httpClients == nil {
+		s.httpClients = make(map[string]*http.Client)
+	}
+	s.httpClients[name] = c
+	return c
+}
+
+// httpClientConfig returns a named client's config, merged with the defaults.
+func (s *Service) httpClientConfig(name string) HTTPClientConfig {
+	config := s.config.HTTPClients[name]
+	defaults := s.config.HTTPClients[defaultHTTPClientName]
+	config.merge(&defaults)
+	config.merge(&builtinHTTPClientConfig)
+	return config
+}
+
+type clientTransport struct {
+	name string
+	base http.RoundTripper
+}
+
+func (t *clientTransport) RoundTrip(req *http.Request) (res *http.Response, err error) {
+	ctx := req.Context()
+	start := time.Now()
+	trace.Do(ctx, TraceKindHTTP, req.Method+" "+req.URL.Host, func(ctx context.Context) {
+		req = propagateIds(ctx, req)
+		res, err = t.base.RoundTrip(req)
+
+		if data := trace.Annotate(ctx); data != nil {
+			data["client"] = t.name
+			data["request_method"] = req.Method
+			data["url"] = req.URL.String()
+			if err != nil {
+				data["error"] = err.Error()
+			} else {
+				data["response_status"] = res.StatusCode
+			}
+		}
+	})
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(res.StatusCode)
+	}
+	httpClientRequests.WithLabelValues(t.name, req.Method, code).Inc()
+	httpClientDuration.WithLabelValues(t.name, req.Method).Observe(time.Since(start).Seconds())
+	return
+}
+
+// propagateIds adds the request and session ids of a request's context to an
+// outbound request. The request id is sent in the form "traceId:spanId", where
+// the span is the outbound request's own, so that the downstream service's
+// trace is parented by it. Without a span only the trace id is sent, for log
+// correlation. A RoundTripper must not modify the caller's request
+// so headers are changed on a copy.
+func propagateIds(ctx context.Context, req *http.Request) *http.Request {
+	requestId := ContextRequestId(ctx)
+	if span := trace.CurrentSpan(ctx); requestId != "" && span != nil {
+		requestId += ":" + strconv.FormatInt(span.Id, 10)
+	}
+	sessionId := ContextSessionId(ctx)
+	if (requestId == "" || req.Header.Get(HeaderRequestId) != "") && (sessionId == "" || req.Header.Get(HeaderSessionId) != "") {
+		return req
+	}
+	req = req.WithContext(req.Context())
+	header := make(http.Header, len(req.Header)+2)
+	for k, v := range req.Header {
+		header[k] = v
+	}
+	if requestId != "" && header.Get(HeaderRequestId) == "" {
+		header.Set(HeaderRequestId, requestId)
+	}
+	if sessionId != "" && header.Get(HeaderSessionId) == "" {
+		header.Set(HeaderSessionId, sessionId)
+	}
+	req.Header = header
+	return req
+}
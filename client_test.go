@@ -0,0 +1,85 @@
+package luddite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/SpirentOrion/trace.v2"
+)
+
+func TestHTTPClientConfig(t *testing.T) {
+	config := &ServiceConfig{
+		HTTPClients: map[string]HTTPClientConfig{
+			"default":  {Timeout: 5 * time.Second},
+			"upstream": {DialTimeout: time.Second},
+		},
+	}
+	s := &Service{config: config}
+
+	c := s.HTTPClient("upstream")
+	if c.Timeout != 5*time.Second {
+		t.Errorf("default client timeout not inherited: %s", c.Timeout)
+	}
+	if s.HTTPClient("upstream") != c {
+		t.Error("named client not reused")
+	}
+	if c = s.HTTPClient("other"); c.Timeout != 5*time.Second {
+		t.Errorf("default client timeout not applied: %s", c.Timeout)
+	}
+	if c = (&Service{config: new(ServiceConfig)}).HTTPClient(""); c.Timeout != defaultHTTPClientTimeout {
+		t.Errorf("built-in client timeout not applied: %s", c.Timeout)
+	}
+}
+
+func TestHTTPClientPropagation(t *testing.T) {
+	var requestId, sessionId string
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		requestId = req.Header.Get(HeaderRequestId)
+		sessionId = req.Header.Get(HeaderSessionId)
+	}))
+	defer upstream.Close()
+
+	s := &Service{config: new(ServiceConfig)}
+	req0, _ := http.NewRequest("GET", "/", nil)
+	req0.Header.Set(HeaderSessionId, "session")
+	ctx := withHandlerDetails(req0.Context(), &handlerDetails{s: s, request: req0, requestId: "1234"})
+
+	req1, _ := http.NewRequest("GET", upstream.URL, nil)
+	res, err := s.HTTPClient("upstream").Do(req1.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if parts := strings.Split(requestId, ":"); len(parts) != 2 || parts[0] != "1234" || parts[1] == "" {
+		t.Errorf("request id not propagated as traceId:spanId: %q", requestId)
+	}
+	if sessionId != "session" {
+		t.Errorf("session id not propagated: %q", sessionId)
+	}
+	if req1.Header.Get(HeaderRequestId) != "" {
+		t.Error("caller's request was modified")
+	}
+}
+
+func TestPropagatedSpanId(t *testing.T) {
+	s := &Service{config: new(ServiceConfig)}
+	req0, _ := http.NewRequest("GET", "/", nil)
+	ctx := withHandlerDetails(req0.Context(), &handlerDetails{s: s, request: req0, requestId: "1234"})
+	req1, _ := http.NewRequest("GET", "http://upstream/", nil)
+	trace.Do(ctx, TraceKindHTTP, "GET upstream", func(ctx context.Context) {
+		span := trace.CurrentSpan(ctx)
+		if span == nil {
+			t.Skip("request isn't traced")
+		}
+		expected := "1234:" + strconv.FormatInt(span.Id, 10)
+		if requestId := propagateIds(ctx, req1).Header.Get(HeaderRequestId); requestId != expected {
+			t.Errorf("expected request id %q, got: %q", expected, requestId)
+		}
+	})
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+type genOptions struct {
+	typeName string
+	dir      string
+	idField  string
+	basePath string
+	force    bool
+}
+
+type genField struct {
+	Name     string
+	JsonName string
+	Expr     ast.Expr
+}
+
+type genData struct {
+	Package     string
+	Type        string
+	Resource    string
+	Constructor string
+	BasePath    string
+	IdField     string
+	IdType      string
+	IdKind      string // string | int | uint
+	SampleId    string
+}
+
+func genResource(opts *genOptions) ([]string, error) {
+	pkgName, st, err := findStruct(opts.dir, opts.typeName)
+	if err != nil {
+		return nil, err
+	}
+	fields := structFields(st)
+
+	data := &genData{
+		Package:     pkgName,
+		Type:        opts.typeName,
+		Resource:    lowerFirst(opts.typeName) + "Resource",
+		Constructor: "new" + opts.typeName + "Resource",
+		BasePath:    opts.basePath,
+	}
+	if data.BasePath == "" {
+		data.BasePath = "/" + plural(snakeCase(opts.typeName))
+	}
+	if err = data.setId(fields, opts.idField); err != nil {
+		return nil, err
+	}
+
+	base := filepath.Join(opts.dir, snakeCase(opts.typeName))
+	outputs := []struct {
+		path  string
+		tmpl  *template.Template
+		gofmt bool
+	}{
+		{base + "_resource.go", resourceTemplate, true},
+		{base + "_resource_test.go", resourceTestTemplate, true},
+		{base + ".schema.yaml", nil, false},
+	}
+
+	var written []string
+	for _, out := range outputs {
+		if !opts.force {
+			if _, err = os.Stat(out.path); err == nil {
+				return written, fmt.Errorf("%s already exists (use -f to overwrite)", out.path)
+			}
+		}
+
+		var buf bytes.Buffer
+		if out.tmpl != nil {
+			if err = out.tmpl.Execute(&buf, data); err != nil {
+				return written, err
+			}
+		} else {
+			writeSchema(&buf, opts.typeName, fields)
+		}
+
+		b := buf.Bytes()
+		if out.gofmt {
+			if b, err = format.Source(b); err != nil {
+				return written, fmt.Errorf("formatting %s: %v", out.path, err)
+			}
+		}
+		if err = ioutil.WriteFile(out.path, b, 0666); err != nil {
+			return written, err
+		}
+		written = append(written, out.path)
+	}
+	return written, nil
+}
+
+// findStruct locates a struct type declaration in the non-test Go files of dir.
+func findStruct(dir, typeName string) (string, *ast.StructType, error) {
+	fset := token.NewFileSet()
+	filter := func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}
+	pkgs, err := parser.ParseDir(fset, dir, filter, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	for name, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			if obj := f.Scope.Lookup(typeName); obj != nil && obj.Kind == ast.Typ {
+				if spec, ok := obj.Decl.(*ast.TypeSpec); ok {
+					if st, ok := spec.Type.(*ast.StructType); ok {
+						return name, st, nil
+					}
+					return "", nil, fmt.Errorf("%s is not a struct type", typeName)
+				}
+			}
+		}
+	}
+	return "", nil, fmt.Errorf("type %s not found in %s", typeName, dir)
+}
+
+func structFields(st *ast.StructType) []genField {
+	var fields []genField
+	for _, f := range st.Fields.List {
+		var tag reflect.StructTag
+		if f.Tag != nil {
+			s, _ := strconv.Unquote(f.Tag.Value)
+			tag = reflect.StructTag(s)
+		}
+		for _, name := range f.Names {
+			if !name.IsExported() || name.Name == "XMLName" {
+				continue
+			}
+			jsonName := strings.Split(tag.Get("json"), ",")[0]
+			if jsonName == "-" {
+				continue
+			}
+			if jsonName == "" {
+				jsonName = name.Name
+			}
+			fields = append(fields, genField{Name: name.Name, JsonName: jsonName, Expr: f.Type})
+		}
+	}
+	return fields
+}
+
+func (data *genData) setId(fields []genField, idField string) error {
+	candidates := []string{idField}
+	if idField == "" {
+		candidates = []string{"Id", "ID", "Name"}
+	}
+	for _, c := range candidates {
+		for _, f := range fields {
+			if f.Name != c {
+				continue
+			}
+			ident, ok := f.Expr.(*ast.Ident)
+			if !ok {
+				return fmt.Errorf("id field %s must have a string or integer type", c)
+			}
+			data.IdField = f.Name
+			data.IdType = ident.Name
+			switch ident.Name {
+			case "string":
+				data.IdKind = "string"
+				data.SampleId = `"test"`
+			case "int", "int8", "int16", "int32", "int64":
+				data.IdKind = "int"
+				data.SampleId = "1"
+			case "uint", "uint8", "uint16", "uint32", "uint64":
+				data.IdKind = "uint"
+				data.SampleId = "1"
+			default:
+				return fmt.Errorf("id field %s must have a string or integer type", c)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("no id field found in %s (use -id to name one)", data.Type)
+}
+
+func writeSchema(buf *bytes.Buffer, typeName string, fields []genField) {
+	fmt.Fprintf(buf, "# Schema snippet for %s; merge into the service's schema definitions.\n", typeName)
+	fmt.Fprintf(buf, "%s:\n  type: object\n  properties:\n", typeName)
+	for _, f := range fields {
+		fmt.Fprintf(buf, "    %s:\n", f.JsonName)
+		writeSchemaType(buf, f.Expr, "      ")
+	}
+}
+
+func writeSchemaType(buf *bytes.Buffer, expr ast.Expr, indent string) {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		writeSchemaType(buf, t.X, indent)
+		return
+	case *ast.ArrayType:
+		if ident, ok := t.Elt.(*ast.Ident); ok && ident.Name == "byte" {
+			fmt.Fprintf(buf, "%stype: string\n%sformat: byte\n", indent, indent)
+			return
+		}
+		fmt.Fprintf(buf, "%stype: array\n%sitems:\n", indent, indent)
+		writeSchemaType(buf, t.Elt, indent+"  ")
+		return
+	case *ast.SelectorExpr:
+		if pkg, ok := t.X.(*ast.Ident); ok && pkg.Name == "time" && t.Sel.Name == "Time" {
+			fmt.Fprintf(buf, "%stype: string\n%sformat: date-time\n", indent, indent)
+			return
+		}
+	case *ast.Ident:
+		switch t.Name {
+		case "string":
+			fmt.Fprintf(buf, "%stype: string\n", indent)
+			return
+		case "bool":
+			fmt.Fprintf(buf, "%stype: boolean\n", indent)
+			return
+		case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64":
+			fmt.Fprintf(buf, "%stype: integer\n", indent)
+			return
+		case "float32", "float64":
+			fmt.Fprintf(buf, "%stype: number\n", indent)
+			return
+		}
+	}
+	fmt.Fprintf(buf, "%stype: object\n", indent)
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
+
+func snakeCase(s string) string {
+	var b strings.Builder
+	r := []rune(s)
+	for i, c := range r {
+		if unicode.IsUpper(c) {
+			if i > 0 && (unicode.IsLower(r[i-1]) || (i+1 < len(r) && unicode.IsLower(r[i+1]))) {
+				b.WriteByte('_')
+			}
+			c = unicode.ToLower(c)
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func plural(s string) string {
+	switch {
+	case strings.HasSuffix(s, "s"), strings.HasSuffix(s, "x"), strings.HasSuffix(s, "ch"), strings.HasSuffix(s, "sh"):
+		return s + "es"
+	case strings.HasSuffix(s, "y") && len(s) > 1 && !strings.ContainsAny(s[len(s)-2:len(s)-1], "aeiou"):
+		return s[:len(s)-1] + "ies"
+	default:
+		return s + "s"
+	}
+}
+
+var resourceTemplate = template.Must(template.New("resource").Parse(`// Generated by "luddite gen resource -type {{.Type}}". This is a skeleton:
+// replace the in-memory storage and fill in the validation rules as needed.
+
+package {{.Package}}
+
+import (
+	"net/http"
+{{- if ne .IdKind "string"}}
+	"strconv"
+{{- end}}
+	"sync"
+
+	"github.com/SpirentOrion/luddite.v2"
+)
+
+// {{.Resource}} is a collection resource for {{.Type}} values. Register it with:
+//
+//	s.AddResource(1, "{{.BasePath}}", {{.Constructor}}())
+type {{.Resource}} struct {
+	sync.RWMutex
+	items map[{{.IdType}}]*{{.Type}}
+}
+
+func {{.Constructor}}() *{{.Resource}} {
+	return &{{.Resource}}{items: make(map[{{.IdType}}]*{{.Type}})}
+}
+
+func (r *{{.Resource}}) New() interface{} {
+	return &{{.Type}}{}
+}
+
+func (r *{{.Resource}}) Id(value interface{}) string {
+	v := value.(*{{.Type}})
+{{- if eq .IdKind "int"}}
+	return strconv.FormatInt(int64(v.{{.IdField}}), 10)
+{{- else if eq .IdKind "uint"}}
+	return strconv.FormatUint(uint64(v.{{.IdField}}), 10)
+{{- else}}
+	return v.{{.IdField}}
+{{- end}}
+}
+
+func (r *{{.Resource}}) parseId(id string) ({{.IdType}}, bool) {
+{{- if eq .IdKind "int"}}
+	n, err := strconv.ParseInt(id, 10, 64)
+	return {{.IdType}}(n), err == nil
+{{- else if eq .IdKind "uint"}}
+	n, err := strconv.ParseUint(id, 10, 64)
+	return {{.IdType}}(n), err == nil
+{{- else}}
+	return id, id != ""
+{{- end}}
+}
+
+// validate checks a {{.Type}} value before it is stored. Return an error such
+// as luddite.NewError(nil, luddite.EcodeValidationFailed, "reason") to reject it.
+func (r *{{.Resource}}) validate(req *http.Request, v *{{.Type}}) *luddite.Error {
+	// TODO: add validation rules
+	return nil
+}
+
+func (r *{{.Resource}}) List(req *http.Request) (int, interface{}) {
+	r.RLock()
+	defer r.RUnlock()
+
+	vs := make([]*{{.Type}}, 0, len(r.items))
+	for _, v := range r.items {
+		vs = append(vs, v)
+	}
+	return http.StatusOK, vs
+}
+
+func (r *{{.Resource}}) Count(req *http.Request) (int, interface{}) {
+	r.RLock()
+	defer r.RUnlock()
+
+	return http.StatusOK, len(r.items)
+}
+
+func (r *{{.Resource}}) Get(req *http.Request, id string) (int, interface{}) {
+	key, ok := r.parseId(id)
+	if !ok {
+		return http.StatusNotFound, nil
+	}
+
+	r.RLock()
+	defer r.RUnlock()
+
+	v, ok := r.items[key]
+	if !ok {
+		return http.StatusNotFound, nil
+	}
+	return http.StatusOK, v
+}
+
+func (r *{{.Resource}}) Create(req *http.Request, value interface{}) (int, interface{}) {
+	v := value.(*{{.Type}})
+	if err := r.validate(req, v); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if _, exists := r.items[v.{{.IdField}}]; exists {
+		return http.StatusConflict, nil
+	}
+	r.items[v.{{.IdField}}] = v
+	return http.StatusCreated, v
+}
+
+func (r *{{.Resource}}) Update(req *http.Request, id string, value interface{}) (int, interface{}) {
+	key, ok := r.parseId(id)
+	if !ok {
+		return http.StatusNotFound, nil
+	}
+	v := value.(*{{.Type}})
+	if err := r.validate(req, v); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if _, exists := r.items[key]; !exists {
+		return http.StatusNotFound, nil
+	}
+	r.items[key] = v
+	return http.StatusOK, v
+}
+
+func (r *{{.Resource}}) Delete(req *http.Request, id string) (int, interface{}) {
+	r.Lock()
+	defer r.Unlock()
+
+	if id == "" {
+		r.items = make(map[{{.IdType}}]*{{.Type}})
+		return http.StatusNoContent, nil
+	}
+	key, ok := r.parseId(id)
+	if !ok {
+		return http.StatusNotFound, nil
+	}
+	delete(r.items, key)
+	return http.StatusNoContent, nil
+}
+`))
+
+var resourceTestTemplate = template.Must(template.New("resource_test").Parse(`// Generated by "luddite gen resource -type {{.Type}}". This is a skeleton:
+// extend it as the resource grows.
+
+package {{.Package}}
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test{{.Type}}Resource(t *testing.T) {
+	r := {{.Constructor}}()
+
+	v := r.New().(*{{.Type}})
+	v.{{.IdField}} = {{.SampleId}}
+	req := httptest.NewRequest("POST", "{{.BasePath}}", nil)
+	if status, _ := r.Create(req, v); status != http.StatusCreated {
+		t.Fatalf("unexpected create status: %d", status)
+	}
+
+	id := r.Id(v)
+	req = httptest.NewRequest("GET", "{{.BasePath}}/"+id, nil)
+	if status, _ := r.Get(req, id); status != http.StatusOK {
+		t.Errorf("unexpected get status: %d", status)
+	}
+
+	req = httptest.NewRequest("DELETE", "{{.BasePath}}/"+id, nil)
+	if status, _ := r.Delete(req, id); status != http.StatusNoContent {
+		t.Errorf("unexpected delete status: %d", status)
+	}
+	if status, _ := r.Get(req, id); status != http.StatusNotFound {
+		t.Errorf("deleted element still present: %d", status)
+	}
+}
+`))
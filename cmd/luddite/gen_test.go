@@ -0,0 +1,102 @@
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const genSource = `package things
+
+import "time"
+
+type GadgetPart struct {
+	Id      int64     ` + "`json:\"id\"`" + `
+	Label   string    ` + "`json:\"label\"`" + `
+	Created time.Time ` + "`json:\"created\"`" + `
+	Tags    []string  ` + "`json:\"tags,omitempty\"`" + `
+}
+`
+
+// dirImporter imports packages from source as seen from dir, so that the
+// generated code resolves luddite through this module rather than through the
+// temporary directory it was written to.
+type dirImporter struct {
+	from types.ImporterFrom
+	dir  string
+}
+
+func (i *dirImporter) Import(path string) (*types.Package, error) {
+	return i.ImportFrom(path, i.dir, 0)
+}
+
+func (i *dirImporter) ImportFrom(path, _ string, mode types.ImportMode) (*types.Package, error) {
+	return i.from.ImportFrom(path, i.dir, mode)
+}
+
+func TestGenResource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "luddite-gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "gadget_part.go"), []byte(genSource), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &genOptions{typeName: "GadgetPart", dir: dir}
+	files, err := genResource(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("expected 3 generated files, got: %v", files)
+	}
+
+	// Type-check the generated files along with the source type
+	fset := token.NewFileSet()
+	var parsed []*ast.File
+	for _, f := range append([]string{filepath.Join(dir, "gadget_part.go")}, files[:2]...) {
+		pf, err := parser.ParseFile(fset, f, nil, 0)
+		if err != nil {
+			t.Fatalf("generated file doesn't parse: %v", err)
+		}
+		parsed = append(parsed, pf)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := types.Config{Importer: &dirImporter{importer.ForCompiler(fset, "source", nil).(types.ImporterFrom), wd}}
+	if _, err = conf.Check("things", fset, parsed, nil); err != nil {
+		t.Errorf("generated files don't type-check: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `s.AddResource(1, "/gadget_parts", newGadgetPartResource())`) {
+		t.Error("unexpected base path or constructor in generated resource")
+	}
+
+	b, err = ioutil.ReadFile(files[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "created:\n      type: string\n      format: date-time") {
+		t.Errorf("unexpected schema snippet:\n%s", b)
+	}
+
+	if _, err = genResource(opts); err == nil {
+		t.Error("existing files were overwritten without -f")
+	}
+}
@@ -0,0 +1,55 @@
+// Command luddite provides development tooling for luddite services.
+//
+// Usage:
+//
+//	luddite gen resource -type User [-dir .] [-id Name] [-path /users] [-f]
+//
+// The "gen resource" command reads the named struct type from the Go package
+// in dir and emits a skeleton collection resource (interface methods and
+// validation stubs), a test file, and a schema snippet for the type. It may
+// also be invoked via go:generate:
+//
+//	//go:generate luddite gen resource -type User
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s gen resource -type TypeName [-dir dir] [-id FieldName] [-path /base/path] [-f]\n", os.Args[0])
+}
+
+func main() {
+	if len(os.Args) < 3 || os.Args[1] != "gen" || os.Args[2] != "resource" {
+		usage()
+		os.Exit(2)
+	}
+
+	var opts genOptions
+	fs := flag.NewFlagSet("gen resource", flag.ExitOnError)
+	fs.StringVar(&opts.typeName, "type", "", "Name of the struct type to generate a resource for")
+	fs.StringVar(&opts.dir, "dir", ".", "Directory containing the Go package that declares the type")
+	fs.StringVar(&opts.idField, "id", "", "Name of the field holding the resource id (defaults to Id, ID or Name)")
+	fs.StringVar(&opts.basePath, "path", "", "Base path of the resource routes (defaults to the pluralized type name)")
+	fs.BoolVar(&opts.force, "f", false, "Overwrite existing files")
+	fs.Usage = usage
+	if err := fs.Parse(os.Args[3:]); err != nil {
+		os.Exit(2)
+	}
+	if opts.typeName == "" {
+		usage()
+		os.Exit(2)
+	}
+
+	files, err := genResource(&opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		os.Exit(1)
+	}
+	for _, f := range files {
+		fmt.Println(f)
+	}
+}
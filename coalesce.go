@@ -0,0 +1,161 @@
+package luddite
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// coalescedCall is an in-flight GET whose response is shared by identical
+// concurrent requests.
+type coalescedCall struct {
+	done   chan struct{}
+	header http.Header
+	status int
+	body   []byte
+	dups   int
+}
+
+// coalescer serves identical concurrent GETs from a single handler execution.
+// Requests are identical when they share an API version, URL path and query,
+// negotiated content type, representation headers (e.g. page size and
+// rendering preferences), and principal (the context principal along with
+// Authorization and Cookie headers).
+type coalescer struct {
+	pathPrefixes []string
+	mu           sync.Mutex
+	calls        map[string]*coalescedCall
+}
+
+func newCoalescer(pathPrefixes []string) *coalescer {
+	return &coalescer{
+		pathPrefixes: pathPrefixes,
+		calls:        make(map[string]*coalescedCall),
+	}
+}
+
+func (c *coalescer) match(req *http.Request) bool {
+	if req.Method != "GET" {
+		return false
+	}
+	if len(c.pathPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range c.pathPrefixes {
+		if strings.HasPrefix(req.URL.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// representationHeaders lists the request headers, other than those used for
+// content negotiation, that select a response's representation.
+var representationHeaders = []string{
+	HeaderSpirentPageSize,
+	HeaderAcceptLanguage,
+	HeaderSpirentTimeZone,
+	HeaderSpirentUnits,
+	HeaderPrefer,
+}
+
+// requestKey identifies requests that select the same response: those that
+// share an API version, URL path and query, negotiated content type,
+// representation headers, and principal.
+func requestKey(res *responseWriter, req *http.Request, apiVersion int) string {
+	var principal string
+	if p := ContextPrincipal(req.Context()); p != nil {
+		principal = p.Id
+	}
+	h := sha256.New()
+	for _, s := range []string{
+		strconv.Itoa(apiVersion),
+		req.URL.Path,
+		req.URL.RawQuery,
+		res.Header().Get(HeaderContentType),
+		req.Header.Get(HeaderAuthorization),
+		req.Header.Get("Cookie"),
+		principal,
+	} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	for _, name := range representationHeaders {
+		for _, v := range req.Header.Values(name) {
+			h.Write([]byte(v))
+			h.Write([]byte{1})
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// serve dispatches a request to the router unless an identical request is
+// already in flight, in which case it waits for and copies that request's
+// response.
+func (c *coalescer) serve(res *responseWriter, req *http.Request, apiVersion int, router http.Handler) {
+	key := requestKey(res, req, apiVersion)
+
+	c.mu.Lock()
+	if call, ok := c.calls[key]; ok {
+		call.dups++
+		c.mu.Unlock()
+		SetContextRequestProgress(req.Context(), "luddite.coalescer.wait")
+		select {
+		case <-call.done:
+		case <-req.Context().Done():
+			panic(context.Canceled)
+		}
+		if call.status == 0 {
+			// The original request failed without a response: run this one
+			router.ServeHTTP(res, req)
+			return
+		}
+		header := res.Header()
+		for k, v := range call.header {
+			if k != HeaderRequestId {
+				header[k] = append([]string(nil), v...)
+			}
+		}
+		res.WriteHeader(call.status)
+		_, _ = res.Write(call.body)
+		return
+	}
+	call := &coalescedCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	capture := new(bytes.Buffer)
+	prevCapture := res.capture
+	if prevCapture != nil {
+		res.capture = io.MultiWriter(prevCapture, capture)
+	} else {
+		res.capture = capture
+	}
+	completed := false
+	defer func() {
+		res.capture = prevCapture
+		// Partial responses (e.g. when this request's client went away or
+		// the resource panicked mid-response) aren't shared
+		if completed && res.Written() && !res.clientAborted() && !res.truncated() {
+			call.header = make(http.Header, len(res.Header()))
+			for k, v := range res.Header() {
+				call.header[k] = append([]string(nil), v...)
+			}
+			call.status = res.Status()
+			call.body = capture.Bytes()
+		}
+		c.mu.Lock()
+		delete(c.calls, key)
+		c.mu.Unlock()
+		close(call.done)
+	}()
+	router.ServeHTTP(res, req)
+	completed = true
+}
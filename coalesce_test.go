@@ -0,0 +1,102 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type slowResource struct {
+	calls   int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *slowResource) New() interface{}            { return &versionedSample{} }
+func (r *slowResource) Id(value interface{}) string { return value.(*versionedSample).Id }
+
+func (r *slowResource) Get(req *http.Request, id string) (int, interface{}) {
+	if atomic.AddInt32(&r.calls, 1) == 1 {
+		close(r.started)
+	}
+	<-r.release
+	return http.StatusOK, &versionedSample{Id: id}
+}
+
+func TestCoalescing(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Coalescing.Enabled = true
+	})
+	r := &slowResource{started: make(chan struct{}), release: make(chan struct{})}
+	mustAddResource(t, s, 1, "/samples", r)
+
+	const n = 4
+	var (
+		wg  sync.WaitGroup
+		rws [n]*httptest.ResponseRecorder
+		get = func(i int) {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "/samples/a", nil)
+			req.Header.Set(HeaderAccept, ContentTypeJson)
+			rws[i] = httptest.NewRecorder()
+			s.ServeHTTP(rws[i], req)
+		}
+		dups = func() int {
+			s.coalescer.mu.Lock()
+			defer s.coalescer.mu.Unlock()
+			for _, call := range s.coalescer.calls {
+				return call.dups
+			}
+			return 0
+		}
+	)
+	wg.Add(n)
+	go get(0)
+	<-r.started
+	for i := 1; i < n; i++ {
+		go get(i)
+	}
+	for start := time.Now(); dups() < n-1; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("requests not coalesced")
+		}
+	}
+	close(r.release)
+	wg.Wait()
+
+	if calls := atomic.LoadInt32(&r.calls); calls != 1 {
+		t.Errorf("expected 1 handler execution, got: %d", calls)
+	}
+	for i, rw := range rws {
+		if rw.Code != http.StatusOK || rw.Body.String() != rws[0].Body.String() {
+			t.Errorf("request %d: unexpected response: %d %s", i, rw.Code, rw.Body.String())
+		}
+		if rw.Header().Get(HeaderRequestId) == rws[0].Header().Get(HeaderRequestId) && i != 0 {
+			t.Errorf("request %d: request id copied from coalesced request", i)
+		}
+	}
+}
+
+func TestRequestKeyRepresentationHeaders(t *testing.T) {
+	res := &responseWriter{}
+	res.init(httptest.NewRecorder())
+	key := func(name, value string) string {
+		req, _ := http.NewRequest("GET", "/samples", nil)
+		if name != "" {
+			req.Header.Set(name, value)
+		}
+		return requestKey(res, req, 1)
+	}
+	base := key("", "")
+	for _, name := range representationHeaders {
+		if key(name, "7") == base {
+			t.Errorf("%s: request key doesn't depend on header", name)
+		}
+	}
+	if key(HeaderSpirentUnits, UnitsMetric) == key(HeaderSpirentUnits, UnitsImperial) {
+		t.Error("requests with different preferences share a key")
+	}
+}
@@ -0,0 +1,202 @@
+package luddite
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CompressionMode controls whether a resource's responses are compressed.
+type CompressionMode int
+
+const (
+	// CompressionAuto compresses responses with compressible content types
+	// when compression is enabled in the service config.
+	CompressionAuto CompressionMode = iota
+	// CompressionOff never compresses a resource's responses.
+	CompressionOff
+	// CompressionForce compresses a resource's responses whatever their
+	// content type, even if compression isn't enabled in the service
+	// config. Responses with already-compressed content types are still
+	// sent as-is.
+	CompressionForce
+)
+
+var (
+	// compressibleContentTypes are compressed in CompressionAuto mode, along
+	// with text/* and any +json or +xml types.
+	compressibleContentTypes = []string{
+		ContentTypeCsv,
+		ContentTypeJson,
+		ContentTypeNdjson,
+		ContentTypeXml,
+		"application/javascript",
+	}
+
+	// compressedContentTypes are already compressed: compressing them again
+	// costs CPU for no gain.
+	compressedContentTypes = []string{
+		ContentTypeGif,
+		ContentTypePng,
+		"application/gzip",
+		"application/x-7z-compressed",
+		"application/x-bzip2",
+		"application/x-xz",
+		"application/zip",
+		"application/zstd",
+		"audio/*",
+		"font/woff",
+		"font/woff2",
+		"image/jpeg",
+		"image/webp",
+		"video/*",
+	}
+
+	// gzipWriterPools holds a pool per compression level, offset by one so
+	// that gzip.DefaultCompression (-1) maps to the first pool.
+	gzipWriterPools [gzip.BestCompression + 2]sync.Pool
+)
+
+// WithCompression sets whether a resource's responses are compressed,
+// overriding the service config.
+func WithCompression(mode CompressionMode) ResourceOption {
+	return func(o *resourceOptions) {
+		o.compression = mode
+		o.compressionSet = true
+	}
+}
+
+// WithCompressedContentTypes declares content types (or media ranges such as
+// "video/*") that a resource serves already compressed, so that they're never
+// compressed again.
+func WithCompressedContentTypes(contentTypes ...string) ResourceOption {
+	return func(o *resourceOptions) {
+		o.compressedTypes = append(o.compressedTypes, contentTypes...)
+	}
+}
+
+// responseCompression holds a response's compression settings.
+type responseCompression struct {
+	enabled         bool
+	accepted        bool
+	mode            CompressionMode
+	level           int
+	compressedTypes []string
+}
+
+// compressible reports whether a response should be compressed based on its
+// headers.
+func (c *responseCompression) compressible(header http.Header) bool {
+	if header.Get(HeaderContentEncoding) != "" {
+		return false
+	}
+	mt, err := ParseMediaType(header.Get(HeaderContentType))
+	if err != nil {
+		return c.mode == CompressionForce
+	}
+	if matchesContentType(mt, c.compressedTypes) || matchesContentType(mt, compressedContentTypes) {
+		return false
+	}
+	if c.mode == CompressionForce || mt.Type == "text" {
+		return true
+	}
+	switch mt.Suffix() {
+	case "json", "xml":
+		return true
+	}
+	return matchesContentType(mt, compressibleContentTypes)
+}
+
+func matchesContentType(mt MediaType, contentTypes []string) bool {
+	for _, ct := range contentTypes {
+		if r, err := ParseMediaType(ct); err == nil && mt.Matches(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsGzip reports whether a request's Accept-Encoding header allows gzip.
+func acceptsGzip(header http.Header) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, v := range header[HeaderAcceptEncoding] {
+		for _, coding := range strings.Split(v, ",") {
+			params := strings.Split(coding, ";")
+			name := strings.ToLower(strings.TrimSpace(params[0]))
+			if name != "gzip" && name != "*" {
+				continue
+			}
+			q := 1.0
+			for _, p := range params[1:] {
+				if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") {
+					q, _ = strconv.ParseFloat(p[2:], 64)
+				}
+			}
+			if name == "gzip" {
+				gzipQ = q
+			} else {
+				anyQ = q
+			}
+		}
+	}
+	// An explicit gzip coding takes precedence over the wildcard (RFC 9110
+	// section 12.5.3)
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+func (rr *registeredResource) declaresCompression() bool {
+	return rr.compressionSet
+}
+
+func (rr *registeredResource) declaresCompressedTypes() bool {
+	return len(rr.compressedTypes) != 0
+}
+
+// enableCompression applies the service's and the route's resource
+// compression settings to a response.
+func enableCompression(rw http.ResponseWriter, req *http.Request, pattern string) {
+	res, ok := rw.(*responseWriter)
+	d := contextHandlerDetails(req.Context())
+	if !ok || d == nil || d.s == nil {
+		return
+	}
+	// Each setting is resolved from the nearest resource that declares it,
+	// so that sub-resources inherit their parent's settings
+	rt := d.currentRouting()
+	mode := CompressionAuto
+	if rr := rt.matchResource(d.apiVersion, pattern, (*registeredResource).declaresCompression); rr != nil {
+		mode = rr.compression
+	}
+	var compressedTypes []string
+	if rr := rt.matchResource(d.apiVersion, pattern, (*registeredResource).declaresCompressedTypes); rr != nil {
+		compressedTypes = rr.compressedTypes
+	}
+	if mode == CompressionOff || (mode == CompressionAuto && !d.s.config.Compression.Enabled) {
+		return
+	}
+	res.compression = responseCompression{
+		enabled:         true,
+		accepted:        acceptsGzip(req.Header),
+		mode:            mode,
+		level:           d.s.config.Compression.Level,
+		compressedTypes: compressedTypes,
+	}
+}
+
+func getGzipWriter(rw http.ResponseWriter, level int) *gzip.Writer {
+	if gz, ok := gzipWriterPools[level+1].Get().(*gzip.Writer); ok {
+		gz.Reset(rw)
+		return gz
+	}
+	gz, _ := gzip.NewWriterLevel(rw, level)
+	return gz
+}
+
+func putGzipWriter(gz *gzip.Writer, level int) {
+	gzipWriterPools[level+1].Put(gz)
+}
@@ -0,0 +1,132 @@
+package luddite
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type blobResource struct {
+	contentType string
+	body        []byte
+}
+
+func (r *blobResource) Get(req *http.Request) (int, interface{}) {
+	ContextResponseWriter(req.Context()).Header().Set(HeaderContentType, r.contentType)
+	return http.StatusOK, r.body
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		accepts        bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"*", true},
+		{"br", false},
+		{"*;q=0", false},
+		{"br, *;q=0", false},
+		{"gzip;q=0, *", false},
+		{"*;q=0, gzip", true},
+		{"gzip;q=0.1, *;q=0", true},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.acceptEncoding != "" {
+			header.Set(HeaderAcceptEncoding, test.acceptEncoding)
+		}
+		if accepts := acceptsGzip(header); accepts != test.accepts {
+			t.Errorf("%q: expected %v, got %v", test.acceptEncoding, test.accepts, accepts)
+		}
+	}
+}
+
+func TestResponseCompression(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Compression.Enabled = true
+	})
+
+	body := bytes.Repeat([]byte("luddite "), 256)
+	resources := map[string]interface{}{
+		"/text":   &blobResource{ContentTypePlain, body},
+		"/custom": &blobResource{"application/x-custom", body},
+		"/forced": &blobResource{"application/x-custom", body},
+		"/off":    &blobResource{ContentTypePlain, body},
+		"/jpeg":   &blobResource{"image/jpeg", body},
+		"/zip":    &blobResource{"application/x-archive", body},
+		// Sub-resources inherit compression settings they don't declare
+		"/forced/sub":  &blobResource{"application/x-custom", body},
+		"/forced/auto": &blobResource{"application/x-custom", body},
+	}
+	opts := map[string][]ResourceOption{
+		"/forced":      {WithCompression(CompressionForce)},
+		"/off":         {WithCompression(CompressionOff)},
+		"/jpeg":        {WithCompression(CompressionForce)},
+		"/zip":         {WithCompression(CompressionForce), WithCompressedContentTypes("application/x-archive")},
+		"/forced/sub":  {WithNullPolicy(NullPolicyOmitNull)},
+		"/forced/auto": {WithCompression(CompressionAuto)},
+	}
+	for basePath, r := range resources {
+		if err := s.AddResource(1, basePath, r, opts[basePath]...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path       string
+		accept     string
+		compressed bool
+	}{
+		{"/text", "gzip", true},
+		{"/text", "", false},
+		{"/custom", "gzip", false},
+		{"/forced", "gzip", true},
+		{"/off", "gzip", false},
+		{"/jpeg", "gzip", false},
+		{"/zip", "gzip", false},
+		{"/forced/sub", "gzip", true},
+		{"/forced/auto", "gzip", false},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.path, nil)
+		if test.accept != "" {
+			req.Header.Set(HeaderAcceptEncoding, test.accept)
+		}
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, req)
+		if rw.Code != http.StatusOK {
+			t.Errorf("%s: expected 200 response, got: %d", test.path, rw.Code)
+			continue
+		}
+		compressed := rw.Header().Get(HeaderContentEncoding) == "gzip"
+		if compressed != test.compressed {
+			t.Errorf("%s %q: expected compressed=%v, got %v", test.path, test.accept, test.compressed, compressed)
+			continue
+		}
+		got := rw.Body.Bytes()
+		if compressed {
+			gz, err := gzip.NewReader(rw.Body)
+			if err != nil {
+				t.Errorf("%s: invalid gzip body: %v", test.path, err)
+				continue
+			}
+			if got, err = ioutil.ReadAll(gz); err != nil {
+				t.Errorf("%s: invalid gzip body: %v", test.path, err)
+				continue
+			}
+		}
+		if !bytes.Equal(got, body) {
+			t.Errorf("%s: incorrect body", test.path)
+		}
+		if test.path == "/text" && !strings.Contains(rw.Header().Get(HeaderVary), HeaderAcceptEncoding) {
+			t.Errorf("%s: expected Vary: %s, got: %q", test.path, HeaderAcceptEncoding, rw.Header().Get(HeaderVary))
+		}
+	}
+}
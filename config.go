@@ -1,16 +1,25 @@
 package luddite
 
 import (
+	"compress/gzip"
 	"errors"
 	"io/ioutil"
+	"net/http"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
 const (
-	defaultMetricsURIPath  = "/metrics"
-	defaultProfilerURIPath = "/debug/pprof"
-	maxStackSize           = 8 * 1024
+	defaultErrorContentType     = ContentTypeJson
+	defaultMetricsURIPath       = "/metrics"
+	defaultProfilerURIPath      = "/debug/pprof"
+	defaultRequestClass         = RequestClassInteractive
+	defaultMirrorSampleRate     = 1
+	defaultMirrorMaxBodySize    = 1 << 20
+	defaultMirrorMaxOutstanding = 16
+	maxStackSize                = 8 * 1024
+	minCookieKeySize            = 32
 )
 
 var (
@@ -20,10 +29,43 @@ var (
 	// ErrInvalidMaxApiVersion occurs when a service's maximum API version is <= 0.
 	ErrInvalidMaxApiVersion = errors.New("service's maximum API version must be greater than zero")
 
+	// ErrMissingMirrorURL occurs when request mirroring is enabled without a shadow backend URL.
+	ErrMissingMirrorURL = errors.New("service's mirror URL must be set when mirroring is enabled")
+
+	// ErrInvalidDefaultTimeZone occurs when a service's default time zone isn't a known IANA time zone.
+	ErrInvalidDefaultTimeZone = errors.New("service's default time zone is unknown")
+
+	// ErrInvalidDefaultUnits occurs when a service's default unit system isn't "metric" or "imperial".
+	ErrInvalidDefaultUnits = errors.New("service's default unit system must be metric or imperial")
+
+	// ErrInvalidErrorContentType occurs when a service's default error content type isn't JSON, XML or plain text.
+	ErrInvalidErrorContentType = errors.New("service's default error content type must be application/json, application/xml or text/plain")
+
+	// ErrInvalidCookieSameSite occurs when a service's cookie SameSite attribute isn't "lax", "strict" or "none".
+	ErrInvalidCookieSameSite = errors.New("service's cookie SameSite attribute must be lax, strict or none")
+
+	// ErrInsecureSameSiteNone occurs when a service's cookies are SameSite=None without the Secure attribute, which browsers reject.
+	ErrInsecureSameSiteNone = errors.New("service's cookies must be secure when their SameSite attribute is none")
+
+	// ErrShortCookieKey occurs when one of a service's cookie keys is shorter than 32 bytes.
+	ErrShortCookieKey = errors.New("service's cookie keys must be at least 32 bytes long")
+
+	// ErrInvalidErrorStatus occurs when a service's decode or validation error status isn't a 4xx status.
+	ErrInvalidErrorStatus = errors.New("service's decode and validation error statuses must be 4xx statuses")
+
+	// ErrInvalidVersionContentType occurs when an API version's default content type isn't one that the service negotiates.
+	ErrInvalidVersionContentType = errors.New("service's API version default content types must be negotiable content types")
+
+	// ErrInvalidVersionKey occurs when an API version's default content type or null policy is configured for an API version <= 0.
+	ErrInvalidVersionKey = errors.New("service's API version default content types and null policies must be configured for API versions greater than zero")
+
+	// ErrInvalidNullPolicy occurs when an API version's null policy isn't emit, omit_null or omit_empty.
+	ErrInvalidNullPolicy = errors.New("service's API version null policies must be emit, omit_null or omit_empty")
+
 	// ErrMismatchedApiVersions occurs when a service's minimum API version > its maximum API version.
 	ErrMismatchedApiVersions = errors.New("service's maximum API version must be greater than or equal to the minimum API version")
 
-	defaultCORSAllowedMethods = []string{"GET", "POST", "PUT", "DELETE"}
+	defaultCORSAllowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
 )
 
 // ServiceConfig holds a service's config values.
@@ -31,12 +73,79 @@ type ServiceConfig struct {
 	// Addr is the address:port pair that the HTTP server listens on.
 	Addr string
 
+	Admin struct {
+		// Enabled, when true, enables the service's admin endpoints, e.g. GET /admin/config to dump the effective config with secrets redacted.
+		Enabled bool
+		// URIPath sets the base path for admin endpoints. Defaults to "/admin".
+		URIPath string `yaml:"uri_path"`
+		// Scope sets the scope that principals need to use the admin endpoints. If unset, any authenticated principal may use them.
+		Scope string `yaml:"scope"`
+	}
+
+	CircuitBreaker struct {
+		// Enabled, when true, fails requests to a route fast with 503 responses once its handler keeps failing.
+		Enabled bool
+		// ErrorRate sets the fraction of failed requests in a window, in (0, 1], that opens a route's breaker. Defaults to 0.5.
+		ErrorRate float64 `yaml:"error_rate"`
+		// Latency, if set, counts requests slower than this as failures.
+		Latency time.Duration `yaml:"latency"`
+		// MinRequests sets the number of requests in a window before a route's breaker may open. Defaults to 20.
+		MinRequests int `yaml:"min_requests"`
+		// Window sets the period over which failures are counted. Defaults to 10s.
+		Window time.Duration `yaml:"window"`
+		// CoolDown sets how long an open breaker fails requests before letting a trial request through. Defaults to 30s.
+		CoolDown time.Duration `yaml:"cool_down"`
+	}
+
+	Classification struct {
+		// Enabled, when true, enables request classification.
+		Enabled bool
+		// Default sets the class of requests that match no rule. Defaults to "interactive".
+		Default string
+		// Rules is an ordered list of classification rules. The first matching rule determines a request's class.
+		Rules []ClassificationRule
+	}
+
+	Cache struct {
+		// Enabled, when true, caches GET responses server-side according to their Cache-Control headers, including stale-while-revalidate and stale-if-error.
+		Enabled bool
+		// MaxEntries sets the maximum number of cached responses. Defaults to 1000.
+		MaxEntries int `yaml:"max_entries"`
+	}
+
+	Coalescing struct {
+		// Enabled, when true, serves identical concurrent GETs (same path, query, API version, content type, and principal) from a single handler execution.
+		Enabled bool
+		// PathPrefixes restricts coalescing to requests whose paths begin with one of these prefixes. If unset, all API GETs are coalesced.
+		PathPrefixes []string `yaml:"path_prefixes"`
+	}
+
+	Compression struct {
+		// Enabled, when true, gzip-compresses resource responses with compressible content types for clients that accept it.
+		Enabled bool
+		// Level sets the gzip compression level, from 1 (best speed) to 9 (best compression). Defaults to gzip's default level.
+		Level int
+	}
+
+	Cookies struct {
+		// Domain sets the Domain attribute of cookies set by the service. If unset, cookies are host-only.
+		Domain string
+		// Path sets the Path attribute of cookies set by the service. Defaults to "/".
+		Path string
+		// SameSite sets the SameSite attribute of cookies set by the service: lax | strict | none. Defaults to "lax".
+		SameSite string `yaml:"same_site"`
+		// Insecure, when true, omits the Secure attribute of cookies set by the service, e.g. for local development over plain HTTP.
+		Insecure bool
+		// Keys holds the secret keys, each at least 32 bytes long, used to sign and encrypt cookies. The first key is used for new cookies; the others remain valid so that keys can be rotated.
+		Keys []string
+	}
+
 	CORS struct {
 		// Enabled, when true, enables CORS.
 		Enabled bool
 		// AllowedOrigins contains the list of origins a cross-domain request can be executed from. Defaults to "*" on an empty list.
 		AllowedOrigins []string `yaml:"allowed_origins"`
-		// AllowedMethods contains the list of methods the client is allowed to use with cross-domain requests. Defaults to "GET", "POST", "PUT" and "DELETE" on an empty list.
+		// AllowedMethods contains the list of methods the client is allowed to use with cross-domain requests. Defaults to "GET", "POST", "PUT", "PATCH" and "DELETE" on an empty list.
 		AllowedMethods []string `yaml:"allowed_methods"`
 		// AllowedHeaders contains the list of non-simple headers clients are allowed to use in cross-origin requests.  An empty list is interpreted literally however "Origin" is always appended.
 		AllowedHeaders []string `yaml:"allowed_headers"`
@@ -49,11 +158,54 @@ type ServiceConfig struct {
 	// Credentials is a generic map of strings that may be used to store tokens, AWS keys, etc.
 	Credentials map[string]string
 
+	// HTTPClients holds config values for outbound HTTP clients by name. The "default" entry applies to all clients.
+	HTTPClients map[string]HTTPClientConfig `yaml:"http_clients"`
+
 	Debug struct {
 		// Stacks, when true, causes stack traces to appear in 500 error responses.
 		Stacks bool
 		// StackSize sets an upper limit on the length of stack traces that appear in 500 error responses.
 		StackSize int `yaml:"stack_size"`
+		// Echo, when true and admin endpoints are enabled, serves GET /admin/echo, which describes each request as the service saw it (negotiated content type, API version, headers, principal, client address and request ids).
+		Echo bool
+	}
+
+	Errors struct {
+		// DefaultContentType sets the content type of error responses when the request's Accept header allows neither JSON, XML nor plain text: application/json | application/xml | text/plain. Defaults to "application/json".
+		DefaultContentType string `yaml:"default_content_type"`
+		// DecodeStatus sets the status of responses to request bodies that can't be deserialized, e.g. malformed JSON. Defaults to 400.
+		DecodeStatus int `yaml:"decode_status"`
+		// ValidationStatus sets the status of responses to request bodies that are well-formed but fail validation, e.g. 422. Defaults to 400.
+		ValidationStatus int `yaml:"validation_status"`
+	}
+
+	Limits struct {
+		// MaxResponseSize sets the maximum response body size in bytes for resource routes. If unset, response sizes are unlimited.
+		MaxResponseSize int64 `yaml:"max_response_size"`
+		// RouteMaxResponseSizes overrides MaxResponseSize for individual routes, keyed by method and route pattern, e.g. "GET /users" or "GET /users/:seg1".
+		RouteMaxResponseSizes map[string]int64 `yaml:"route_max_response_sizes"`
+		// MaxBufferedBodySize sets the maximum request body size in bytes accepted by BufferRequestBody. Defaults to 1 MB.
+		MaxBufferedBodySize int64 `yaml:"max_buffered_body_size"`
+		// RequestTimeout sets a deadline for handling each request, after which its context is cancelled. If unset, requests have no deadline.
+		RequestTimeout time.Duration `yaml:"request_timeout"`
+		// StrictPageSize, when true, rejects list requests for pages larger than a resource's maximum page size with 400 responses. Otherwise the page size is clamped.
+		StrictPageSize bool `yaml:"strict_page_size"`
+		// MaxListItems sets the maximum number of items in List responses from resources without a maximum page size. If unset, list lengths are unlimited.
+		MaxListItems int `yaml:"max_list_items"`
+		// MaxListSize sets the maximum serialized size in bytes of the items in List responses from resources without a maximum page size. Only JSON lists can be truncated; lists of other media types that exceed it are rejected with 500 responses. If unset, list sizes are unlimited.
+		MaxListSize int64 `yaml:"max_list_size"`
+		// StrictListSize, when true, rejects List responses that exceed MaxListItems or MaxListSize with 500 responses. Otherwise the list is truncated and a Warning header is added.
+		StrictListSize bool `yaml:"strict_list_size"`
+		// MaxResponseHeaderSize sets the maximum size in bytes of a single response header, including all its values. Larger headers are dropped and logged. If unset, header sizes are unlimited.
+		MaxResponseHeaderSize int `yaml:"max_response_header_size"`
+		// MaxResponseHeadersSize sets the maximum total size in bytes of response headers. The largest headers are dropped and logged until the headers fit. If unset, the total size is unlimited.
+		MaxResponseHeadersSize int `yaml:"max_response_headers_size"`
+		// MaxQueryLength sets the maximum length in bytes of request query strings. Longer queries are rejected with 414 responses. If unset, query lengths are unlimited.
+		MaxQueryLength int `yaml:"max_query_length"`
+		// MaxQueryParams sets the maximum number of parameters in request query strings. If unset, parameter counts are unlimited.
+		MaxQueryParams int `yaml:"max_query_params"`
+		// MaxQueryValues sets the maximum number of values of a repeated (array-style) query parameter. If unset, repetition is unlimited.
+		MaxQueryValues int `yaml:"max_query_values"`
 	}
 
 	Log struct {
@@ -63,6 +215,8 @@ type ServiceConfig struct {
 		ServiceLogLevel string `yaml:"service_log_level"`
 		// AccessLogPath sets the file path for the access log (written as JSON). If unset, defaults to stdout (written as text).
 		AccessLogPath string `yaml:"access_log_path"`
+		// ShutdownReportPath sets a file path to which a JSON report of each graceful shutdown is written. The report is always logged.
+		ShutdownReportPath string `yaml:"shutdown_report_path"`
 	}
 
 	Metrics struct {
@@ -72,6 +226,37 @@ type ServiceConfig struct {
 		URIPath string `yaml:"uri_path"`
 	}
 
+	Mirror struct {
+		// Enabled, when true, asynchronously copies a sample of requests (with bodies) to a shadow backend, ignoring its responses.
+		Enabled bool
+		// URL sets the shadow backend's base URL, e.g. "http://shadow.example.com:8080".
+		URL string `yaml:"url"`
+		// SampleRate sets the fraction of requests that are mirrored, in (0, 1]. Defaults to 1 (all requests).
+		SampleRate float64 `yaml:"sample_rate"`
+		// MaxBodySize sets the largest request body in bytes that is mirrored. Defaults to 1 MB.
+		MaxBodySize int64 `yaml:"max_body_size"`
+		// MaxOutstanding sets the maximum number of mirrored requests in flight; additional requests aren't mirrored. Defaults to 16.
+		MaxOutstanding int `yaml:"max_outstanding"`
+	}
+
+	Plugins struct {
+		// Dir sets a directory whose Go plugins (*.so files) are opened by plugins.Load.
+		Dir string `yaml:"dir"`
+		// Disabled lists the names of registered plugins that aren't loaded.
+		Disabled []string `yaml:"disabled"`
+	}
+
+	Preferences struct {
+		// Enabled, when true, resolves each request's locale, time zone, and unit preferences.
+		Enabled bool
+		// DefaultLocale sets the locale used when neither the request nor the principal's profile provides one.
+		DefaultLocale string `yaml:"default_locale"`
+		// DefaultTimeZone sets the IANA time zone used when neither the request nor the principal's profile provides one. Defaults to "UTC".
+		DefaultTimeZone string `yaml:"default_time_zone"`
+		// DefaultUnits sets the unit system used when neither the request nor the principal's profile provides one: metric | imperial. Defaults to "metric".
+		DefaultUnits string `yaml:"default_units"`
+	}
+
 	Profiler struct {
 		// Enabled, when true, enables the service's profiling endpoints.
 		Enabled bool
@@ -79,6 +264,20 @@ type ServiceConfig struct {
 		URIPath string `yaml:"uri_path"`
 	}
 
+	Ready struct {
+		// Enabled, when true, enables the service's readiness endpoint, which also lists routes with open circuit breakers.
+		Enabled bool
+		// URIPath sets the readiness path. Defaults to "/ready".
+		URIPath string `yaml:"uri_path"`
+	}
+
+	ResponseHeaders struct {
+		// Enabled, when true, strips response headers that aren't allowlisted before responses are written, and trailers that aren't allowlisted once they complete, logging the headers stripped.
+		Enabled bool
+		// Allowed lists the headers allowed in addition to the standard and luddite headers. Names ending in "*" match by prefix, e.g. "X-Acme-*".
+		Allowed []string `yaml:"allowed"`
+	}
+
 	Schema struct {
 		// Enabled, when true, self-serve the service's own schema.
 		Enabled bool
@@ -90,6 +289,13 @@ type ServiceConfig struct {
 		FileName string `yaml:"file_name"`
 		// RootRedirect, when true, redirects the service's root to the default schema.
 		RootRedirect bool `yaml:"root_redirect"`
+		// HotReload, when true, serves the schema from memory and atomically reloads it whenever files under FilePath change until the service shuts down.
+		HotReload bool `yaml:"hot_reload"`
+	}
+
+	Timing struct {
+		// Enabled, when true, adds X-Response-Time and Server-Timing headers to responses.
+		Enabled bool
 	}
 
 	Trace struct {
@@ -110,6 +316,8 @@ type ServiceConfig struct {
 		CertFilePath string `yaml:"cert_file_path"`
 		// KeyFilePath sets the path to the server's key file.
 		KeyFilePath string `yaml:"key_file_path"`
+		// DrainTimeout sets how long the service waits for in-flight requests to complete once it stops listening. Defaults to 30s.
+		DrainTimeout time.Duration `yaml:"drain_timeout"`
 	}
 
 	Version struct {
@@ -117,27 +325,108 @@ type ServiceConfig struct {
 		Min int
 		// Max sets the maximum API version that the service supports.
 		Max int
+		// DefaultContentTypes sets the response content type of requests without an Accept header by API version, e.g. {1: application/xml, 3: application/json}. Each entry applies to its API version and later ones, up to the next entry. Versions without an entry default to JSON.
+		DefaultContentTypes map[int]string `yaml:"default_content_types"`
+		// NullPolicies sets whether null and zero-valued members are omitted from JSON responses by API version: emit (the default), omit_null or omit_empty. Each entry applies to its API version and later ones, up to the next entry. Resources may override it using WithNullPolicy.
+		NullPolicies map[int]string `yaml:"null_policies"`
 	}
 }
 
 // Normalize applies sensible defaults to service config values when they are
 // otherwise unspecified or invalid.
 func (config *ServiceConfig) Normalize() {
+	if config.Admin.Enabled && config.Admin.URIPath == "" {
+		config.Admin.URIPath = defaultAdminURIPath
+	}
+
+	if config.Cache.Enabled && config.Cache.MaxEntries < 1 {
+		config.Cache.MaxEntries = defaultResponseCacheMaxEntries
+	}
+
+	if config.CircuitBreaker.Enabled {
+		if config.CircuitBreaker.ErrorRate <= 0 || config.CircuitBreaker.ErrorRate > 1 {
+			config.CircuitBreaker.ErrorRate = defaultBreakerErrorRate
+		}
+		if config.CircuitBreaker.MinRequests < 1 {
+			config.CircuitBreaker.MinRequests = defaultBreakerMinRequests
+		}
+		if config.CircuitBreaker.Window <= 0 {
+			config.CircuitBreaker.Window = defaultBreakerWindow
+		}
+		if config.CircuitBreaker.CoolDown <= 0 {
+			config.CircuitBreaker.CoolDown = defaultBreakerCoolDown
+		}
+	}
+
+	if config.Classification.Enabled && config.Classification.Default == "" {
+		config.Classification.Default = defaultRequestClass
+	}
+
+	if config.Cookies.Path == "" {
+		config.Cookies.Path = "/"
+	}
+	if config.Cookies.SameSite == "" {
+		config.Cookies.SameSite = CookieSameSiteLax
+	}
+
 	if config.CORS.Enabled && len(config.CORS.AllowedMethods) == 0 {
 		config.CORS.AllowedMethods = defaultCORSAllowedMethods
 	}
 
+	if config.Compression.Level < gzip.BestSpeed || config.Compression.Level > gzip.BestCompression {
+		config.Compression.Level = gzip.DefaultCompression
+	}
+
 	if config.Debug.Stacks && config.Debug.StackSize < 1 {
 		config.Debug.StackSize = maxStackSize
 	}
 
+	if config.Errors.DefaultContentType == "" {
+		config.Errors.DefaultContentType = defaultErrorContentType
+	}
+	if config.Errors.DecodeStatus == 0 {
+		config.Errors.DecodeStatus = http.StatusBadRequest
+	}
+	if config.Errors.ValidationStatus == 0 {
+		config.Errors.ValidationStatus = http.StatusBadRequest
+	}
+
 	if config.Metrics.Enabled && config.Metrics.URIPath == "" {
 		config.Metrics.URIPath = defaultMetricsURIPath
 	}
 
+	if config.Mirror.Enabled {
+		if config.Mirror.SampleRate <= 0 {
+			config.Mirror.SampleRate = defaultMirrorSampleRate
+		}
+		if config.Mirror.MaxBodySize < 1 {
+			config.Mirror.MaxBodySize = defaultMirrorMaxBodySize
+		}
+		if config.Mirror.MaxOutstanding < 1 {
+			config.Mirror.MaxOutstanding = defaultMirrorMaxOutstanding
+		}
+	}
+
+	if config.Preferences.Enabled {
+		if config.Preferences.DefaultTimeZone == "" {
+			config.Preferences.DefaultTimeZone = "UTC"
+		}
+		if config.Preferences.DefaultUnits == "" {
+			config.Preferences.DefaultUnits = UnitsMetric
+		}
+	}
+
 	if config.Profiler.Enabled && config.Profiler.URIPath == "" {
 		config.Profiler.URIPath = defaultProfilerURIPath
 	}
+
+	if config.Ready.Enabled && config.Ready.URIPath == "" {
+		config.Ready.URIPath = defaultReadyURIPath
+	}
+
+	if config.Transport.DrainTimeout <= 0 {
+		config.Transport.DrainTimeout = defaultDrainTimeout
+	}
 }
 
 // Validate sanity-checks service config values.
@@ -151,9 +440,68 @@ func (config *ServiceConfig) Validate() error {
 	if config.Version.Min > config.Version.Max {
 		return ErrMismatchedApiVersions
 	}
+	if config.Mirror.Enabled && config.Mirror.URL == "" {
+		return ErrMissingMirrorURL
+	}
+	switch config.Cookies.SameSite {
+	case "", CookieSameSiteLax, CookieSameSiteStrict:
+	case CookieSameSiteNone:
+		if config.Cookies.Insecure {
+			return ErrInsecureSameSiteNone
+		}
+	default:
+		return ErrInvalidCookieSameSite
+	}
+	for _, key := range config.Cookies.Keys {
+		if len(key) < minCookieKeySize {
+			return ErrShortCookieKey
+		}
+	}
+	for _, status := range []int{config.Errors.DecodeStatus, config.Errors.ValidationStatus} {
+		if status != 0 && (status < 400 || status > 499) {
+			return ErrInvalidErrorStatus
+		}
+	}
+	for v, ct := range config.Version.DefaultContentTypes {
+		if v < 1 {
+			return ErrInvalidVersionKey
+		}
+		if !isNegotiatedContentType(ct) {
+			return ErrInvalidVersionContentType
+		}
+	}
+	for v, p := range config.Version.NullPolicies {
+		if v < 1 {
+			return ErrInvalidVersionKey
+		}
+		if !NullPolicy(p).valid() {
+			return ErrInvalidNullPolicy
+		}
+	}
+	switch config.Errors.DefaultContentType {
+	case "", ContentTypeJson, ContentTypeXml, ContentTypePlain:
+	default:
+		return ErrInvalidErrorContentType
+	}
+	if config.Preferences.Enabled {
+		if _, err := time.LoadLocation(config.Preferences.DefaultTimeZone); err != nil {
+			return ErrInvalidDefaultTimeZone
+		}
+		if units := config.Preferences.DefaultUnits; units != UnitsMetric && units != UnitsImperial {
+			return ErrInvalidDefaultUnits
+		}
+	}
 	return nil
 }
 
+// maxResponseSize returns the maximum response body size for a resource route.
+func (config *ServiceConfig) maxResponseSize(method, pattern string) int64 {
+	if size, ok := config.Limits.RouteMaxResponseSizes[method+" "+pattern]; ok {
+		return size
+	}
+	return config.Limits.MaxResponseSize
+}
+
 // ReadConfig reads a YAML config file from path. The file is parsed into the struct pointed to by cfg.
 func ReadConfig(path string, cfg interface{}) error {
 	buf, err := ioutil.ReadFile(path)
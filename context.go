@@ -3,6 +3,7 @@ package luddite
 import (
 	"context"
 	"net/http"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -14,13 +15,24 @@ const contextHandlerDetailsKey = contextKey(0)
 // NB: New fields added to this structure must be explicitly initialized in the
 // init method below. This enables pool-based allocation.
 type handlerDetails struct {
-	s               *Service
-	rw              ResponseWriter
-	request         *http.Request
-	requestId       string
-	requestProgress string
-	apiVersion      int
-	external        map[interface{}]interface{}
+	s                   *Service
+	rw                  ResponseWriter
+	request             *http.Request
+	requestId           string
+	requestProgress     string
+	apiVersion          int
+	requestClass        string
+	route               string
+	preferences         *Preferences
+	preferencesHandler  *preferencesHandler
+	preferencesRequest  *http.Request
+	explicitPreferences Preferences
+	principal           *Principal
+	eventTxs            []*EventTx
+	body                []byte
+	external            map[interface{}]interface{}
+	breaker             *circuitBreaker
+	routing             *routing
 }
 
 func (d *handlerDetails) init(s *Service, rw ResponseWriter, request *http.Request, requestId, requestProgress string) {
@@ -30,7 +42,28 @@ func (d *handlerDetails) init(s *Service, rw ResponseWriter, request *http.Reque
 	d.requestId = requestId
 	d.requestProgress = requestProgress
 	d.apiVersion = 0
+	d.requestClass = ""
+	d.route = ""
+	d.preferences = nil
+	d.preferencesHandler = nil
+	d.preferencesRequest = nil
+	d.explicitPreferences = Preferences{}
+	d.principal = nil
+	d.eventTxs = nil
+	d.body = nil
 	d.external = nil
+	d.breaker = nil
+	d.routing = nil
+}
+
+// currentRouting returns the routing snapshot that the request is dispatched
+// through, so that its routes and resource options agree even if resources are
+// replaced while it's in flight.
+func (d *handlerDetails) currentRouting() *routing {
+	if d.routing != nil {
+		return d.routing
+	}
+	return d.s.currentRouting()
 }
 
 func withHandlerDetails(ctx context.Context, d *handlerDetails) context.Context {
@@ -133,6 +166,80 @@ func ContextApiVersion(ctx context.Context) (apiVersion int) {
 	return
 }
 
+// ContextRequestClass returns the current HTTP request's class (e.g.
+// "interactive", "batch" or "health") from a context.Context, if possible. The
+// class is empty unless request classification is enabled.
+func ContextRequestClass(ctx context.Context) (class string) {
+	if d, ok := ctx.Value(contextHandlerDetailsKey).(*handlerDetails); ok {
+		class = d.requestClass
+	}
+	return
+}
+
+// ContextRoute returns the pattern of the resource route (e.g.
+// "/users/:seg1") that is handling the current HTTP request from a
+// context.Context, if possible.
+func ContextRoute(ctx context.Context) (route string) {
+	if d, ok := ctx.Value(contextHandlerDetailsKey).(*handlerDetails); ok {
+		route = d.route
+	}
+	return
+}
+
+// ContextPreferences returns the rendering preferences that apply to the
+// current HTTP request from a context.Context, if possible. The preferences
+// are nil unless preference resolution is enabled. They're resolved on first
+// access, so that a PreferencesResolver sees the principal set by middleware.
+func ContextPreferences(ctx context.Context) (prefs *Preferences) {
+	if d, ok := ctx.Value(contextHandlerDetailsKey).(*handlerDetails); ok {
+		if d.preferences == nil && d.preferencesHandler != nil {
+			d.preferences = d.preferencesHandler.resolve(d.preferencesRequest, &d.explicitPreferences)
+			d.preferencesHandler, d.preferencesRequest = nil, nil
+		}
+		prefs = d.preferences
+	}
+	return
+}
+
+// ContextLocale returns the preferred locale for the current HTTP request from
+// a context.Context, if possible.
+func ContextLocale(ctx context.Context) (locale string) {
+	if prefs := ContextPreferences(ctx); prefs != nil {
+		locale = prefs.Locale
+	}
+	return
+}
+
+// ContextTimeZone returns the preferred time zone for the current HTTP request
+// from a context.Context. It defaults to UTC.
+func ContextTimeZone(ctx context.Context) (loc *time.Location) {
+	if prefs := ContextPreferences(ctx); prefs != nil && prefs.TimeZone != nil {
+		return prefs.TimeZone
+	}
+	return time.UTC
+}
+
+// ContextUnits returns the preferred unit system (e.g. "metric" or "imperial")
+// for the current HTTP request from a context.Context, if possible.
+func ContextUnits(ctx context.Context) (units string) {
+	if prefs := ContextPreferences(ctx); prefs != nil {
+		units = prefs.Units
+	}
+	return
+}
+
+// setContextRoute records the route handling the current HTTP request and
+// applies the route's response size limit.
+func setContextRoute(ctx context.Context, method, pattern string) {
+	if d, ok := ctx.Value(contextHandlerDetailsKey).(*handlerDetails); ok {
+		d.route = pattern
+		if res, ok := d.rw.(*responseWriter); ok && d.s != nil {
+			res.maxSize = d.s.config.maxResponseSize(method, pattern)
+			res.route = pattern
+		}
+	}
+}
+
 // SetContextDetail sets a detail in the current HTTP request's context. This
 // may be used by the service's own middleware and avoids allocating a new
 // request with additional context.
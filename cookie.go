@@ -0,0 +1,217 @@
+package luddite
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CookieSameSiteLax    = "lax"
+	CookieSameSiteStrict = "strict"
+	CookieSameSiteNone   = "none"
+)
+
+var (
+	// ErrNoCookieKeys is returned when signing, encrypting, verifying or
+	// decrypting cookies without any configured cookie keys.
+	ErrNoCookieKeys = errors.New("no cookie keys are configured")
+
+	// ErrInvalidCookie is returned for cookies that have been tampered with,
+	// weren't issued by the service (or with a current key), or have expired.
+	ErrInvalidCookie = errors.New("invalid cookie")
+)
+
+var cookieEncoding = base64.RawURLEncoding
+
+// NewCookie returns a cookie with the service's configured attributes. Cookies
+// are HttpOnly and Secure, with SameSite=Lax, unless configured otherwise. A
+// zero maxAge makes a session cookie.
+func (s *Service) NewCookie(name, value string, maxAge time.Duration) *http.Cookie {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     s.config.Cookies.Path,
+		Domain:   s.config.Cookies.Domain,
+		Secure:   !s.config.Cookies.Insecure,
+		HttpOnly: true,
+	}
+	switch s.config.Cookies.SameSite {
+	case CookieSameSiteStrict:
+		c.SameSite = http.SameSiteStrictMode
+	case CookieSameSiteNone:
+		c.SameSite = http.SameSiteNoneMode
+	default:
+		c.SameSite = http.SameSiteLaxMode
+	}
+	if maxAge > 0 {
+		c.MaxAge = int(maxAge / time.Second)
+		c.Expires = time.Now().Add(maxAge).UTC()
+	}
+	return c
+}
+
+// SetCookie sets a cookie with the service's configured attributes.
+func (s *Service) SetCookie(rw http.ResponseWriter, name, value string, maxAge time.Duration) {
+	http.SetCookie(rw, s.NewCookie(name, value, maxAge))
+}
+
+// DeleteCookie tells the client to delete a cookie set by the service.
+func (s *Service) DeleteCookie(rw http.ResponseWriter, name string) {
+	c := s.NewCookie(name, "", 0)
+	c.MaxAge = -1
+	c.Expires = time.Unix(0, 0).UTC()
+	http.SetCookie(rw, c)
+}
+
+// SetSignedCookie sets a cookie whose value is readable by the client but
+// can't be modified without detection. The value's expiry is signed along with
+// it, so an expired cookie is rejected even if the client keeps it.
+func (s *Service) SetSignedCookie(rw http.ResponseWriter, name, value string, maxAge time.Duration) error {
+	keys := s.config.Cookies.Keys
+	if len(keys) == 0 {
+		return ErrNoCookieKeys
+	}
+	payload := cookieEncoding.EncodeToString([]byte(cookiePayload(value, maxAge)))
+	sig := cookieEncoding.EncodeToString(signCookie(keys[0], name, payload))
+	s.SetCookie(rw, name, payload+"."+sig, maxAge)
+	return nil
+}
+
+// SignedCookie returns the value of a cookie set with SetSignedCookie. It
+// returns http.ErrNoCookie if the request has no such cookie.
+func (s *Service) SignedCookie(req *http.Request, name string) (string, error) {
+	keys := s.config.Cookies.Keys
+	if len(keys) == 0 {
+		return "", ErrNoCookieKeys
+	}
+	c, err := req.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	i := strings.LastIndexByte(c.Value, '.')
+	if i < 0 {
+		return "", ErrInvalidCookie
+	}
+	payload := c.Value[:i]
+	sig, err := cookieEncoding.DecodeString(c.Value[i+1:])
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+	for _, key := range keys {
+		if hmac.Equal(sig, signCookie(key, name, payload)) {
+			b, err := cookieEncoding.DecodeString(payload)
+			if err != nil {
+				return "", ErrInvalidCookie
+			}
+			return parseCookiePayload(string(b))
+		}
+	}
+	return "", ErrInvalidCookie
+}
+
+// SetEncryptedCookie sets a cookie whose value can be neither read nor
+// modified by the client. It's encrypted with AES-GCM.
+func (s *Service) SetEncryptedCookie(rw http.ResponseWriter, name, value string, maxAge time.Duration) error {
+	keys := s.config.Cookies.Keys
+	if len(keys) == 0 {
+		return ErrNoCookieKeys
+	}
+	aead, err := cookieCipher(keys[0])
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return err
+	}
+	b := aead.Seal(nonce, nonce, []byte(cookiePayload(value, maxAge)), []byte(name))
+	s.SetCookie(rw, name, cookieEncoding.EncodeToString(b), maxAge)
+	return nil
+}
+
+// EncryptedCookie returns the value of a cookie set with SetEncryptedCookie.
+// It returns http.ErrNoCookie if the request has no such cookie.
+func (s *Service) EncryptedCookie(req *http.Request, name string) (string, error) {
+	keys := s.config.Cookies.Keys
+	if len(keys) == 0 {
+		return "", ErrNoCookieKeys
+	}
+	c, err := req.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	b, err := cookieEncoding.DecodeString(c.Value)
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+	for _, key := range keys {
+		aead, err := cookieCipher(key)
+		if err != nil {
+			return "", err
+		}
+		if len(b) < aead.NonceSize() {
+			return "", ErrInvalidCookie
+		}
+		if plain, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], []byte(name)); err == nil {
+			return parseCookiePayload(string(plain))
+		}
+	}
+	return "", ErrInvalidCookie
+}
+
+// cookiePayload prefixes a cookie value with its expiry time (zero for session
+// cookies).
+func cookiePayload(value string, maxAge time.Duration) string {
+	var expires int64
+	if maxAge > 0 {
+		expires = time.Now().Add(maxAge).Unix()
+	}
+	return strconv.FormatInt(expires, 10) + "|" + value
+}
+
+func parseCookiePayload(payload string) (string, error) {
+	i := strings.IndexByte(payload, '|')
+	if i < 0 {
+		return "", ErrInvalidCookie
+	}
+	expires, err := strconv.ParseInt(payload[:i], 10, 64)
+	if err != nil || (expires != 0 && time.Now().Unix() >= expires) {
+		return "", ErrInvalidCookie
+	}
+	return payload[i+1:], nil
+}
+
+// signCookie signs a cookie's payload along with its name, so that a signed
+// value can't be replayed as another cookie.
+func signCookie(key, name, payload string) []byte {
+	mac := hmac.New(sha256.New, deriveCookieKey(key, "sign"))
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func cookieCipher(key string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveCookieKey(key, "encrypt"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// deriveCookieKey derives separate 256-bit keys for signing and encryption
+// from a configured cookie key.
+func deriveCookieKey(key, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte("luddite cookie " + purpose))
+	return mac.Sum(nil)
+}
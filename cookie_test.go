@@ -0,0 +1,101 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newCookieTestService(t *testing.T, keys ...string) *Service {
+	return newTestService(t, func(config *ServiceConfig) {
+		config.Cookies.Keys = keys
+	})
+}
+
+// testCookieKey pads a test key to the minimum cookie key size.
+func testCookieKey(key string) string {
+	return key + strings.Repeat("-", minCookieKeySize-len(key))
+}
+
+// roundTrip returns a request that carries the cookies set on a response.
+func roundTrip(rw *httptest.ResponseRecorder) *http.Request {
+	req, _ := http.NewRequest("GET", "/", nil)
+	for _, c := range rw.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func TestCookieDefaults(t *testing.T) {
+	s := newCookieTestService(t)
+	c := s.NewCookie("prefs", "dark", time.Hour)
+	if !c.Secure || !c.HttpOnly || c.SameSite != http.SameSiteLaxMode || c.Path != "/" || c.MaxAge != 3600 {
+		t.Errorf("unexpected cookie attributes: %+v", c)
+	}
+
+	config := &ServiceConfig{}
+	config.Version.Min = 1
+	config.Version.Max = 1
+	config.Cookies.SameSite = CookieSameSiteNone
+	config.Cookies.Insecure = true
+	if err := config.Validate(); err != ErrInsecureSameSiteNone {
+		t.Errorf("expected ErrInsecureSameSiteNone, got: %v", err)
+	}
+
+	config.Cookies.SameSite = CookieSameSiteLax
+	config.Cookies.Keys = []string{testCookieKey("current"), "short"}
+	if err := config.Validate(); err != ErrShortCookieKey {
+		t.Errorf("expected ErrShortCookieKey, got: %v", err)
+	}
+}
+
+func TestSignedCookie(t *testing.T) {
+	s := newCookieTestService(t, testCookieKey("old-key"))
+	rw := httptest.NewRecorder()
+	if err := s.SetSignedCookie(rw, "session", "user-7", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	req := roundTrip(rw)
+
+	// Rotated keys continue to verify cookies signed with older keys
+	s.config.Cookies.Keys = []string{testCookieKey("new-key"), testCookieKey("old-key")}
+	if v, err := s.SignedCookie(req, "session"); err != nil || v != "user-7" {
+		t.Errorf("expected signed cookie value, got: %q %v", v, err)
+	}
+
+	c, _ := req.Cookie("session")
+	tampered, _ := http.NewRequest("GET", "/", nil)
+	tampered.AddCookie(&http.Cookie{Name: "session", Value: "x" + c.Value})
+	if _, err := s.SignedCookie(tampered, "session"); err != ErrInvalidCookie {
+		t.Errorf("expected tampered cookie to be rejected, got: %v", err)
+	}
+	renamed, _ := http.NewRequest("GET", "/", nil)
+	renamed.AddCookie(&http.Cookie{Name: "admin", Value: c.Value})
+	if _, err := s.SignedCookie(renamed, "admin"); err != ErrInvalidCookie {
+		t.Errorf("expected renamed cookie to be rejected, got: %v", err)
+	}
+	if _, err := s.SignedCookie(req, "missing"); err != http.ErrNoCookie {
+		t.Errorf("expected http.ErrNoCookie, got: %v", err)
+	}
+}
+
+func TestEncryptedCookie(t *testing.T) {
+	s := newCookieTestService(t, testCookieKey("secret"))
+	rw := httptest.NewRecorder()
+	if err := s.SetEncryptedCookie(rw, "csrf", "token-123", 0); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(rw.Header().Get("Set-Cookie"), "token-123") {
+		t.Error("expected cookie value to be encrypted")
+	}
+	if v, err := s.EncryptedCookie(roundTrip(rw), "csrf"); err != nil || v != "token-123" {
+		t.Errorf("expected decrypted cookie value, got: %q %v", v, err)
+	}
+
+	s.config.Cookies.Keys = []string{testCookieKey("other")}
+	if _, err := s.EncryptedCookie(roundTrip(rw), "csrf"); err != ErrInvalidCookie {
+		t.Errorf("expected cookie encrypted with an unknown key to be rejected, got: %v", err)
+	}
+}
@@ -3,6 +3,8 @@ package luddite
 import (
 	"encoding/xml"
 	"fmt"
+	"net/http"
+	"strings"
 )
 
 const (
@@ -22,6 +24,22 @@ const (
 	EcodeMissingViewParameter  = "MISSING_VIEW_PARAMETER"
 	EcodeInvalidViewParameter  = "INVALID_VIEW_PARAMETER"
 	EcodeInvalidParameterValue = "INVALID_PARAMETER_VALUE"
+	EcodeResponseTooLarge      = "RESPONSE_TOO_LARGE"
+	EcodeSubRequestIncomplete  = "SUBREQUEST_INCOMPLETE"
+	EcodePageSizeTooLarge      = "PAGE_SIZE_TOO_LARGE"
+	EcodeUnauthenticated       = "UNAUTHENTICATED"
+	EcodeMissingScope          = "MISSING_SCOPE"
+	EcodeRouteNotFound         = "ROUTE_NOT_FOUND"
+	EcodeMethodNotAllowed      = "METHOD_NOT_ALLOWED"
+	EcodeResourceUnavailable   = "RESOURCE_UNAVAILABLE"
+	EcodeRateLimited           = "RATE_LIMITED"
+	EcodeNotAcceptable         = "NOT_ACCEPTABLE"
+	EcodeRouteUnavailable      = "ROUTE_UNAVAILABLE"
+	EcodeListTooLarge          = "LIST_TOO_LARGE"
+	EcodeQueryTooLong          = "QUERY_TOO_LONG"
+	EcodeTooManyQueryParams    = "TOO_MANY_QUERY_PARAMS"
+	EcodeTooManyQueryValues    = "TOO_MANY_QUERY_VALUES"
+	EcodeInvalidId             = "INVALID_ID"
 )
 
 var commonErrorMap = map[string]string{
@@ -41,20 +59,81 @@ var commonErrorMap = map[string]string{
 	EcodeMissingViewParameter:  "Missing view parameter: %s",
 	EcodeInvalidViewParameter:  "Invalid view parameter: %s",
 	EcodeInvalidParameterValue: "Invalid parameter value: %s -> %s",
+	EcodeResponseTooLarge:      "Response exceeds the maximum size of %d bytes",
+	EcodeSubRequestIncomplete:  "Sub-request did not complete: %v",
+	EcodePageSizeTooLarge:      "The maximum page size is %d",
+	EcodeUnauthenticated:       "Authentication is required",
+	EcodeMissingScope:          "Missing required scope: %s",
+	EcodeRouteNotFound:         "No route matches %s %s",
+	EcodeMethodNotAllowed:      "Method %s isn't allowed for %s",
+	EcodeResourceUnavailable:   "Resource %s is being updated, retry shortly",
+	EcodeRateLimited:           "Rate limit exceeded (scope: %s)",
+	EcodeNotAcceptable:         "None of the acceptable content types can be produced",
+	EcodeRouteUnavailable:      "Route %s %s is temporarily unavailable",
+	EcodeListTooLarge:          "List of %d items exceeds the unpaginated list limits",
+	EcodeQueryTooLong:          "Query string exceeds the maximum length of %d bytes",
+	EcodeTooManyQueryParams:    "Query string exceeds the maximum of %d parameters",
+	EcodeTooManyQueryValues:    "Query parameter %s exceeds the maximum of %d values",
+	EcodeInvalidId:             "Invalid resource id: %s",
+}
+
+// ecodeStatuses maps common error codes to the response status used when an
+// Error is raised by panicking rather than returned by a resource.
+var ecodeStatuses = map[string]int{
+	EcodeUnsupportedMediaType:  http.StatusUnsupportedMediaType,
+	EcodeDeserializationFailed: http.StatusBadRequest,
+	EcodeResourceIdMismatch:    http.StatusBadRequest,
+	EcodeApiVersionInvalid:     http.StatusBadRequest,
+	EcodeApiVersionTooOld:      http.StatusGone,
+	EcodeApiVersionTooNew:      http.StatusNotImplemented,
+	EcodeValidationFailed:      http.StatusBadRequest,
+	EcodeLocked:                http.StatusLocked,
+	EcodeUpdatePreempted:       http.StatusPreconditionFailed,
+	EcodeInvalidViewName:       http.StatusBadRequest,
+	EcodeMissingViewParameter:  http.StatusBadRequest,
+	EcodeInvalidViewParameter:  http.StatusBadRequest,
+	EcodeInvalidParameterValue: http.StatusBadRequest,
+	EcodePageSizeTooLarge:      http.StatusBadRequest,
+	EcodeUnauthenticated:       http.StatusUnauthorized,
+	EcodeMissingScope:          http.StatusForbidden,
+	EcodeRouteNotFound:         http.StatusNotFound,
+	EcodeMethodNotAllowed:      http.StatusMethodNotAllowed,
+	EcodeResourceUnavailable:   http.StatusServiceUnavailable,
+	EcodeRateLimited:           http.StatusTooManyRequests,
+	EcodeNotAcceptable:         http.StatusNotAcceptable,
+	EcodeRouteUnavailable:      http.StatusServiceUnavailable,
+	EcodeListTooLarge:          http.StatusInternalServerError,
+	EcodeQueryTooLong:          http.StatusRequestURITooLong,
+	EcodeTooManyQueryParams:    http.StatusBadRequest,
+	EcodeTooManyQueryValues:    http.StatusBadRequest,
+	EcodeInvalidId:             http.StatusNotFound,
 }
 
 // Error is a transfer object that is serialized as the body in 4xx and 5xx responses.
 type Error struct {
-	XMLName xml.Name `json:"-" xml:"error"`
-	Code    string   `json:"code" xml:"code"`
-	Message string   `json:"message" xml:"message"`
-	Stack   string   `json:"stack,omitempty" xml:"stack,omitempty"`
+	XMLName   xml.Name   `json:"-" xml:"error"`
+	Code      string     `json:"code" xml:"code"`
+	Message   string     `json:"message" xml:"message"`
+	Details   []string   `json:"details,omitempty" xml:"details>detail,omitempty"`
+	RateLimit *RateLimit `json:"rate_limit,omitempty" xml:"rate_limit,omitempty"`
+	Stack     string     `json:"stack,omitempty" xml:"stack,omitempty"`
 }
 
 func (e *Error) Error() string {
 	return e.Message
 }
 
+// text renders the error as plain text, for clients that accept neither JSON
+// nor XML.
+func (e *Error) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s\n", e.Code, e.Message)
+	for _, detail := range e.Details {
+		fmt.Fprintf(&b, "  %s\n", detail)
+	}
+	return b.String()
+}
+
 // NewError allocates and initializes an Error. If a non-nil errorMap
 // map is passed, the error is built using this map. Otherwise a map
 // containing common errors is used as a fallback.
@@ -95,3 +174,52 @@ func NewError(errorMap map[string]string, code string, args ...interface{}) *Err
 		Message: message,
 	}
 }
+
+// abort is the value raised by Abort.
+type abort struct {
+	status int
+	v      interface{}
+}
+
+// Abort ends handling of the current request by panicking. The service's
+// recovery handler writes a response with the given status and body, exactly
+// as if they had been returned by the resource. This allows deeply nested
+// helpers (e.g. validation) to fail a request without threading errors back
+// through every caller.
+//
+// Resources may also panic with an *Error directly. Common error codes are
+// mapped to an appropriate 4xx status; other codes produce a 500 response
+// that preserves the error's code and message.
+func Abort(status int, v interface{}) {
+	panic(&abort{status, v})
+}
+
+// recoveredResponse converts a value recovered from a deliberate panic (see
+// Abort) into a response status and body. It returns false for any other
+// value, which should be treated as an unhandled error.
+func recoveredResponse(s *Service, rcv interface{}) (int, interface{}, bool) {
+	switch x := rcv.(type) {
+	case *abort:
+		return x.status, x.v, true
+	case *Error:
+		if status, ok := s.ecodeStatus(x.Code); ok {
+			return status, x, true
+		}
+	}
+	return 0, nil, false
+}
+
+// ecodeStatus returns the response status for a common error code. The
+// statuses of decode and validation failures are configurable.
+func (s *Service) ecodeStatus(code string) (int, bool) {
+	if s != nil {
+		switch code {
+		case EcodeDeserializationFailed:
+			return s.config.Errors.DecodeStatus, true
+		case EcodeValidationFailed:
+			return s.config.Errors.ValidationStatus, true
+		}
+	}
+	status, ok := ecodeStatuses[code]
+	return status, ok
+}
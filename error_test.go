@@ -1,7 +1,11 @@
 package luddite
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -78,3 +82,85 @@ func TestNewError(t *testing.T) {
 		t.Error("no error returned")
 	}
 }
+
+var sharedPanicError = NewError(errorMap, EcodeHelloWorld, "shared")
+
+type abortResource struct{}
+
+func (r *abortResource) New() interface{}            { return &versionedSample{} }
+func (r *abortResource) Id(value interface{}) string { return value.(*versionedSample).Id }
+
+func (r *abortResource) Get(req *http.Request, id string) (int, interface{}) {
+	switch id {
+	case "abort":
+		Abort(http.StatusConflict, NewError(errorMap, EcodeHelloWorld, "abort"))
+	case "validation":
+		panic(NewError(nil, EcodeValidationFailed, "name is required"))
+	case "custom":
+		panic(NewError(errorMap, EcodeHelloWorld, "custom"))
+	case "shared":
+		panic(sharedPanicError)
+	case "error":
+		panic(errors.New("oh noes!"))
+	}
+	return http.StatusOK, &versionedSample{Id: id}
+}
+
+func TestRecoveredPanicResponses(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Log.ServiceLogLevel = "error"
+	})
+	mustAddResource(t, s, 1, "/samples", &abortResource{})
+
+	tests := []struct {
+		id     string
+		status int
+		code   string
+	}{
+		{"abort", http.StatusConflict, EcodeHelloWorld},
+		{"validation", http.StatusBadRequest, EcodeValidationFailed},
+		{"custom", http.StatusInternalServerError, EcodeHelloWorld},
+		{"error", http.StatusInternalServerError, EcodeInternal},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/samples/"+test.id, nil)
+		req.Header.Set(HeaderAccept, ContentTypeJson)
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, req)
+		if rw.Code != test.status {
+			t.Errorf("%s: expected %d response, got: %d", test.id, test.status, rw.Code)
+		}
+		if rw.Header().Get(HeaderRequestId) == "" {
+			t.Errorf("%s: request id not set", test.id)
+		}
+		var e Error
+		if err := json.Unmarshal(rw.Body.Bytes(), &e); err != nil {
+			t.Errorf("%s: error body not decoded: %v", test.id, err)
+		} else if e.Code != test.code {
+			t.Errorf("%s: expected error code %s, got: %s", test.id, test.code, e.Code)
+		}
+	}
+}
+
+func TestRecoveredPanicErrorNotMutated(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Log.ServiceLogLevel = "error"
+		config.Debug.Stacks = true
+	})
+	mustAddResource(t, s, 1, "/samples", &abortResource{})
+
+	req, _ := http.NewRequest("GET", "/samples/shared", nil)
+	req.Header.Set(HeaderAccept, ContentTypeJson)
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	var e Error
+	if err := json.Unmarshal(rw.Body.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Stack == "" {
+		t.Error("expected stack in error response")
+	}
+	if sharedPanicError.Stack != "" {
+		t.Error("panicked error was modified")
+	}
+}
@@ -0,0 +1,157 @@
+package luddite
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Versioned is implemented by resource values that carry a version for
+// optimistic concurrency control. Luddite emits the version as an ETag in
+// responses and enforces If-Match preconditions on updates and deletes.
+type Versioned interface {
+	// Version returns the resource's current version.
+	Version() string
+
+	// SetVersion sets the resource's version. Luddite calls this on values
+	// decoded from request bodies with the version given by If-Match, which
+	// allows resources to perform their own compare-and-swap.
+	SetVersion(version string)
+}
+
+// formatETag converts a resource version into a strong entity tag.
+func formatETag(version string) string {
+	return `"` + version + `"`
+}
+
+// responseETag returns the entity tag for a response value or an empty string
+// if the value isn't versioned. Slices of versioned values are given a weak
+// entity tag derived from the versions of their (non-nil) elements.
+func responseETag(v interface{}) string {
+	if x, ok := v.(Versioned); ok {
+		if version := x.Version(); version != "" {
+			return formatETag(version)
+		}
+		return ""
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice || rv.Len() == 0 {
+		return ""
+	}
+	h := fnv.New64a()
+	for i := 0; i < rv.Len(); i++ {
+		elem := rv.Index(i)
+		if (elem.Kind() == reflect.Ptr || elem.Kind() == reflect.Interface) && elem.IsNil() {
+			continue
+		}
+		x, ok := elem.Interface().(Versioned)
+		if !ok {
+			return ""
+		}
+		fmt.Fprintf(h, "%d:%s;", i, x.Version())
+	}
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+// setResponseETag adds an ETag header for a successful response value.
+func setResponseETag(rw http.ResponseWriter, status int, v interface{}) {
+	if status/100 != 2 {
+		return
+	}
+	if etag := responseETag(v); etag != "" {
+		rw.Header().Set(HeaderETag, etag)
+	}
+}
+
+// matchETag reports whether a list of entity tags taken from an If-Match or
+// If-None-Match header contains the given entity tag. Weak comparison is used,
+// which ignores the W/ prefix.
+func matchETag(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// strongMatchETag reports whether a list of entity tags taken from an If-Match
+// header contains the given entity tag. Strong comparison is used (RFC 7232
+// section 3.1): weak entity tags never match.
+func strongMatchETag(header, etag string) bool {
+	if strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchVersion returns the resource version given by an If-Match header. It
+// returns an empty string unless the header contains exactly one strong entity
+// tag.
+func ifMatchVersion(header string) string {
+	tag := strings.TrimSpace(header)
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' || strings.Contains(tag, ",") {
+		return ""
+	}
+	return tag[1 : len(tag)-1]
+}
+
+// checkIfMatch evaluates a request's If-Match precondition. The value about to
+// be written (if any) receives the requested version, and the resource's
+// current value is fetched using get (if non-nil) so that its version can be
+// compared. If the precondition fails, or the current value can't be fetched
+// because of a client or server error other than 404/Not Found, it returns the
+// status and value to respond with; otherwise it returns a zero status.
+func checkIfMatch(req *http.Request, v interface{}, get func() (int, interface{})) (int, interface{}) {
+	header := req.Header.Get(HeaderIfMatch)
+	if header == "" {
+		return 0, nil
+	}
+	if x, ok := v.(Versioned); ok {
+		if version := ifMatchVersion(header); version != "" {
+			x.SetVersion(version)
+		}
+	}
+	if get == nil {
+		return 0, nil
+	}
+	status, cur := get()
+	switch {
+	case status == http.StatusNotFound:
+		return preconditionFailed()
+	case status/100 == 4 || status/100 == 5:
+		return status, cur
+	case status/100 != 2:
+		return 0, nil
+	}
+	if x, ok := cur.(Versioned); ok && !strongMatchETag(header, formatETag(x.Version())) {
+		return preconditionFailed()
+	}
+	return 0, nil
+}
+
+func preconditionFailed() (int, interface{}) {
+	return http.StatusPreconditionFailed, NewError(nil, EcodeUpdatePreempted, "resource version mismatch")
+}
+
+// checkIfNoneMatch evaluates a request's If-None-Match precondition against a
+// response value. It returns false if the client's representation is current.
+func checkIfNoneMatch(req *http.Request, v interface{}) bool {
+	header := req.Header.Get(HeaderIfNoneMatch)
+	if header == "" {
+		return true
+	}
+	etag := responseETag(v)
+	return etag == "" || !matchETag(header, etag)
+}
@@ -0,0 +1,181 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dimfeld/httptreemux"
+)
+
+type versionedSample struct {
+	Id  string `json:"id"`
+	Rev string `json:"rev"`
+}
+
+func (v *versionedSample) Version() string           { return v.Rev }
+func (v *versionedSample) SetVersion(version string) { v.Rev = version }
+
+type versionedResource struct {
+	current *versionedSample
+	updated *versionedSample
+}
+
+func (r *versionedResource) New() interface{}            { return &versionedSample{} }
+func (r *versionedResource) Id(value interface{}) string { return value.(*versionedSample).Id }
+
+func (r *versionedResource) Get(req *http.Request, id string) (int, interface{}) {
+	if id != r.current.Id {
+		return http.StatusNotFound, nil
+	}
+	return http.StatusOK, r.current
+}
+
+func (r *versionedResource) Update(req *http.Request, id string, value interface{}) (int, interface{}) {
+	r.updated = value.(*versionedSample)
+	return http.StatusOK, &versionedSample{Id: id, Rev: "2"}
+}
+
+func (r *versionedResource) Delete(req *http.Request, id string) (int, interface{}) {
+	return http.StatusNoContent, nil
+}
+
+func newVersionedRouter(r *versionedResource) *httptreemux.ContextMux {
+	router := httptreemux.NewContextMux()
+	AddGetCollectionRoute(router, "/samples", r)
+	AddUpdateCollectionRoute(router, "/samples", r)
+	return router
+}
+
+func TestVersionedGetETag(t *testing.T) {
+	r := &versionedResource{current: &versionedSample{Id: "a", Rev: "1"}}
+	router := newVersionedRouter(r)
+
+	req, _ := http.NewRequest("GET", "/samples/a", nil)
+	rw := httptest.NewRecorder()
+	rw.Header().Set(HeaderContentType, ContentTypeJson)
+	router.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", rw.Code)
+	}
+	if etag := rw.Header().Get(HeaderETag); etag != `"1"` {
+		t.Errorf("incorrect ETag: %s", etag)
+	}
+
+	req, _ = http.NewRequest("GET", "/samples/a", nil)
+	req.Header.Set(HeaderIfNoneMatch, `"1"`)
+	rw = httptest.NewRecorder()
+	rw.Header().Set(HeaderContentType, ContentTypeJson)
+	router.ServeHTTP(rw, req)
+	if rw.Code != http.StatusNotModified {
+		t.Errorf("expected 304/Not Modified response for current version, got: %d", rw.Code)
+	}
+}
+
+func TestVersionedUpdateIfMatch(t *testing.T) {
+	r := &versionedResource{current: &versionedSample{Id: "a", Rev: "1"}}
+	router := newVersionedRouter(r)
+
+	req, _ := http.NewRequest("PUT", "/samples/a", strings.NewReader(`{"id":"a"}`))
+	req.Header.Set(HeaderContentType, ContentTypeJson)
+	req.Header.Set(HeaderIfMatch, `"0"`)
+	rw := httptest.NewRecorder()
+	rw.Header().Set(HeaderContentType, ContentTypeJson)
+	router.ServeHTTP(rw, req)
+	if rw.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412/Precondition Failed response for stale version, got: %d", rw.Code)
+	}
+	if r.updated != nil {
+		t.Error("stale update reached the resource")
+	}
+
+	req, _ = http.NewRequest("PUT", "/samples/a", strings.NewReader(`{"id":"a"}`))
+	req.Header.Set(HeaderContentType, ContentTypeJson)
+	req.Header.Set(HeaderIfMatch, `"1"`)
+	rw = httptest.NewRecorder()
+	rw.Header().Set(HeaderContentType, ContentTypeJson)
+	router.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Errorf("unexpected status: %d", rw.Code)
+	}
+	if r.updated == nil || r.updated.Rev != "1" {
+		t.Error("If-Match version not passed to the resource")
+	}
+	if etag := rw.Header().Get(HeaderETag); etag != `"2"` {
+		t.Errorf("incorrect ETag: %s", etag)
+	}
+}
+
+type failingGetResource struct {
+	versionedResource
+	status int
+}
+
+func (r *failingGetResource) Get(req *http.Request, id string) (int, interface{}) {
+	return r.status, NewError(nil, EcodeInternal, "lookup failed")
+}
+
+func TestIfMatchStrongComparison(t *testing.T) {
+	r := &versionedResource{current: &versionedSample{Id: "a", Rev: "1"}}
+	router := newVersionedRouter(r)
+
+	req, _ := http.NewRequest("PUT", "/samples/a", strings.NewReader(`{"id":"a"}`))
+	req.Header.Set(HeaderContentType, ContentTypeJson)
+	req.Header.Set(HeaderIfMatch, `W/"1"`)
+	rw := httptest.NewRecorder()
+	rw.Header().Set(HeaderContentType, ContentTypeJson)
+	router.ServeHTTP(rw, req)
+	if rw.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412/Precondition Failed response for weak entity tag, got: %d", rw.Code)
+	}
+	if r.updated != nil {
+		t.Error("update with a weak entity tag reached the resource")
+	}
+
+	for _, status := range []int{http.StatusServiceUnavailable, http.StatusForbidden} {
+		u := &failingGetResource{status: status}
+		router = httptreemux.NewContextMux()
+		AddUpdateCollectionRoute(router, "/samples", u)
+		req, _ = http.NewRequest("PUT", "/samples/a", strings.NewReader(`{"id":"a"}`))
+		req.Header.Set(HeaderContentType, ContentTypeJson)
+		req.Header.Set(HeaderIfMatch, `"1"`)
+		rw = httptest.NewRecorder()
+		rw.Header().Set(HeaderContentType, ContentTypeJson)
+		router.ServeHTTP(rw, req)
+		if rw.Code != status {
+			t.Errorf("expected the getter's %d response, got: %d", status, rw.Code)
+		}
+		if u.updated != nil {
+			t.Errorf("%d: update reached the resource without its precondition being evaluated", status)
+		}
+	}
+}
+
+func TestResponseETagNilElements(t *testing.T) {
+	samples := []*versionedSample{{Id: "a", Rev: "1"}, nil, {Id: "b", Rev: "2"}}
+	if etag := responseETag(samples); !strings.HasPrefix(etag, `W/"`) {
+		t.Errorf("expected weak ETag for a list with nil elements, got: %q", etag)
+	}
+}
+
+func TestMatchETag(t *testing.T) {
+	if !matchETag(`"a", "b"`, `"b"`) {
+		t.Error("entity tag list not matched")
+	}
+	if !matchETag(`*`, `"b"`) {
+		t.Error("wildcard not matched")
+	}
+	if !matchETag(`W/"b"`, `"b"`) {
+		t.Error("weak entity tag not matched")
+	}
+	if matchETag(`"a"`, `"b"`) {
+		t.Error("mismatched entity tag matched")
+	}
+	if strongMatchETag(`W/"b"`, `"b"`) || strongMatchETag(`"b"`, `W/"b"`) {
+		t.Error("weak entity tag matched by strong comparison")
+	}
+	if !strongMatchETag(`"a", "b"`, `"b"`) || !strongMatchETag(`*`, `"b"`) {
+		t.Error("strong entity tag not matched")
+	}
+}
@@ -0,0 +1,251 @@
+package luddite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Event describes a mutation of a resource.
+type Event struct {
+	// Type is the kind of event, e.g. "created", "updated" or "deleted".
+	Type string `json:"type"`
+	// Resource identifies the resource type, e.g. its base path.
+	Resource string `json:"resource"`
+	// Id is the identifier of the mutated resource value.
+	Id string `json:"id,omitempty"`
+	// Data optionally carries a representation of the mutated value.
+	Data interface{} `json:"data,omitempty"`
+	// Time is when the event was staged.
+	Time time.Time `json:"time"`
+	// RequestId is the id of the request that caused the event.
+	RequestId string `json:"request_id,omitempty"`
+}
+
+// EventPublisher delivers events to a service's event bus, e.g. a message
+// queue. Publish is called from a single background worker with the events of
+// each committed transaction, in commit order. Its context is canceled if the
+// events are still being published when the service's drain timeout expires.
+type EventPublisher interface {
+	Publish(ctx context.Context, events []*Event) error
+}
+
+// eventQueueSize bounds the number of committed transactions awaiting
+// publication. Transactions committed while the queue is full are discarded
+// rather than blocking their requests.
+const eventQueueSize = 1024
+
+var (
+	errEventQueueClosed = errors.New("service shut down")
+	errEventQueueFull   = errors.New("event queue full")
+)
+
+const (
+	eventTxPending = iota
+	eventTxCommitted
+	eventTxRolledBack
+)
+
+// EventTx stages events alongside a resource's own transaction. Staged events
+// are published once the transaction is committed, whatever the outcome of the
+// request, so consumers see events for every mutation that was committed and
+// never for mutations that were rolled back.
+type EventTx struct {
+	mu        sync.Mutex
+	s         *Service
+	requestId string
+	state     int
+	events    []*Event
+}
+
+// BeginEventTx begins staging events for the current HTTP request. The
+// returned EventTx acts as a commit token: resources call Commit once their own
+// transaction has committed, or Rollback if it has not.
+func BeginEventTx(ctx context.Context) *EventTx {
+	tx := &EventTx{}
+	if d, ok := ctx.Value(contextHandlerDetailsKey).(*handlerDetails); ok {
+		tx.s = d.s
+		tx.requestId = d.requestId
+		d.eventTxs = append(d.eventTxs, tx)
+	}
+	return tx
+}
+
+// Stage adds an event to the transaction. Events staged after the transaction
+// has been committed or rolled back are discarded.
+func (tx *EventTx) Stage(e *Event) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.state != eventTxPending {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.RequestId == "" {
+		e.RequestId = tx.requestId
+	}
+	tx.events = append(tx.events, e)
+}
+
+// Commit queues the transaction's staged events for publication to the
+// service's event bus.
+func (tx *EventTx) Commit() {
+	tx.mu.Lock()
+	if tx.state != eventTxPending {
+		tx.mu.Unlock()
+		return
+	}
+	tx.state = eventTxCommitted
+	events := tx.events
+	tx.events = nil
+	tx.mu.Unlock()
+	if tx.s != nil && len(events) != 0 {
+		tx.s.queueEvents(tx.requestId, events)
+	}
+}
+
+// Rollback discards the transaction's staged events.
+func (tx *EventTx) Rollback() {
+	tx.mu.Lock()
+	if tx.state == eventTxPending {
+		tx.state = eventTxRolledBack
+		tx.events = nil
+	}
+	tx.mu.Unlock()
+}
+
+// resolveEventTxs discards the events of a request's transactions that were
+// neither committed nor rolled back.
+func (s *Service) resolveEventTxs(d *handlerDetails) {
+	for _, tx := range d.eventTxs {
+		tx.mu.Lock()
+		if tx.state == eventTxPending {
+			if len(tx.events) != 0 {
+				s.defaultLogger.WithFields(log.Fields{
+					"request_id": d.requestId,
+					"events":     len(tx.events),
+				}).Warn("discarding events from an event transaction that was neither committed nor rolled back")
+			}
+			tx.state = eventTxRolledBack
+			tx.events = nil
+		}
+		tx.mu.Unlock()
+	}
+}
+
+// eventBatch holds the events of one committed transaction.
+type eventBatch struct {
+	requestId string
+	events    []*Event
+}
+
+// eventQueue publishes committed events from a single worker goroutine.
+type eventQueue struct {
+	publisher EventPublisher
+	batches   chan eventBatch
+	mu        sync.RWMutex
+	closed    bool
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+func newEventQueue(s *Service, publisher EventPublisher) *eventQueue {
+	q := &eventQueue{
+		publisher: publisher,
+		batches:   make(chan eventBatch, eventQueueSize),
+		done:      make(chan struct{}),
+	}
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	go q.run(s)
+	return q
+}
+
+func (q *eventQueue) run(s *Service) {
+	defer close(q.done)
+	for b := range q.batches {
+		if err := q.publisher.Publish(q.ctx, b.events); err != nil {
+			s.defaultLogger.WithFields(log.Fields{
+				"request_id": b.requestId,
+				"events":     len(b.events),
+			}).Error("event publication failed: ", err)
+		}
+	}
+}
+
+// add queues a batch for publication without blocking. It fails if the queue
+// has been closed or is full.
+func (q *eventQueue) add(b eventBatch) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return errEventQueueClosed
+	}
+	select {
+	case q.batches <- b:
+		return nil
+	default:
+		return errEventQueueFull
+	}
+}
+
+// close stops accepting batches and waits up to timeout for the queued ones to
+// be published, canceling the publisher's context if they aren't.
+func (q *eventQueue) close(timeout time.Duration) error {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.batches)
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-t.C:
+		n := len(q.batches)
+		q.cancel()
+		return fmt.Errorf("event queue not drained: %d committed transactions unpublished", n)
+	}
+}
+
+// queueEvents queues the events of a committed transaction for publication.
+func (s *Service) queueEvents(requestId string, events []*Event) {
+	q := s.eventQueue
+	if q == nil {
+		s.defaultLogger.WithFields(log.Fields{
+			"request_id": requestId,
+			"events":     len(events),
+		}).Debug("no event publisher: discarding committed events")
+		return
+	}
+	if err := q.add(eventBatch{requestId, events}); err != nil {
+		s.defaultLogger.WithFields(log.Fields{
+			"request_id": requestId,
+			"events":     len(events),
+		}).Error(err, ": discarding committed events")
+	}
+}
+
+// SetEventPublisher sets the event bus to which events committed using
+// EventTx are published. It must be called at most once, before the service
+// starts serving requests. Events still queued when the service shuts down are
+// published as part of the graceful shutdown.
+func (s *Service) SetEventPublisher(publisher EventPublisher) {
+	if s.eventQueue != nil {
+		panic("event publisher set twice")
+	}
+	q := newEventQueue(s, publisher)
+	s.eventQueue = q
+	s.OnShutdown("events", func() error {
+		return q.close(s.config.Transport.DrainTimeout)
+	})
+}
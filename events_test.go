@@ -0,0 +1,143 @@
+package luddite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type eventSink chan []*Event
+
+func (c eventSink) Publish(ctx context.Context, events []*Event) error {
+	c <- events
+	return nil
+}
+
+type eventResource struct{}
+
+func (r *eventResource) New() interface{}            { return &versionedSample{} }
+func (r *eventResource) Id(value interface{}) string { return value.(*versionedSample).Id }
+
+func (r *eventResource) Create(req *http.Request, value interface{}) (int, interface{}) {
+	v := value.(*versionedSample)
+	tx := BeginEventTx(req.Context())
+	tx.Stage(&Event{Type: "created", Resource: "samples", Id: v.Id})
+	switch v.Id {
+	case "rollback":
+		tx.Rollback()
+		return http.StatusConflict, nil
+	case "fail":
+		tx.Commit()
+		return http.StatusInternalServerError, nil
+	}
+	tx.Commit()
+	return http.StatusCreated, v
+}
+
+func TestEventTx(t *testing.T) {
+	s := newTestService(t, nil)
+	sink := make(eventSink, 3)
+	s.SetEventPublisher(sink)
+	mustAddResource(t, s, 1, "/samples", &eventResource{})
+
+	for _, id := range []string{"rollback", "fail", "ok"} {
+		req, _ := http.NewRequest("POST", "/samples", strings.NewReader(`{"id":"`+id+`"}`))
+		req.Header.Set(HeaderAccept, ContentTypeJson)
+		req.Header.Set(HeaderContentType, ContentTypeJson)
+		s.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Events are published on commit, even if the request then fails
+	for _, id := range []string{"fail", "ok"} {
+		select {
+		case events := <-sink:
+			if len(events) != 1 || events[0].Id != id || events[0].RequestId == "" {
+				t.Errorf("unexpected events published: %+v", events)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("committed %q events not published", id)
+		}
+	}
+	select {
+	case events := <-sink:
+		t.Errorf("events published for a rolled back transaction: %+v", events)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+type slowEventSink struct {
+	mu     sync.Mutex
+	events []*Event
+}
+
+func (p *slowEventSink) Publish(ctx context.Context, events []*Event) error {
+	time.Sleep(10 * time.Millisecond)
+	p.mu.Lock()
+	p.events = append(p.events, events...)
+	p.mu.Unlock()
+	return nil
+}
+
+func TestEventQueueDrainedOnShutdown(t *testing.T) {
+	s := newTestService(t, nil)
+	sink := &slowEventSink{}
+	s.SetEventPublisher(sink)
+	mustAddResource(t, s, 1, "/samples", &eventResource{})
+
+	for i := 0; i < 5; i++ {
+		req, _ := http.NewRequest("POST", "/samples", strings.NewReader(`{"id":"ok"}`))
+		req.Header.Set(HeaderAccept, ContentTypeJson)
+		req.Header.Set(HeaderContentType, ContentTypeJson)
+		s.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	r := s.shutdown()
+	if len(r.WorkerErrors) != 0 {
+		t.Errorf("unexpected shutdown errors: %v", r.WorkerErrors)
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.events) != 5 {
+		t.Errorf("expected 5 events published before shutdown completed, got: %d", len(sink.events))
+	}
+
+	tx := BeginEventTx(context.WithValue(context.Background(), contextHandlerDetailsKey, &handlerDetails{s: s}))
+	tx.Stage(&Event{Type: "created", Resource: "samples", Id: "late"})
+	tx.Commit()
+	if len(sink.events) != 5 {
+		t.Errorf("events published after shutdown: %+v", sink.events[5:])
+	}
+}
+
+type blockedEventSink struct{}
+
+func (blockedEventSink) Publish(ctx context.Context, events []*Event) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestEventQueueFull(t *testing.T) {
+	s := newTestService(t, nil)
+	q := newEventQueue(s, blockedEventSink{})
+	var err error
+	for i := 0; i <= eventQueueSize+1 && err == nil; i++ {
+		err = q.add(eventBatch{})
+	}
+	if err != errEventQueueFull {
+		t.Errorf("expected errEventQueueFull, got: %v", err)
+	}
+
+	start := time.Now()
+	if err = q.close(10 * time.Millisecond); err == nil {
+		t.Error("expected undrained queue error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("close blocked for %s", elapsed)
+	}
+	if err = q.add(eventBatch{}); err != errEventQueueClosed {
+		t.Errorf("expected errEventQueueClosed, got: %v", err)
+	}
+}
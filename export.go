@@ -0,0 +1,206 @@
+package luddite
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/K-Phoen/negotiation"
+	"github.com/dimfeld/httptreemux"
+)
+
+const (
+	ExportStatusComplete = "complete"
+	ExportStatusError    = "error"
+
+	exportFlushInterval = 100
+)
+
+var exportContentTypes = []string{ContentTypeNdjson, ContentTypeCsv}
+
+// CollectionExporter is a collection-style resource that streams its full
+// (optionally filtered) dataset as NDJSON or CSV in response to `GET
+// /resource/export`.
+type CollectionExporter interface {
+	// Export writes each exported element to w, stopping if w returns an
+	// error. It returns an HTTP status code and a response body (or error),
+	// which is only written if no elements have been exported.
+	Export(req *http.Request, w ExportWriter) (int, interface{})
+}
+
+// ExportWriter streams elements to an export response.
+type ExportWriter interface {
+	// Write encodes a single element. Writes block while the client is slow to
+	// consume the response. An error is returned if the client has gone away
+	// or the element can't be encoded, in which case the export should stop.
+	Write(v interface{}) error
+
+	// Count returns the number of elements written so far.
+	Count() int64
+}
+
+// CsvMarshaler is implemented by elements that export themselves as CSV
+// records. Elements may also be given directly as []string.
+type CsvMarshaler interface {
+	MarshalCsv() ([]string, error)
+}
+
+// CsvHeaderer is optionally implemented by a CollectionExporter to provide a
+// header record for CSV exports.
+type CsvHeaderer interface {
+	CsvHeader(req *http.Request) []string
+}
+
+// AddExportCollectionRoute adds a route for a CollectionExporter.
+func AddExportCollectionRoute(router *httptreemux.ContextMux, basePath string, r CollectionExporter) {
+	handleRoute(router, "GET", path.Join(basePath, "export"), func(rw http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		SetContextRequestProgress(ctx, "luddite.ExportCollectionRoute.begin")
+
+		ct := ContentTypeNdjson
+		if accept := req.Header.Get(HeaderAccept); accept != "" {
+			format, err := negotiation.NegotiateAccept(accept, exportContentTypes)
+			if err != nil {
+				SetContextRequestProgress(ctx, "luddite.ExportCollectionRoute.negotiate_error")
+				observeNotAcceptable(accept)
+				_ = WriteResponse(rw, http.StatusNotAcceptable, NewError(nil, EcodeNotAcceptable))
+				return
+			}
+			ct = format.Value
+		}
+
+		mt, _ := ParseMediaType(ct)
+		w := &exportWriter{rw: rw, ctx: ctx, mt: mt}
+		if x, ok := r.(CsvHeaderer); ok && mt.Is(ContentTypeCsv) {
+			w.header = x.CsvHeader(req)
+		}
+
+		status, v := r.Export(req, w)
+		if status <= 0 {
+			return
+		}
+		if !w.begun {
+			if status/100 != 2 {
+				SetContextRequestProgress(ctx, "luddite.ExportCollectionRoute.write")
+				_ = WriteResponse(rw, status, v)
+				return
+			}
+			w.begin()
+		}
+		SetContextRequestProgress(ctx, "luddite.ExportCollectionRoute.finish")
+		w.finish(status)
+	})
+}
+
+// exportWriter writes export elements directly to the response so that
+// writes block (and the resource slows down) when the client falls behind.
+type exportWriter struct {
+	rw     http.ResponseWriter
+	ctx    context.Context
+	mt     MediaType
+	header []string
+	json   *json.Encoder
+	csv    *csv.Writer
+	begun  bool
+	count  int64
+	err    error
+}
+
+func (w *exportWriter) begin() {
+	w.begun = true
+	h := w.rw.Header()
+	SetContentType(w.rw, w.mt)
+	h.Set(HeaderTrailer, HeaderSpirentExportCount+", "+HeaderSpirentExportStatus)
+	w.rw.WriteHeader(http.StatusOK)
+
+	if w.mt.Is(ContentTypeCsv) {
+		w.csv = csv.NewWriter(w.rw)
+		if w.header != nil {
+			w.err = w.csv.Write(w.header)
+		}
+	} else {
+		w.json = json.NewEncoder(w.rw)
+	}
+}
+
+func (w *exportWriter) Write(v interface{}) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.err = w.ctx.Err(); w.err != nil {
+		return w.err
+	}
+	if !w.begun {
+		w.begin()
+		if w.err != nil {
+			return w.err
+		}
+	}
+
+	if w.csv != nil {
+		var record []string
+		if record, w.err = csvRecord(v); w.err == nil {
+			w.err = w.csv.Write(record)
+		}
+	} else if policy := responseNullPolicy(w.rw); policy != NullPolicyEmit {
+		var b []byte
+		if b, w.err = json.Marshal(v); w.err == nil {
+			if b, w.err = applyNullPolicy(b, policy); w.err == nil {
+				_, w.err = w.rw.Write(append(b, '\n'))
+			}
+		}
+	} else {
+		w.err = w.json.Encode(v)
+	}
+	if w.err != nil {
+		return w.err
+	}
+
+	w.count++
+	if w.count%exportFlushInterval == 0 {
+		w.flush()
+	}
+	return w.err
+}
+
+func (w *exportWriter) Count() int64 {
+	return w.count
+}
+
+func (w *exportWriter) flush() {
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil && w.err == nil {
+			w.err = err
+		}
+	}
+	if f, ok := w.rw.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *exportWriter) finish(status int) {
+	w.flush()
+	exportStatus := ExportStatusComplete
+	if w.err != nil || status/100 != 2 {
+		exportStatus = ExportStatusError
+	}
+	h := w.rw.Header()
+	h.Set(HeaderSpirentExportCount, strconv.FormatInt(w.count, 10))
+	h.Set(HeaderSpirentExportStatus, exportStatus)
+}
+
+func csvRecord(v interface{}) ([]string, error) {
+	switch x := v.(type) {
+	case []string:
+		return x, nil
+	case CsvMarshaler:
+		return x.MarshalCsv()
+	default:
+		return nil, fmt.Errorf("%T can't be exported as CSV", v)
+	}
+}
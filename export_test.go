@@ -0,0 +1,85 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/dimfeld/httptreemux"
+)
+
+type exportSample struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func (s *exportSample) MarshalCsv() ([]string, error) {
+	return []string{strconv.Itoa(s.Id), s.Name}, nil
+}
+
+type exportResource struct {
+	n int
+}
+
+func (r *exportResource) Export(req *http.Request, w ExportWriter) (int, interface{}) {
+	for i := 0; i < r.n; i++ {
+		if err := w.Write(&exportSample{Id: i, Name: "dave"}); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+	return http.StatusOK, nil
+}
+
+func (r *exportResource) CsvHeader(req *http.Request) []string {
+	return []string{"id", "name"}
+}
+
+func export(accept string) *http.Response {
+	router := httptreemux.NewContextMux()
+	AddExportCollectionRoute(router, "/samples", &exportResource{n: 2})
+
+	req, _ := http.NewRequest("GET", "/samples/export", nil)
+	if accept != "" {
+		req.Header.Set(HeaderAccept, accept)
+	}
+	rw := httptest.NewRecorder()
+	router.ServeHTTP(rw, req)
+	return rw.Result()
+}
+
+func TestExportNdjson(t *testing.T) {
+	res := export("")
+	if ct := res.Header.Get(HeaderContentType); ct != ContentTypeNdjson {
+		t.Errorf("incorrect content type: %s", ct)
+	}
+	body := httptest.NewRecorder()
+	body.Body.ReadFrom(res.Body)
+	if s := body.Body.String(); s != "{\"id\":0,\"name\":\"dave\"}\n{\"id\":1,\"name\":\"dave\"}\n" {
+		t.Errorf("NDJSON export failed, got: %s", s)
+	}
+	if n := res.Trailer.Get(HeaderSpirentExportCount); n != "2" {
+		t.Errorf("incorrect export count trailer: %s", n)
+	}
+	if status := res.Trailer.Get(HeaderSpirentExportStatus); status != ExportStatusComplete {
+		t.Errorf("incorrect export status trailer: %s", status)
+	}
+}
+
+func TestExportCsv(t *testing.T) {
+	res := export(ContentTypeCsv)
+	if ct := res.Header.Get(HeaderContentType); ct != ContentTypeCsv {
+		t.Errorf("incorrect content type: %s", ct)
+	}
+	body := httptest.NewRecorder()
+	body.Body.ReadFrom(res.Body)
+	if s := body.Body.String(); s != "id,name\n0,dave\n1,dave\n" {
+		t.Errorf("CSV export failed, got: %s", s)
+	}
+}
+
+func TestExportNotAcceptable(t *testing.T) {
+	if res := export(ContentTypeXml); res.StatusCode != http.StatusNotAcceptable {
+		t.Errorf("expected 406/Not Acceptable response, got: %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,86 @@
+package luddite
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/dimfeld/httptreemux"
+)
+
+// fallback handles requests under a path prefix that match no route.
+type fallback struct {
+	prefix string
+	h      http.Handler
+}
+
+// SetNotFoundHandler sets the handler for requests that match no route and no
+// fallback. By default these receive 404 responses without bodies.
+func (s *Service) SetNotFoundHandler(h http.Handler) {
+	s.notFoundHandler = h
+}
+
+// SetMethodNotAllowedHandler sets the handler for requests whose path matches
+// a route that doesn't support the request's method. The response's Allow
+// header lists the supported methods before the handler is called. By default
+// these receive 405 responses without bodies.
+func (s *Service) SetMethodNotAllowedHandler(h http.Handler) {
+	s.methodNotAllowedHandler = h
+}
+
+// AddFallback adds a handler for requests under a path prefix that match no
+// route, e.g. to serve a single-page application for unknown non-API paths.
+// The fallback with the longest matching prefix is used.
+func (s *Service) AddFallback(prefix string, h http.Handler) {
+	s.fallbacks = append(s.fallbacks, fallback{prefix, h})
+}
+
+func (s *Service) newRouter() *httptreemux.ContextMux {
+	router := httptreemux.NewContextMux()
+	router.NotFoundHandler = s.serveNotFound
+	router.MethodNotAllowedHandler = s.serveMethodNotAllowed
+	return router
+}
+
+func (s *Service) serveNotFound(rw http.ResponseWriter, req *http.Request) {
+	var match *fallback
+	for i, f := range s.fallbacks {
+		if strings.HasPrefix(req.URL.Path, f.prefix) && (match == nil || len(f.prefix) > len(match.prefix)) {
+			match = &s.fallbacks[i]
+		}
+	}
+	switch {
+	case match != nil:
+		match.h.ServeHTTP(rw, req)
+	case s.notFoundHandler != nil:
+		s.notFoundHandler.ServeHTTP(rw, req)
+	default:
+		rw.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *Service) serveMethodNotAllowed(rw http.ResponseWriter, req *http.Request, methods map[string]httptreemux.HandlerFunc) {
+	allowed := make([]string, 0, len(methods))
+	for method := range methods {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	rw.Header().Set(HeaderAllow, strings.Join(allowed, ", "))
+	if s.methodNotAllowedHandler != nil {
+		s.methodNotAllowedHandler.ServeHTTP(rw, req)
+		return
+	}
+	rw.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+// RouteNotFound writes a structured 404 response. It may be used with
+// SetNotFoundHandler or AddFallback, e.g. for API paths.
+func RouteNotFound(rw http.ResponseWriter, req *http.Request) {
+	_ = WriteResponse(rw, http.StatusNotFound, NewError(nil, EcodeRouteNotFound, req.Method, req.URL.Path))
+}
+
+// RouteMethodNotAllowed writes a structured 405 response. It may be used with
+// SetMethodNotAllowedHandler.
+func RouteMethodNotAllowed(rw http.ResponseWriter, req *http.Request) {
+	_ = WriteResponse(rw, http.StatusMethodNotAllowed, NewError(nil, EcodeMethodNotAllowed, req.Method, req.URL.Path))
+}
@@ -0,0 +1,48 @@
+package luddite
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFallbacks(t *testing.T) {
+	s := newTestService(t, nil)
+	s.AddFallback("/", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set(HeaderContentType, ContentTypeHtml)
+		_ = WriteResponse(rw, http.StatusOK, "<html></html>")
+	}))
+	s.AddFallback("/api/", http.HandlerFunc(RouteNotFound))
+	s.SetMethodNotAllowedHandler(http.HandlerFunc(RouteMethodNotAllowed))
+	r := &versionedResource{current: &versionedSample{Id: "a", Rev: "1"}}
+	mustAddResource(t, s, 1, "/api/samples", r)
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set(HeaderAccept, ContentTypeJson)
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, req)
+		return rw
+	}
+
+	if rw := serve("GET", "/app/settings"); rw.Code != http.StatusOK || rw.Body.String() != "<html></html>" {
+		t.Errorf("fallback not served: %d %s", rw.Code, rw.Body.String())
+	}
+
+	rw := serve("GET", "/api/unknown")
+	var e Error
+	if rw.Code != http.StatusNotFound {
+		t.Errorf("expected 404/Not Found response, got: %d", rw.Code)
+	} else if err := json.Unmarshal(rw.Body.Bytes(), &e); err != nil || e.Code != EcodeRouteNotFound {
+		t.Errorf("structured 404 response not served: %s", rw.Body.String())
+	}
+
+	rw = serve("POST", "/api/samples/a")
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405/Method Not Allowed response, got: %d", rw.Code)
+	}
+	if allow := rw.Header().Get(HeaderAllow); allow != "DELETE, GET, PUT" {
+		t.Errorf("incorrect Allow header: %s", allow)
+	}
+}
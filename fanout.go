@@ -0,0 +1,154 @@
+package luddite
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"time"
+)
+
+// SubRequest is one of the parallel sub-requests issued by FanOut.
+type SubRequest struct {
+	// Name identifies the sub-request's result.
+	Name string
+
+	// Do performs the sub-request, e.g. by calling another local resource's
+	// methods or an upstream service via Service.HTTPClient. It returns an
+	// HTTP status code and a response body (or error), just like a resource
+	// handler. Do should abandon its work when ctx is done.
+	Do func(ctx context.Context) (int, interface{})
+}
+
+// SubResult is the outcome of a single sub-request.
+type SubResult struct {
+	XMLName xml.Name    `json:"-" xml:"result"`
+	Name    string      `json:"name" xml:"name"`
+	Status  int         `json:"status" xml:"status"`
+	Body    interface{} `json:"body,omitempty" xml:"body,omitempty"`
+}
+
+// FanOutResponse aggregates the results of parallel sub-requests into a single
+// response body. Partial is true when some, but not all, sub-requests failed.
+type FanOutResponse struct {
+	XMLName xml.Name     `json:"-" xml:"results"`
+	Partial bool         `json:"partial" xml:"partial"`
+	Results []*SubResult `json:"results" xml:"result"`
+}
+
+// FanOut issues sub-requests in parallel, running at most limit of them at once
+// (no limit if limit < 1), and waits for their results for up to timeout (no
+// timeout if timeout <= 0). The parent context, typically the inbound request's
+// context, is propagated to each sub-request along with the shared deadline.
+// Sub-requests that don't complete in time are reported with a 504 status.
+//
+// FanOut returns a status code and body suitable for returning directly from a
+// resource handler: 200 if any sub-request succeeded (with Partial set if some
+// failed) or 502 if they all failed. Handlers that report partial success with
+// a 207 status instead may return the response's MultiStatus.
+func FanOut(ctx context.Context, limit int, timeout time.Duration, subs ...SubRequest) (int, *FanOutResponse) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if limit < 1 || limit > len(subs) {
+		limit = len(subs)
+	}
+
+	type indexedResult struct {
+		index  int
+		result *SubResult
+	}
+
+	// Both channels are buffered so that sub-requests which outlive the
+	// deadline never block when they finish.
+	var (
+		results = make(chan indexedResult, len(subs))
+		sem     = make(chan struct{}, limit)
+		started = 0
+	)
+
+launch:
+	for i := range subs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break launch
+		}
+		started++
+		go func(index int, sub SubRequest) {
+			defer func() { <-sem }()
+			results <- indexedResult{index, runSubRequest(ctx, sub)}
+		}(i, subs[i])
+	}
+
+	resp := &FanOutResponse{Results: make([]*SubResult, len(subs))}
+collect:
+	for n := 0; n < started; n++ {
+		select {
+		case r := <-results:
+			resp.Results[r.index] = r.result
+		case <-ctx.Done():
+			break collect
+		}
+	}
+
+	// Pick up any results that raced with the deadline
+drain:
+	for {
+		select {
+		case r := <-results:
+			resp.Results[r.index] = r.result
+		default:
+			break drain
+		}
+	}
+
+	succeeded := 0
+	for i, r := range resp.Results {
+		if r == nil {
+			r = &SubResult{
+				Name:   subs[i].Name,
+				Status: http.StatusGatewayTimeout,
+				Body:   NewError(nil, EcodeSubRequestIncomplete, ctx.Err()),
+			}
+			resp.Results[i] = r
+		}
+		if r.Status/100 == 2 {
+			succeeded++
+		}
+	}
+
+	switch {
+	case succeeded == len(subs):
+		return http.StatusOK, resp
+	case succeeded > 0:
+		resp.Partial = true
+		return http.StatusOK, resp
+	default:
+		return http.StatusBadGateway, resp
+	}
+}
+
+// runSubRequest runs a single sub-request, converting panics and errors into
+// structured results.
+func runSubRequest(ctx context.Context, sub SubRequest) (r *SubResult) {
+	r = &SubResult{Name: sub.Name}
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			r.Status = http.StatusInternalServerError
+			r.Body = NewError(nil, EcodeInternal, rcv)
+		}
+	}()
+
+	r.Status, r.Body = sub.Do(ctx)
+	if r.Status <= 0 {
+		r.Status = http.StatusInternalServerError
+	}
+	switch v := r.Body.(type) {
+	case *Error:
+	case error:
+		r.Body = NewError(nil, EcodeInternal, v)
+	}
+	return
+}
@@ -0,0 +1,76 @@
+package luddite
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFanOut(t *testing.T) {
+	var running, maxRunning int32
+	sub := func(name string, status int) SubRequest {
+		return SubRequest{
+			Name: name,
+			Do: func(ctx context.Context) (int, interface{}) {
+				n := atomic.AddInt32(&running, 1)
+				for {
+					m := atomic.LoadInt32(&maxRunning)
+					if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				if status != http.StatusOK {
+					return status, errors.New("failed")
+				}
+				return status, name
+			},
+		}
+	}
+
+	status, resp := FanOut(context.Background(), 2, 0, sub("a", 200), sub("b", 200), sub("c", 503), sub("d", 200))
+	if status != http.StatusOK || !resp.Partial {
+		t.Errorf("expected partial 200/OK response, got: %d (partial: %v)", status, resp.Partial)
+	}
+	if maxRunning > 2 {
+		t.Errorf("concurrency limit exceeded: %d", maxRunning)
+	}
+	if len(resp.Results) != 4 || resp.Results[1].Name != "b" || resp.Results[1].Body != "b" {
+		t.Error("results not aggregated in order")
+	}
+	if e, ok := resp.Results[2].Body.(*Error); !ok || e.Code != EcodeInternal {
+		t.Error("sub-request error not converted")
+	}
+}
+
+func TestFanOutTimeout(t *testing.T) {
+	slow := SubRequest{
+		Name: "slow",
+		Do: func(ctx context.Context) (int, interface{}) {
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond)
+			return http.StatusOK, nil
+		},
+	}
+	panicky := SubRequest{
+		Name: "panicky",
+		Do: func(ctx context.Context) (int, interface{}) {
+			panic("oh noes!")
+		},
+	}
+
+	status, resp := FanOut(context.Background(), 0, 10*time.Millisecond, slow, panicky)
+	if status != http.StatusBadGateway {
+		t.Errorf("expected 502/Bad Gateway response, got: %d", status)
+	}
+	if resp.Results[0].Status != http.StatusGatewayTimeout {
+		t.Errorf("expected 504/Gateway Timeout result for slow sub-request, got: %d", resp.Results[0].Status)
+	}
+	if resp.Results[1].Status != http.StatusInternalServerError {
+		t.Errorf("expected 500/Internal Server Error result for panicking sub-request, got: %d", resp.Results[1].Status)
+	}
+}
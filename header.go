@@ -10,25 +10,54 @@ import (
 const (
 	HeaderAccept               = "Accept"
 	HeaderAcceptEncoding       = "Accept-Encoding"
+	HeaderAcceptLanguage       = "Accept-Language"
+	HeaderAcceptPatch          = "Accept-Patch"
+	HeaderAge                  = "Age"
+	HeaderAllow                = "Allow"
 	HeaderAuthorization        = "Authorization"
 	HeaderCacheControl         = "Cache-Control"
+	HeaderConnection           = "Connection"
 	HeaderContentDisposition   = "Content-Disposition"
 	HeaderContentEncoding      = "Content-Encoding"
 	HeaderContentLength        = "Content-Length"
 	HeaderContentType          = "Content-Type"
+	HeaderCookie               = "Cookie"
 	HeaderETag                 = "ETag"
 	HeaderExpect               = "Expect"
+	HeaderExpires              = "Expires"
 	HeaderForwardedFor         = "X-Forwarded-For"
 	HeaderForwardedHost        = "X-Forwarded-Host"
+	HeaderIfMatch              = "If-Match"
 	HeaderIfNoneMatch          = "If-None-Match"
+	HeaderLink                 = "Link"
 	HeaderLocation             = "Location"
+	HeaderPrefer               = "Prefer"
+	HeaderPreferenceApplied    = "Preference-Applied"
+	HeaderProxyAuthorization   = "Proxy-Authorization"
+	HeaderRateLimitLimit       = "RateLimit-Limit"
+	HeaderRateLimitRemaining   = "RateLimit-Remaining"
+	HeaderRateLimitReset       = "RateLimit-Reset"
 	HeaderRequestId            = "X-Request-Id"
+	HeaderResponseTime         = "X-Response-Time"
+	HeaderRetryAfter           = "Retry-After"
+	HeaderServerTiming         = "Server-Timing"
 	HeaderSessionId            = "X-Session-Id"
+	HeaderSetCookie            = "Set-Cookie"
 	HeaderSpirentApiVersion    = "X-Spirent-Api-Version"
+	HeaderSpirentExportCount   = "X-Spirent-Export-Count"
+	HeaderSpirentExportStatus  = "X-Spirent-Export-Status"
+	HeaderSpirentLimitScope    = "X-Spirent-Limit-Scope"
+	HeaderSpirentMirrored      = "X-Spirent-Mirrored"
 	HeaderSpirentNextLink      = "X-Spirent-Next-Link"
 	HeaderSpirentPageSize      = "X-Spirent-Page-Size"
 	HeaderSpirentResourceNonce = "X-Spirent-Resource-Nonce"
+	HeaderSpirentTimeZone      = "X-Spirent-Time-Zone"
+	HeaderSpirentUnits         = "X-Spirent-Units"
+	HeaderTrailer              = "Trailer"
 	HeaderUserAgent            = "User-Agent"
+	HeaderVary                 = "Vary"
+	HeaderWarning              = "Warning"
+	HeaderWwwAuthenticate      = "Www-Authenticate"
 )
 
 func RequestBearerToken(r *http.Request) string {
@@ -54,6 +83,9 @@ func RequestNextLink(r *http.Request, cursor string) *url.URL {
 }
 
 func RequestPageSize(r *http.Request) (pageSize int) {
+	if pageSize, ok := r.Context().Value(contextPageSizeKey).(int); ok {
+		return pageSize
+	}
 	var err error
 	if pageSize, err = strconv.Atoi(r.Header.Get(HeaderSpirentPageSize)); err != nil {
 		pageSize = math.MaxInt32
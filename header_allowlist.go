@@ -0,0 +1,116 @@
+package luddite
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// defaultAllowedHeaders are the response headers that are always allowed when
+// response headers are sanitized. Names ending in "*" match by prefix.
+var defaultAllowedHeaders = []string{
+	HeaderAcceptPatch,
+	"Accept-Ranges",
+	"Access-Control-*",
+	HeaderAge,
+	HeaderAllow,
+	HeaderCacheControl,
+	HeaderConnection,
+	HeaderContentDisposition,
+	HeaderContentEncoding,
+	"Content-Language",
+	HeaderContentLength,
+	"Content-Range",
+	"Content-Security-Policy",
+	HeaderContentType,
+	"Date",
+	HeaderETag,
+	HeaderExpires,
+	"Last-Modified",
+	HeaderLink,
+	HeaderLocation,
+	HeaderPreferenceApplied,
+	"RateLimit-*",
+	HeaderRetryAfter,
+	HeaderServerTiming,
+	"Referrer-Policy",
+	"Set-Cookie",
+	"Strict-Transport-Security",
+	HeaderTrailer,
+	"Transfer-Encoding",
+	HeaderVary,
+	HeaderWarning,
+	HeaderWwwAuthenticate,
+	"X-Content-Type-Options",
+	"X-Frame-Options",
+	HeaderRequestId,
+	HeaderResponseTime,
+	HeaderSessionId,
+	"X-Spirent-*",
+}
+
+// headerAllowlist strips response headers and trailers that aren't explicitly
+// allowed, so that internal headers set by resources or upstream services don't
+// leak to clients.
+type headerAllowlist struct {
+	names    map[string]bool
+	prefixes []string
+}
+
+func newHeaderAllowlist(allowed []string) *headerAllowlist {
+	a := &headerAllowlist{names: make(map[string]bool)}
+	for _, name := range append(append([]string(nil), defaultAllowedHeaders...), allowed...) {
+		if strings.HasSuffix(name, "*") {
+			a.prefixes = append(a.prefixes, http.CanonicalHeaderKey(strings.TrimSuffix(name, "*")))
+		} else {
+			a.names[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+	return a
+}
+
+func (a *headerAllowlist) allows(name string) bool {
+	name = http.CanonicalHeaderKey(strings.TrimPrefix(name, http.TrailerPrefix))
+	if a.names[name] {
+		return true
+	}
+	for _, prefix := range a.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitize removes the headers that aren't allowed and returns their names.
+func (a *headerAllowlist) sanitize(header http.Header) (stripped []string) {
+	for name := range header {
+		if !a.allows(name) {
+			stripped = append(stripped, name)
+			delete(header, name)
+		}
+	}
+	sort.Strings(stripped)
+	return
+}
+
+// sanitizeTrailers removes the trailers that aren't allowed from the headers
+// of a response whose header has been written, and returns their names.
+// Trailers are either declared by the Trailer header or set with
+// http.TrailerPrefix.
+func (a *headerAllowlist) sanitizeTrailers(header http.Header) (stripped []string) {
+	declared := make(map[string]bool)
+	for _, v := range header[HeaderTrailer] {
+		for _, name := range strings.Split(v, ",") {
+			declared[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+		}
+	}
+	for name := range header {
+		if (declared[name] || strings.HasPrefix(name, http.TrailerPrefix)) && !a.allows(name) {
+			stripped = append(stripped, name)
+			delete(header, name)
+		}
+	}
+	sort.Strings(stripped)
+	return
+}
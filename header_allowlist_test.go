@@ -0,0 +1,101 @@
+package luddite
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type leakyResource struct{}
+
+func (r *leakyResource) Get(req *http.Request) (int, interface{}) {
+	header := ContextResponseWriter(req.Context()).Header()
+	header.Set("X-Backend-Host", "db-7.internal")
+	header.Set("X-Acme-Trace", "1")
+	header.Set(HeaderContentType, ContentTypePlain)
+	return http.StatusOK, "ok"
+}
+
+func TestResponseHeaderAllowlist(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.ResponseHeaders.Enabled = true
+		config.ResponseHeaders.Allowed = []string{"x-acme-*"}
+	})
+	logs := new(bytes.Buffer)
+	s.defaultLogger.Out = logs
+	mustAddResource(t, s, 1, "/leaky", &leakyResource{})
+
+	req, _ := http.NewRequest("GET", "/leaky", nil)
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected 200 response, got: %d", rw.Code)
+	}
+	if rw.Header().Get("X-Backend-Host") != "" {
+		t.Error("expected internal header to be stripped")
+	}
+	for _, name := range []string{"X-Acme-Trace", HeaderContentType, HeaderRequestId} {
+		if rw.Header().Get(name) == "" {
+			t.Errorf("expected %s header to be allowed", name)
+		}
+	}
+	if !strings.Contains(logs.String(), "X-Backend-Host") {
+		t.Errorf("expected stripped header to be logged, got: %s", logs.String())
+	}
+
+	s.setDraining()
+	rw = httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	if rw.Header().Get(HeaderConnection) != "close" {
+		t.Errorf("expected Connection: close to be allowed while draining, got: %q", rw.Header().Get(HeaderConnection))
+	}
+}
+
+type trailerResource struct{}
+
+func (r *trailerResource) Get(req *http.Request) (int, interface{}) {
+	rw := ContextResponseWriter(req.Context())
+	header := rw.Header()
+	header.Set(HeaderTrailer, "X-Backend-Cost, X-Acme-Digest")
+	header.Set("Strict-Transport-Security", "max-age=63072000")
+	header.Set("Content-Security-Policy", "default-src 'none'")
+	header.Set("X-Frame-Options", "DENY")
+	header.Set("Referrer-Policy", "no-referrer")
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte("ok"))
+	header.Set("X-Backend-Cost", "42")
+	header.Set("X-Acme-Digest", "abc")
+	header.Set(http.TrailerPrefix+"X-Backend-Host", "db-7.internal")
+	return 0, nil
+}
+
+func TestResponseTrailerAllowlist(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.ResponseHeaders.Enabled = true
+		config.ResponseHeaders.Allowed = []string{"x-acme-*"}
+	})
+	logs := new(bytes.Buffer)
+	s.defaultLogger.Out = logs
+	mustAddResource(t, s, 1, "/trailers", &trailerResource{})
+
+	req, _ := http.NewRequest("GET", "/trailers", nil)
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	res := rw.Result()
+	for _, name := range []string{"Strict-Transport-Security", "Content-Security-Policy", "X-Frame-Options", "Referrer-Policy"} {
+		if res.Header.Get(name) == "" {
+			t.Errorf("expected %s header to be allowed", name)
+		}
+	}
+	if res.Trailer.Get("X-Acme-Digest") != "abc" {
+		t.Errorf("expected allowed trailer, got: %v", res.Trailer)
+	}
+	if res.Trailer.Get("X-Backend-Cost") != "" || res.Trailer.Get("X-Backend-Host") != "" {
+		t.Errorf("expected internal trailers to be stripped, got: %v", res.Trailer)
+	}
+	if !strings.Contains(logs.String(), "X-Backend-Cost") {
+		t.Errorf("expected stripped trailer to be logged, got: %s", logs.String())
+	}
+}
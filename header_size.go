@@ -0,0 +1,95 @@
+package luddite
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// essentialHeaders are never dropped to bring response headers within their
+// size limits, since responses are malformed or misleading without them. CORS
+// response headers (Access-Control-*) are essential too: browsers reject
+// cross-origin responses without them.
+var essentialHeaders = map[string]bool{
+	HeaderCacheControl:    true,
+	HeaderContentEncoding: true,
+	HeaderContentLength:   true,
+	HeaderContentType:     true,
+	HeaderETag:            true,
+	HeaderLocation:        true,
+	HeaderRequestId:       true,
+	HeaderRetryAfter:      true,
+	HeaderSetCookie:       true,
+	HeaderTrailer:         true,
+	HeaderVary:            true,
+	HeaderWwwAuthenticate: true,
+}
+
+func isEssentialHeader(name string) bool {
+	return essentialHeaders[name] || strings.HasPrefix(name, "Access-Control-")
+}
+
+// headerLimits caps the size of response headers. Some proxies silently drop
+// responses whose headers are too large, so oversized headers are dropped
+// (rather than truncated, which would leave values such as Link headers and
+// cookies malformed) before the response is written.
+type headerLimits struct {
+	maxHeaderSize int
+	maxTotalSize  int
+}
+
+// droppedHeader records a response header dropped for exceeding a size limit.
+type droppedHeader struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+func newHeaderLimits(maxHeaderSize, maxTotalSize int) *headerLimits {
+	return &headerLimits{maxHeaderSize: maxHeaderSize, maxTotalSize: maxTotalSize}
+}
+
+// headerSize returns the number of bytes a header occupies in an HTTP/1.1
+// response, i.e. "Name: value\r\n" for each value.
+func headerSize(name string, values []string) (size int) {
+	for _, v := range values {
+		size += len(name) + len(v) + 4
+	}
+	return
+}
+
+// limit drops headers that exceed the per-header limit and then, while the
+// headers exceed the total limit, the largest remaining non-essential headers.
+func (l *headerLimits) limit(header http.Header) (dropped []droppedHeader) {
+	total := 0
+	var candidates []droppedHeader
+	for name, values := range header {
+		size := headerSize(name, values)
+		if l.maxHeaderSize > 0 && size > l.maxHeaderSize && !isEssentialHeader(name) {
+			dropped = append(dropped, droppedHeader{name, size})
+			delete(header, name)
+			continue
+		}
+		total += size
+		if !isEssentialHeader(name) {
+			candidates = append(candidates, droppedHeader{name, size})
+		}
+	}
+	if l.maxTotalSize > 0 && total > l.maxTotalSize {
+		sort.Slice(candidates, func(i, j int) bool {
+			if candidates[i].Size != candidates[j].Size {
+				return candidates[i].Size > candidates[j].Size
+			}
+			return candidates[i].Name < candidates[j].Name
+		})
+		for _, c := range candidates {
+			if total <= l.maxTotalSize {
+				break
+			}
+			dropped = append(dropped, c)
+			delete(header, c.Name)
+			total -= c.Size
+		}
+	}
+	sort.Slice(dropped, func(i, j int) bool { return dropped[i].Name < dropped[j].Name })
+	return
+}
@@ -0,0 +1,61 @@
+package luddite
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHeaderLimits(t *testing.T) {
+	header := http.Header{}
+	header.Set(HeaderContentType, ContentTypeJson)
+	header.Set(HeaderLink, strings.Repeat("l", 500))
+	header.Set("X-Debug-A", strings.Repeat("a", 60))
+	header.Set("X-Debug-B", strings.Repeat("b", 30))
+	header.Set(HeaderLocation, strings.Repeat("/", 200))
+	header.Set(HeaderSetCookie, "session="+strings.Repeat("s", 300))
+	header.Set("Access-Control-Expose-Headers", strings.Repeat("x", 300))
+	header.Set(HeaderWwwAuthenticate, `Bearer realm="`+strings.Repeat("r", 300)+`"`)
+
+	dropped := newHeaderLimits(256, 1300).limit(header)
+	if len(dropped) != 2 || dropped[0].Name != HeaderLink || dropped[1].Name != "X-Debug-A" {
+		t.Errorf("expected Link and X-Debug-A headers to be dropped, got: %v", dropped)
+	}
+	for _, name := range []string{HeaderContentType, HeaderLocation, "X-Debug-B", HeaderSetCookie, "Access-Control-Expose-Headers", HeaderWwwAuthenticate} {
+		if header.Get(name) == "" {
+			t.Errorf("expected %s header to be kept", name)
+		}
+	}
+}
+
+type headerHeavyResource struct{}
+
+func (r *headerHeavyResource) Get(req *http.Request) (int, interface{}) {
+	ContextResponseWriter(req.Context()).Header().Set("X-Spirent-Debug", strings.Repeat("d", 8192))
+	return http.StatusOK, "ok"
+}
+
+func TestOversizedResponseHeaders(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Limits.MaxResponseHeaderSize = 4096
+	})
+	logs := new(bytes.Buffer)
+	s.defaultLogger.Out = logs
+	mustAddResource(t, s, 1, "/heavy", &headerHeavyResource{})
+
+	req, _ := http.NewRequest("GET", "/heavy", nil)
+	req.Header.Set(HeaderAccept, ContentTypePlain)
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected 200 response, got: %d", rw.Code)
+	}
+	if rw.Header().Get("X-Spirent-Debug") != "" {
+		t.Error("expected oversized header to be dropped")
+	}
+	if !strings.Contains(logs.String(), "dropped oversized response headers") {
+		t.Errorf("expected dropped header to be logged, got: %s", logs.String())
+	}
+}
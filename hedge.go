@@ -0,0 +1,149 @@
+package luddite
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"gopkg.in/SpirentOrion/trace.v2"
+)
+
+// HedgedDo sends an outbound request using the named HTTP client and, if no
+// response has arrived after the client's HedgeDelay, sends a second attempt.
+// The first response wins and the other attempt is cancelled. This trims tail
+// latency when calling flaky upstreams, at the cost of some duplicate work
+// upstream.
+//
+// Only idempotent requests (GET, HEAD, OPTIONS, PUT and DELETE) are hedged,
+// and requests with bodies must support GetBody (as those created by
+// http.NewRequest with in-memory bodies do). Other requests are sent once.
+// Both attempts are made with the instrumented client, so they're traced and
+// counted in the service's metrics under a parent "hedge" span.
+func (s *Service) HedgedDo(name string, req *http.Request) (*http.Response, error) {
+	if name == "" {
+		name = defaultHTTPClientName
+	}
+	config := s.httpClientConfig(name)
+	return hedge(s.HTTPClient(name), name, req, config.HedgeDelay)
+}
+
+func hedgeable(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+	default:
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+type hedgeResult struct {
+	attempt int
+	res     *http.Response
+	err     error
+}
+
+func hedge(client *http.Client, name string, req *http.Request, delay time.Duration) (res *http.Response, err error) {
+	if delay <= 0 || !hedgeable(req) {
+		return client.Do(req)
+	}
+
+	trace.Do(req.Context(), TraceKindHTTP, "hedge "+req.Method+" "+req.URL.Host, func(ctx context.Context) {
+		var (
+			results = make(chan hedgeResult, 2)
+			cancels []context.CancelFunc
+		)
+		send := func() {
+			attempt := len(cancels)
+			actx, cancel := context.WithCancel(ctx)
+			cancels = append(cancels, cancel)
+			r := req.WithContext(actx)
+			if attempt > 0 && req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					results <- hedgeResult{attempt, nil, err}
+					return
+				}
+				r.Body = body
+			}
+			go func() {
+				res, err := client.Do(r)
+				results <- hedgeResult{attempt, res, err}
+			}()
+		}
+
+		send()
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		winner := -1
+		for pending := 1; pending > 0 && winner < 0; {
+			select {
+			case <-timer.C:
+				if len(cancels) == 1 {
+					send()
+					pending++
+				}
+			case r := <-results:
+				pending--
+				res, err = r.res, r.err
+				if err != nil {
+					continue
+				}
+				winner = r.attempt
+
+				// Cancel the loser, discarding its response if it
+				// arrives anyway, and cancel the winner once its
+				// body has been read
+				for i, cancel := range cancels {
+					if i != winner {
+						cancel()
+					}
+				}
+				if pending > 0 {
+					go func() {
+						if r := <-results; r.res != nil {
+							_ = r.res.Body.Close()
+						}
+					}()
+				}
+				res.Body = &cancelingBody{res.Body, cancels[winner]}
+			}
+		}
+		if winner < 0 {
+			for _, cancel := range cancels {
+				cancel()
+			}
+		}
+
+		outcome := "error"
+		switch {
+		case winner == 0 && len(cancels) == 1:
+			outcome = "not_hedged"
+		case winner == 0:
+			outcome = "original"
+		case winner == 1:
+			outcome = "hedge"
+		}
+		httpClientHedges.WithLabelValues(name, outcome).Inc()
+		if data := trace.Annotate(ctx); data != nil {
+			data["client"] = name
+			data["attempts"] = len(cancels)
+			data["outcome"] = outcome
+		}
+	})
+	return
+}
+
+// cancelingBody cancels a hedged attempt's context once its response body is
+// closed.
+type cancelingBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
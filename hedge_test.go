@@ -0,0 +1,83 @@
+package luddite
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHedgedDo(t *testing.T) {
+	var attempts int32
+	canceled := make(chan struct{}, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			// The first attempt stalls until it's cancelled
+			select {
+			case <-req.Context().Done():
+				canceled <- struct{}{}
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		_, _ = rw.Write(body)
+	}))
+	defer upstream.Close()
+
+	config := &ServiceConfig{
+		HTTPClients: map[string]HTTPClientConfig{
+			"upstream": {HedgeDelay: 20 * time.Millisecond},
+		},
+	}
+	s := &Service{config: config}
+
+	req, _ := http.NewRequest("PUT", upstream.URL, strings.NewReader("hedged"))
+	start := time.Now()
+	res, err := s.HedgedDo("upstream", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "hedged" {
+		t.Errorf("expected hedge attempt's response, got: %q", body)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("hedged request took too long: %s", elapsed)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("losing attempt not cancelled")
+	}
+}
+
+func TestHedgedDoNotIdempotent(t *testing.T) {
+	var attempts int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		time.Sleep(30 * time.Millisecond)
+	}))
+	defer upstream.Close()
+
+	config := &ServiceConfig{
+		HTTPClients: map[string]HTTPClientConfig{
+			"upstream": {HedgeDelay: 5 * time.Millisecond},
+		},
+	}
+	s := &Service{config: config}
+
+	req, _ := http.NewRequest("POST", upstream.URL, strings.NewReader("once"))
+	res, err := s.HedgedDo("upstream", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Errorf("expected a single attempt, got: %d", n)
+	}
+}
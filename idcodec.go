@@ -0,0 +1,318 @@
+package luddite
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrMalformedId is returned by IdCodec methods for ids that can't be decoded
+// or encoded.
+var ErrMalformedId = errors.New("malformed id")
+
+// IdCodec converts between the ids that appear in resource routes (and
+// Location headers) and the ids that resources use internally. Decoding ids
+// before they reach a resource allows a service to switch to opaque ids
+// without changing its resources.
+type IdCodec interface {
+	// Decode converts an id from a route into the resource's own form.
+	Decode(id string) (string, error)
+
+	// Encode converts a resource's own id into the form used in routes.
+	Encode(id string) (string, error)
+}
+
+// IdCodecProvider is implemented by collection resources whose route ids are
+// encoded. Requests with ids that the resource's codec can't decode receive 404
+// responses without reaching the resource.
+type IdCodecProvider interface {
+	IdCodec() IdCodec
+}
+
+// routeId returns the decoded id route parameter of a request, or false if the
+// request was rejected.
+func routeId(rw http.ResponseWriter, r interface{}, id string) (string, bool) {
+	p, ok := r.(IdCodecProvider)
+	if !ok {
+		return id, true
+	}
+	decoded, err := p.IdCodec().Decode(id)
+	if err != nil {
+		_ = WriteResponse(rw, http.StatusNotFound, NewError(nil, EcodeInvalidId, id))
+		return "", false
+	}
+	return decoded, true
+}
+
+// encodeId returns a resource's id in the form used in routes.
+func encodeId(r interface{}, id string) string {
+	if p, ok := r.(IdCodecProvider); ok {
+		if encoded, err := p.IdCodec().Encode(id); err == nil {
+			return encoded
+		}
+	}
+	return id
+}
+
+type integerCodec struct{}
+
+// NewIntegerCodec returns a codec for decimal integer ids. Ids are
+// canonicalized, e.g. "007" decodes to "7".
+func NewIntegerCodec() IdCodec {
+	return integerCodec{}
+}
+
+func (integerCodec) Decode(id string) (string, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return "", ErrMalformedId
+	}
+	return strconv.FormatInt(n, 10), nil
+}
+
+func (c integerCodec) Encode(id string) (string, error) {
+	return c.Decode(id)
+}
+
+type uuidCodec struct{}
+
+// NewUUIDCodec returns a codec for UUIDs in their hyphenated form, e.g.
+// "6ba7b810-9dad-11d1-80b4-00c04fd430c8". Ids are case-insensitive and are
+// canonicalized to lower case.
+func NewUUIDCodec() IdCodec {
+	return uuidCodec{}
+}
+
+func (uuidCodec) Decode(id string) (string, error) {
+	if len(id) != 36 {
+		return "", ErrMalformedId
+	}
+	b := []byte(strings.ToLower(id))
+	for i, c := range b {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return "", ErrMalformedId
+			}
+		default:
+			if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+				return "", ErrMalformedId
+			}
+		}
+	}
+	return string(b), nil
+}
+
+func (c uuidCodec) Encode(id string) (string, error) {
+	return c.Decode(id)
+}
+
+// ulidAlphabet is Crockford's base32 alphabet, which excludes I, L, O and U.
+const ulidAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+type ulidCodec struct{}
+
+// NewULIDCodec returns a codec for ULIDs, e.g. "01ARZ3NDEKTSV4RRFFQ69G5FAV".
+// Ids are case-insensitive and are canonicalized to upper case.
+func NewULIDCodec() IdCodec {
+	return ulidCodec{}
+}
+
+func (ulidCodec) Decode(id string) (string, error) {
+	// The first character encodes only 3 bits of the 128-bit value
+	if len(id) != 26 || id[0] > '7' {
+		return "", ErrMalformedId
+	}
+	id = strings.ToUpper(id)
+	for i := 0; i < len(id); i++ {
+		if strings.IndexByte(ulidAlphabet, id[i]) < 0 {
+			return "", ErrMalformedId
+		}
+	}
+	return id, nil
+}
+
+func (c ulidCodec) Encode(id string) (string, error) {
+	return c.Decode(id)
+}
+
+const (
+	hashidAlphabet   = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890"
+	hashidSeparators = "cfhistuCFHISTU"
+	hashidSepDiv     = 3.5
+	hashidGuardDiv   = 12
+)
+
+// hashidCodec implements the Hashids algorithm (https://hashids.org) for
+// single non-negative integer ids.
+type hashidCodec struct {
+	alphabet  []byte
+	seps      []byte
+	guards    []byte
+	salt      []byte
+	minLength int
+}
+
+// NewHashidCodec returns a codec that obfuscates non-negative integer ids as
+// Hashids using the given salt, e.g. "12345" as "NkK9" with the salt "this is
+// my salt". Encoded ids are padded to at least minLength characters. Hashids aren't encrypted and must not be
+// relied upon to keep ids secret.
+func NewHashidCodec(salt string, minLength int) IdCodec {
+	c := &hashidCodec{salt: []byte(salt), minLength: minLength}
+	alphabet := []byte(hashidAlphabet)
+	for _, sep := range []byte(hashidSeparators) {
+		if i := indexByte(alphabet, sep); i >= 0 {
+			c.seps = append(c.seps, sep)
+			alphabet = append(alphabet[:i], alphabet[i+1:]...)
+		}
+	}
+	hashidShuffle(c.seps, c.salt)
+
+	if len(c.seps) == 0 || float64(len(alphabet))/float64(len(c.seps)) > hashidSepDiv {
+		sepsLength := int(math.Ceil(float64(len(alphabet)) / hashidSepDiv))
+		if sepsLength == 1 {
+			sepsLength = 2
+		}
+		if sepsLength > len(c.seps) {
+			diff := sepsLength - len(c.seps)
+			c.seps = append(c.seps, alphabet[:diff]...)
+			alphabet = alphabet[diff:]
+		} else {
+			c.seps = c.seps[:sepsLength]
+		}
+	}
+	hashidShuffle(alphabet, c.salt)
+
+	guardCount := int(math.Ceil(float64(len(alphabet)) / hashidGuardDiv))
+	if len(alphabet) < 3 {
+		c.guards = c.seps[:guardCount]
+		c.seps = c.seps[guardCount:]
+	} else {
+		c.guards = alphabet[:guardCount]
+		alphabet = alphabet[guardCount:]
+	}
+	c.alphabet = alphabet
+	return c
+}
+
+func (c *hashidCodec) Decode(id string) (string, error) {
+	if id == "" {
+		return "", ErrMalformedId
+	}
+
+	// Strip the guards and the lottery character
+	parts := splitBytes([]byte(id), c.guards)
+	breakdown := parts[0]
+	if len(parts) == 2 || len(parts) == 3 {
+		breakdown = parts[1]
+	}
+	if len(breakdown) < 2 || len(splitBytes(breakdown[1:], c.seps)) != 1 {
+		return "", ErrMalformedId
+	}
+	lottery := breakdown[0]
+
+	alphabet := append([]byte(nil), c.alphabet...)
+	c.shuffleFor(alphabet, lottery)
+	var n int64
+	for _, b := range breakdown[1:] {
+		i := indexByte(alphabet, b)
+		if i < 0 || n > (math.MaxInt64-int64(i))/int64(len(alphabet)) {
+			return "", ErrMalformedId
+		}
+		n = n*int64(len(alphabet)) + int64(i)
+	}
+
+	// Only the canonical encoding of a number is accepted
+	if c.encode(n) != id {
+		return "", ErrMalformedId
+	}
+	return strconv.FormatInt(n, 10), nil
+}
+
+func (c *hashidCodec) Encode(id string) (string, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || n < 0 {
+		return "", ErrMalformedId
+	}
+	return c.encode(n), nil
+}
+
+func (c *hashidCodec) encode(n int64) string {
+	alphabet := append([]byte(nil), c.alphabet...)
+	numbersHash := n % 100
+	lottery := alphabet[numbersHash%int64(len(alphabet))]
+	c.shuffleFor(alphabet, lottery)
+
+	var hash []byte
+	for {
+		hash = append([]byte{alphabet[n%int64(len(alphabet))]}, hash...)
+		if n /= int64(len(alphabet)); n == 0 {
+			break
+		}
+	}
+	ret := append([]byte{lottery}, hash...)
+
+	if len(ret) < c.minLength {
+		guardIndex := (numbersHash + int64(ret[0])) % int64(len(c.guards))
+		ret = append([]byte{c.guards[guardIndex]}, ret...)
+		if len(ret) < c.minLength {
+			guardIndex = (numbersHash + int64(ret[2])) % int64(len(c.guards))
+			ret = append(ret, c.guards[guardIndex])
+		}
+	}
+	half := len(alphabet) / 2
+	for len(ret) < c.minLength {
+		hashidShuffle(alphabet, append([]byte(nil), alphabet...))
+		padded := append(append(append([]byte(nil), alphabet[half:]...), ret...), alphabet[:half]...)
+		if excess := len(padded) - c.minLength; excess > 0 {
+			padded = padded[excess/2 : excess/2+c.minLength]
+		}
+		ret = padded
+	}
+	return string(ret)
+}
+
+// shuffleFor shuffles the alphabet used to hash a number given its lottery
+// character.
+func (c *hashidCodec) shuffleFor(alphabet []byte, lottery byte) {
+	buffer := append(append([]byte{lottery}, c.salt...), alphabet...)
+	hashidShuffle(alphabet, buffer[:len(alphabet)])
+}
+
+// hashidShuffle is the Hashids algorithm's consistent shuffle.
+func hashidShuffle(alphabet, salt []byte) {
+	if len(salt) == 0 {
+		return
+	}
+	for i, v, p := len(alphabet)-1, 0, 0; i > 0; i, v = i-1, v+1 {
+		v %= len(salt)
+		integer := int(salt[v])
+		p += integer
+		j := (integer + v + p) % i
+		alphabet[i], alphabet[j] = alphabet[j], alphabet[i]
+	}
+}
+
+func indexByte(b []byte, c byte) int {
+	for i := range b {
+		if b[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitBytes splits b around each of the separators.
+func splitBytes(b, seps []byte) [][]byte {
+	var parts [][]byte
+	start := 0
+	for i := range b {
+		if indexByte(seps, b[i]) >= 0 {
+			parts = append(parts, b[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, b[start:])
+}
@@ -0,0 +1,82 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dimfeld/httptreemux"
+)
+
+func TestIdCodecs(t *testing.T) {
+	tests := []struct {
+		codec     IdCodec
+		encoded   string
+		decoded   string
+		malformed []string
+	}{
+		{NewIntegerCodec(), "007", "7", []string{"", "x", "1.5"}},
+		{NewUUIDCodec(), "6BA7B810-9DAD-11D1-80B4-00C04FD430C8", "6ba7b810-9dad-11d1-80b4-00c04fd430c8", []string{"6ba7b810", "6ba7b810-9dad-11d1-80b4-00c04fd430cz", "6ba7b8109-dad-11d1-80b4-00c04fd430c8"}},
+		{NewULIDCodec(), "01arz3ndektsv4rrffq69g5fav", "01ARZ3NDEKTSV4RRFFQ69G5FAV", []string{"01ARZ3NDEK", "81ARZ3NDEKTSV4RRFFQ69G5FAV", "01ARZ3NDEKTSV4RRFFQ69G5FAU"}},
+		{NewHashidCodec("this is my salt", 0), "NkK9", "12345", []string{"", "NkK", "NkK9x", "!!!"}},
+	}
+	for _, test := range tests {
+		decoded, err := test.codec.Decode(test.encoded)
+		if err != nil || decoded != test.decoded {
+			t.Errorf("%T: expected %q to decode to %q, got: %q %v", test.codec, test.encoded, test.decoded, decoded, err)
+		}
+		for _, id := range test.malformed {
+			if _, err = test.codec.Decode(id); err != ErrMalformedId {
+				t.Errorf("%T: expected %q to be malformed", test.codec, id)
+			}
+		}
+	}
+
+	c := NewHashidCodec("this is my salt", 8)
+	for _, id := range []string{"0", "1", "42", "12345", "9223372036854775807"} {
+		encoded, err := c.Encode(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(encoded) < 8 {
+			t.Errorf("expected %s to be padded to 8 characters, got: %s", id, encoded)
+		}
+		if decoded, err := c.Decode(encoded); err != nil || decoded != id {
+			t.Errorf("expected %s to round-trip, got: %s %v", id, decoded, err)
+		}
+	}
+}
+
+type hashidResource struct {
+	id string
+}
+
+func (r *hashidResource) IdCodec() IdCodec { return NewHashidCodec("this is my salt", 0) }
+
+func (r *hashidResource) Get(req *http.Request, id string) (int, interface{}) {
+	r.id = id
+	return http.StatusOK, id
+}
+
+func TestRouteIdCodec(t *testing.T) {
+	r := &hashidResource{}
+	router := httptreemux.NewContextMux()
+	AddGetCollectionRoute(router, "/things", r)
+
+	req, _ := http.NewRequest("GET", "/things/NkK9", nil)
+	rw := httptest.NewRecorder()
+	rw.Header().Set(HeaderContentType, ContentTypePlain)
+	router.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK || r.id != "12345" {
+		t.Errorf("expected decoded id to reach the resource, got: %d %q", rw.Code, r.id)
+	}
+
+	r.id = ""
+	req, _ = http.NewRequest("GET", "/things/12345", nil)
+	rw = httptest.NewRecorder()
+	rw.Header().Set(HeaderContentType, ContentTypeJson)
+	router.ServeHTTP(rw, req)
+	if rw.Code != http.StatusNotFound || r.id != "" {
+		t.Errorf("expected 404 response for malformed id, got: %d", rw.Code)
+	}
+}
@@ -0,0 +1,61 @@
+package luddite
+
+import (
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Lifecycle event names. These are stable so that fleet automation can match
+// the "lifecycle_event" field of service log entries rather than their text.
+const (
+	LifecycleListenerStarted   = "listener_started"
+	LifecycleShutdownInitiated = "shutdown_initiated"
+	LifecycleDrainComplete     = "drain_complete"
+	LifecycleShutdownComplete  = "shutdown_complete"
+	LifecycleConfigReloaded    = "config_reloaded"
+	LifecycleSchemasReloaded   = "schemas_reloaded"
+	LifecyclePluginLoaded      = "plugin_loaded"
+	LifecycleResourceReplaced  = "resource_replaced"
+	LifecycleResourceRemoved   = "resource_removed"
+	LifecycleWorkerRestarted   = "worker_restarted"
+)
+
+const (
+	defaultDrainTimeout = 30 * time.Second
+	drainPollInterval   = 10 * time.Millisecond
+)
+
+// LogLifecycleEvent logs a structured lifecycle event through the service
+// logger. The framework logs its own events (e.g. listener_started and
+// drain_complete); services log the events that only they know about, such as
+// LifecycleConfigReloaded or LifecycleWorkerRestarted.
+func (s *Service) LogLifecycleEvent(event string, fields log.Fields) {
+	logLifecycleEvent(s.defaultLogger, event, fields)
+}
+
+func logLifecycleEvent(logger *log.Logger, event string, fields log.Fields) {
+	entry := logger.WithField("lifecycle_event", event)
+	if len(fields) != 0 {
+		entry = entry.WithFields(fields)
+	}
+	entry.Info(event)
+}
+
+// drain waits for in-flight requests to complete, for up to the configured
+// drain timeout, and returns the number of requests abandoned along with the
+// number that completed while draining. Both counts are read together, so a
+// request completing as the timeout expires is only counted once.
+func (s *Service) drain() (abandoned, drained int32) {
+	deadline := time.Now().Add(s.config.Transport.DrainTimeout)
+	for {
+		s.drainMu.Lock()
+		abandoned, drained = atomic.LoadInt32(&s.inflight), atomic.LoadInt32(&s.drained)
+		s.drainMu.Unlock()
+		if abandoned <= 0 || !time.Now().Before(deadline) {
+			return
+		}
+		time.Sleep(drainPollInterval)
+	}
+}
@@ -0,0 +1,170 @@
+package luddite
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestLogLifecycleEvent(t *testing.T) {
+	s := newTestService(t, nil)
+	logs := new(bytes.Buffer)
+	s.defaultLogger.Out = logs
+
+	s.LogLifecycleEvent(LifecycleWorkerRestarted, log.Fields{"worker": "indexer"})
+	var entry map[string]interface{}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["lifecycle_event"] != LifecycleWorkerRestarted || entry["msg"] != LifecycleWorkerRestarted || entry["worker"] != "indexer" {
+		t.Errorf("unexpected lifecycle log entry: %v", entry)
+	}
+
+	logs.Reset()
+	mustAddResource(t, s, 1, "/blob", &blobResource{ContentTypePlain, []byte("old")})
+	if err := s.RemoveResource(1, "/blob"); err != nil {
+		t.Fatal(err)
+	}
+	entry = nil
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["lifecycle_event"] != LifecycleResourceRemoved || entry["base_path"] != "/blob" {
+		t.Errorf("unexpected lifecycle log entry: %v", entry)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Ready.Enabled = true
+		config.Transport.DrainTimeout = 20 * time.Millisecond
+	})
+	s.defaultLogger.Out = new(bytes.Buffer)
+	s.addReadyRoute()
+
+	s.setDraining()
+	req, _ := http.NewRequest("GET", "/ready", nil)
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 response while draining, got: %d", rw.Code)
+	}
+	if rw.Header().Get(HeaderConnection) != "close" {
+		t.Errorf("expected connection to be closed while draining, got: %v", rw.Header())
+	}
+
+	if n, _ := s.drain(); n != 0 {
+		t.Errorf("expected no abandoned requests, got: %d", n)
+	}
+	atomic.AddInt32(&s.inflight, 1)
+	if n, _ := s.drain(); n != 1 {
+		t.Errorf("expected 1 abandoned request after the drain timeout, got: %d", n)
+	}
+}
+
+func TestShutdownReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "luddite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Transport.DrainTimeout = 20 * time.Millisecond
+		config.Log.ShutdownReportPath = filepath.Join(dir, "shutdown.json")
+	})
+
+	var stopped []string
+	s.OnShutdown("indexer", func() error { stopped = append(stopped, "indexer"); return nil })
+	s.OnShutdown("mailer", func() error { stopped = append(stopped, "mailer"); return errors.New("queue unreachable") })
+	client, server := net.Pipe()
+	defer client.Close()
+	s.hijacked.add(server)
+	atomic.AddInt32(&s.inflight, 1)
+
+	r := s.shutdown()
+	if r.InFlight != 1 || r.Aborted != 1 || r.Drained != 0 || r.HijackedClosed != 1 || r.WorkersStopped != 1 || r.WorkerErrors["mailer"] != "queue unreachable" {
+		t.Errorf("unexpected shutdown report: %+v", r)
+	}
+	if len(stopped) != 2 || stopped[0] != "mailer" {
+		t.Errorf("expected shutdown hooks to run in reverse order, got: %v", stopped)
+	}
+
+	b, err := ioutil.ReadFile(s.config.Log.ShutdownReportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written ShutdownReport
+	if err = json.Unmarshal(b, &written); err != nil || written.Aborted != 1 {
+		t.Errorf("expected shutdown report file, got: %s %v", b, err)
+	}
+}
+
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (rw *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return rw.conn, bufio.NewReadWriter(bufio.NewReader(rw.conn), bufio.NewWriter(rw.conn)), nil
+}
+
+func TestShutdownClosesHijackedConns(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Transport.DrainTimeout = 5 * time.Second
+	})
+	hijacked := make(chan struct{})
+	s.AddHandler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		conn, _, err := rw.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		close(hijacked)
+		// Block like a websocket handler until the connection is closed
+		_, _ = conn.Read(make([]byte, 1))
+	}))
+
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		req, _ := http.NewRequest("GET", "/socket", nil)
+		s.ServeHTTP(&hijackRecorder{httptest.NewRecorder(), server}, req)
+		close(done)
+	}()
+	<-hijacked
+
+	start := time.Now()
+	r := s.shutdown()
+	<-done
+	if r.InFlight != 1 || r.Drained != 1 || r.Aborted != 0 || r.HijackedClosed != 1 {
+		t.Errorf("unexpected shutdown report: %+v", r)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected the hijacked request to drain promptly, took %s", d)
+	}
+
+	// Connections hijacked once shutdown has begun are closed immediately
+	late, peer := net.Pipe()
+	defer peer.Close()
+	s.hijacked.add(late)
+	if _, err := late.Write([]byte("x")); err == nil {
+		t.Error("expected a connection hijacked during shutdown to be closed")
+	}
+	if n := s.hijacked.closedCount(); n != 2 {
+		t.Errorf("expected 2 closed connections, got: %d", n)
+	}
+}
@@ -0,0 +1,144 @@
+package luddite
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// guardListSize enforces the service's unpaginated list limits on a List
+// response. Lists with more than MaxListItems elements, or whose elements
+// serialize to more than MaxListSize bytes, are truncated with a Warning header
+// or, if StrictListSize is set, replaced with a 500 error so that the resource
+// is paginated before it melts down. Resources that declare a maximum page size
+// with PageSizer are exempt.
+//
+// The item limit is applied here. The size limit is applied by WriteResponse,
+// which measures the list as it serializes it.
+func guardListSize(rw http.ResponseWriter, req *http.Request, r CollectionLister, status int, v interface{}) (int, interface{}) {
+	if status != http.StatusOK || v == nil {
+		return status, v
+	}
+	if p, ok := r.(PageSizer); ok {
+		if _, maxSize := p.PageSizes(); maxSize > 0 {
+			return status, v
+		}
+	}
+	d := contextHandlerDetails(req.Context())
+	if d == nil || d.s == nil {
+		return status, v
+	}
+	limits := &d.s.config.Limits
+	if limits.MaxListItems <= 0 && limits.MaxListSize <= 0 {
+		return status, v
+	}
+	list := reflect.ValueOf(v)
+	if list.Kind() == reflect.Ptr {
+		list = list.Elem()
+	}
+	if list.Kind() != reflect.Slice && list.Kind() != reflect.Array {
+		return status, v
+	}
+
+	g := &listGuard{
+		maxSize: limits.MaxListSize,
+		items:   list.Len(),
+		strict:  limits.StrictListSize,
+		logger:  d.s.defaultLogger,
+		fields: log.Fields{
+			"request_id": d.requestId,
+			"method":     req.Method,
+			"route":      d.route,
+			"items":      list.Len(),
+		},
+	}
+	if limits.MaxListItems > 0 && list.Len() > limits.MaxListItems {
+		if g.strict {
+			return http.StatusInternalServerError, g.reject()
+		}
+		n := limits.MaxListItems
+		g.truncate(rw, n)
+		// Copy rather than slice, since arrays returned by value aren't addressable
+		truncated := reflect.MakeSlice(reflect.SliceOf(list.Type().Elem()), n, n)
+		reflect.Copy(truncated, list)
+		v = truncated.Interface()
+	}
+	if res, ok := rw.(*responseWriter); ok && g.maxSize > 0 {
+		res.listGuard = g
+	}
+	return status, v
+}
+
+// listGuard carries a List response's size limit to WriteResponse.
+type listGuard struct {
+	maxSize int64
+	items   int
+	strict  bool
+	logger  *log.Logger
+	fields  log.Fields
+}
+
+// apply enforces the size limit on a serialized List response. JSON lists are
+// truncated at the last element that fits; lists serialized as other media
+// types can't be truncated without serializing them again, so they're rejected
+// when they exceed the limit.
+func (g *listGuard) apply(rw http.ResponseWriter, ct string, b []byte) ([]byte, *Error) {
+	if int64(len(b)) <= g.maxSize {
+		// The elements can be no larger than the list
+		return b, nil
+	}
+	if ct != ContentTypeJson {
+		return nil, g.reject()
+	}
+	n, end, complete, ok := jsonListPrefix(b, g.maxSize)
+	if !ok || complete {
+		return b, nil
+	}
+	if g.strict {
+		return nil, g.reject()
+	}
+	g.truncate(rw, n)
+	return append(b[:end:end], ']'), nil
+}
+
+// reject logs a list that exceeds the limits and returns the error that
+// replaces it.
+func (g *listGuard) reject() *Error {
+	g.logger.WithFields(g.fields).Error("unpaginated list exceeded limits")
+	return NewError(nil, EcodeListTooLarge, g.items)
+}
+
+// truncate logs a list truncated to n items and adds a Warning header to its
+// response.
+func (g *listGuard) truncate(rw http.ResponseWriter, n int) {
+	g.logger.WithFields(g.fields).WithField("truncated_items", n).Warn("truncated unpaginated list that exceeded limits")
+	rw.Header().Set(HeaderWarning, fmt.Sprintf(`199 - "List truncated to %d of %d items"`, n, g.items))
+}
+
+// jsonListPrefix measures the elements of a serialized JSON array, returning
+// the number of leading elements whose total size is within maxSize and the
+// offset just past the last of them. ok is false if b isn't a JSON array.
+func jsonListPrefix(b []byte, maxSize int64) (n int, end int, complete bool, ok bool) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return
+	}
+	end = int(dec.InputOffset())
+	var size int64
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return 0, 0, false, false
+		}
+		if size += int64(len(raw)); size > maxSize {
+			return n, end, false, true
+		}
+		n++
+		end = int(dec.InputOffset())
+	}
+	return n, end, true, true
+}
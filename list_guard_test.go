@@ -0,0 +1,112 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type unpagedResource struct{}
+
+func (r *unpagedResource) List(req *http.Request) (int, interface{}) {
+	return http.StatusOK, []string{"a", "b", "c", "d", "e"}
+}
+
+func TestListSizeGuard(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		s := newTestService(t, func(config *ServiceConfig) {
+			config.Limits.MaxListItems = 3
+			config.Limits.StrictListSize = strict
+		})
+		mustAddResource(t, s, 1, "/unpaged", &unpagedResource{})
+		mustAddResource(t, s, 1, "/paged", &pagedResource{})
+
+		req, _ := http.NewRequest("GET", "/unpaged", nil)
+		req.Header.Set(HeaderAccept, ContentTypeJson)
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, req)
+		if strict {
+			if rw.Code != http.StatusInternalServerError {
+				t.Errorf("expected 500 response for oversized list in strict mode, got: %d", rw.Code)
+			}
+		} else {
+			if rw.Code != http.StatusOK || rw.Body.String() != `["a","b","c"]` {
+				t.Errorf("expected truncated list, got: %d %s", rw.Code, rw.Body.String())
+			}
+			if rw.Header().Get(HeaderWarning) == "" {
+				t.Error("expected Warning header on truncated list")
+			}
+		}
+
+		req, _ = http.NewRequest("GET", "/paged", nil)
+		req.Header.Set(HeaderAccept, ContentTypeJson)
+		rw = httptest.NewRecorder()
+		s.ServeHTTP(rw, req)
+		if rw.Code != http.StatusOK {
+			t.Errorf("expected paginated resource to be exempt, got: %d", rw.Code)
+		}
+	}
+
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Limits.MaxListSize = 10
+	})
+	mustAddResource(t, s, 1, "/unpaged", &unpagedResource{})
+	req, _ := http.NewRequest("GET", "/unpaged", nil)
+	req.Header.Set(HeaderAccept, ContentTypeJson)
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	if rw.Body.String() != `["a","b","c"]` {
+		t.Errorf("expected list truncated by size, got: %s", rw.Body.String())
+	}
+}
+
+type arrayResource struct{}
+
+func (r *arrayResource) List(req *http.Request) (int, interface{}) {
+	return http.StatusOK, [5]string{"a", "b", "c", "d", "e"}
+}
+
+func TestListSizeGuardArray(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Limits.MaxListItems = 2
+	})
+	mustAddResource(t, s, 1, "/array", &arrayResource{})
+	req, _ := http.NewRequest("GET", "/array", nil)
+	req.Header.Set(HeaderAccept, ContentTypeJson)
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK || rw.Body.String() != `["a","b"]` {
+		t.Errorf("expected truncated array, got: %d %s", rw.Code, rw.Body.String())
+	}
+}
+
+func TestListSizeGuardStrictSize(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Limits.MaxListSize = 10
+		config.Limits.StrictListSize = true
+	})
+	mustAddResource(t, s, 1, "/unpaged", &unpagedResource{})
+	for _, ct := range []string{ContentTypeJson, ContentTypeXml} {
+		req, _ := http.NewRequest("GET", "/unpaged", nil)
+		req.Header.Set(HeaderAccept, ct)
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, req)
+		if rw.Code != http.StatusInternalServerError {
+			t.Errorf("%s: expected 500 response for oversized list, got: %d %s", ct, rw.Code, rw.Body.String())
+		}
+	}
+}
+
+func TestJsonListPrefix(t *testing.T) {
+	b := []byte(`["a",{"b":[1,2]},"c"]`)
+	n, end, complete, ok := jsonListPrefix(b, 14)
+	if !ok || complete || n != 2 || string(b[:end]) != `["a",{"b":[1,2]}` {
+		t.Errorf("unexpected prefix: %d %q %v %v", n, b[:end], complete, ok)
+	}
+	if _, _, complete, ok = jsonListPrefix(b, 100); !ok || !complete {
+		t.Errorf("expected complete list, got: %v %v", complete, ok)
+	}
+	if _, _, _, ok = jsonListPrefix([]byte(`{"a":1}`), 1); ok {
+		t.Error("expected object to be rejected")
+	}
+}
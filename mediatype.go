@@ -0,0 +1,109 @@
+package luddite
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// ErrInvalidMediaType occurs when a media type lacks a type or subtype, e.g.
+// "json".
+var ErrInvalidMediaType = errors.New("media type must have a type and subtype")
+
+// MediaType is a parsed media type, e.g. as given by a Content-Type header.
+// Types, subtypes, and parameter names are lower case.
+type MediaType struct {
+	Type    string
+	Subtype string
+	Params  map[string]string
+}
+
+// ParseMediaType parses a media type such as "application/json;
+// charset=utf-8". A bare "*" is treated as "*/*".
+func ParseMediaType(v string) (MediaType, error) {
+	base, params, err := mime.ParseMediaType(v)
+	if err != nil {
+		return MediaType{}, err
+	}
+	if base == "*" {
+		base = "*/*"
+	}
+	mt := MediaType{Params: params}
+	if i := strings.IndexByte(base, '/'); i > 0 && i < len(base)-1 {
+		mt.Type, mt.Subtype = base[:i], base[i+1:]
+	} else {
+		return MediaType{}, ErrInvalidMediaType
+	}
+	return mt, nil
+}
+
+// Base returns the media type without parameters, e.g. "application/json", or
+// an empty string for the zero MediaType.
+func (mt MediaType) Base() string {
+	if mt.Type == "" {
+		return ""
+	}
+	return mt.Type + "/" + mt.Subtype
+}
+
+// String formats the media type along with its parameters.
+func (mt MediaType) String() string {
+	if mt.Type == "" {
+		return ""
+	}
+	return mime.FormatMediaType(mt.Base(), mt.Params)
+}
+
+// Suffix returns the media type's structured syntax suffix, e.g. "json" for
+// "application/vnd.spirent.thing+json".
+func (mt MediaType) Suffix() string {
+	if i := strings.LastIndexByte(mt.Subtype, '+'); i >= 0 {
+		return mt.Subtype[i+1:]
+	}
+	return ""
+}
+
+// Is reports whether the media type has the same type and subtype as a
+// content type such as ContentTypeJson. Parameters are ignored.
+func (mt MediaType) Is(contentType string) bool {
+	other, err := ParseMediaType(contentType)
+	return err == nil && mt.Type == other.Type && mt.Subtype == other.Subtype
+}
+
+// Matches reports whether the media type is matched by a media range (e.g.
+// "*/*" or "text/*") as used in Accept headers.
+func (mt MediaType) Matches(mediaRange MediaType) bool {
+	return (mediaRange.Type == "*" || mediaRange.Type == mt.Type) &&
+		(mediaRange.Subtype == "*" || mediaRange.Subtype == mt.Subtype)
+}
+
+// RequestMediaType returns the parsed Content-Type of a request. It returns
+// the zero MediaType if the header is missing or invalid.
+func RequestMediaType(r *http.Request) MediaType {
+	mt, _ := ParseMediaType(r.Header.Get(HeaderContentType))
+	return mt
+}
+
+// ResponseMediaType returns the parsed (typically negotiated) Content-Type of
+// a response. It returns the zero MediaType if the header is missing or
+// invalid.
+func ResponseMediaType(rw http.ResponseWriter) MediaType {
+	mt, _ := ParseMediaType(rw.Header().Get(HeaderContentType))
+	return mt
+}
+
+// SetContentType sets a response's Content-Type header.
+func SetContentType(rw http.ResponseWriter, mt MediaType) {
+	rw.Header().Set(HeaderContentType, mt.String())
+}
+
+// SetContentDisposition sets a response's Content-Disposition header, e.g.
+// SetContentDisposition(rw, "attachment", "export.csv").
+func SetContentDisposition(rw http.ResponseWriter, disposition, filename string) {
+	var params map[string]string
+	if filename != "" {
+		params = map[string]string{"filename": filename}
+	}
+	rw.Header().Set(HeaderContentDisposition, mime.FormatMediaType(disposition, params))
+}
@@ -0,0 +1,47 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseMediaType(t *testing.T) {
+	mt, err := ParseMediaType("Application/VND.Spirent.Thing+JSON; charset=utf-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mt.Base() != "application/vnd.spirent.thing+json" || mt.Params["charset"] != "utf-8" {
+		t.Errorf("incorrect media type: %+v", mt)
+	}
+	if mt.Suffix() != "json" {
+		t.Errorf("incorrect suffix: %s", mt.Suffix())
+	}
+	if mt.Is(ContentTypeJson) || !mt.Is("application/vnd.spirent.thing+json") {
+		t.Error("incorrect media type comparison")
+	}
+	if s := mt.String(); s != "application/vnd.spirent.thing+json; charset=utf-8" {
+		t.Errorf("incorrect media type string: %s", s)
+	}
+
+	all, _ := ParseMediaType("*")
+	text, _ := ParseMediaType("text/*")
+	if !mt.Matches(all) || mt.Matches(text) {
+		t.Error("incorrect media range matching")
+	}
+
+	if _, err = ParseMediaType("json"); err != ErrInvalidMediaType {
+		t.Errorf("expected ErrInvalidMediaType for media type without subtype, got: %v", err)
+	}
+}
+
+func TestWriteResponseContentTypeParams(t *testing.T) {
+	rw := httptest.NewRecorder()
+	rw.Header().Set(HeaderContentType, ContentTypeJson+"; charset=utf-8")
+	if err := WriteResponse(rw, http.StatusOK, &sample{Id: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if rw.Code != http.StatusOK || rw.Body.Len() == 0 {
+		t.Errorf("JSON response with content type parameters not serialized: %d", rw.Code)
+	}
+}
@@ -0,0 +1,283 @@
+package luddite
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "luddite"
+
+const (
+	serializationDecode = "decode"
+	serializationEncode = "encode"
+)
+
+const (
+	headerLabelAccept      = "accept"
+	headerLabelContentType = "content_type"
+
+	maxMediaTypeLabels = 100
+
+	// maxMediaTypeNameSize is RFC 6838's limit on type and subtype names.
+	maxMediaTypeNameSize = 127
+)
+
+var (
+	httpClientRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http_client",
+			Name:      "requests_total",
+			Help:      "Total number of outbound HTTP requests by client name, method and status code.",
+		},
+		[]string{"client", "method", "code"},
+	)
+
+	httpClientDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http_client",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of outbound HTTP requests by client name and method.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"client", "method"},
+	)
+
+	httpClientHedges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http_client",
+			Name:      "hedged_requests_total",
+			Help:      "Total number of hedged outbound HTTP requests by client name and outcome (not_hedged, original, hedge or error).",
+		},
+		[]string{"client", "outcome"},
+	)
+
+	httpRequestSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "request_size_bytes",
+			Help:      "Size of HTTP request bodies by method and route.",
+			Buckets:   sizeBuckets,
+		},
+		[]string{"method", "route"},
+	)
+
+	httpResponseSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "response_size_bytes",
+			Help:      "Size of HTTP response bodies by method and route.",
+			Buckets:   sizeBuckets,
+		},
+		[]string{"method", "route"},
+	)
+
+	httpClientAborts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "client_aborts_total",
+			Help:      "Total number of HTTP responses that couldn't be written because the client went away, by method and route.",
+		},
+		[]string{"method", "route"},
+	)
+
+	httpTruncatedResponses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "truncated_responses_total",
+			Help:      "Total number of HTTP response bodies cut short by server-side failures (the connection is closed), by method and route.",
+		},
+		[]string{"method", "route"},
+	)
+
+	httpResponseHeadersDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "response_headers_dropped_total",
+			Help:      "Total number of response headers dropped for exceeding size limits, by method and route.",
+		},
+		[]string{"method", "route"},
+	)
+
+	httpUnsupportedMediaTypes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "unsupported_media_types_total",
+			Help:      "Total number of media types that couldn't be handled, by header (content_type for 415 responses or accept for 406 responses) and media type.",
+		},
+		[]string{"header", "media_type"},
+	)
+
+	httpCancellations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "cancellations_total",
+			Help:      "Total number of HTTP requests cancelled before completion, by method, route and reason (client_disconnect, timeout or shutdown).",
+		},
+		[]string{"method", "route", "reason"},
+	)
+
+	httpCircuitBreakerOpen = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "circuit_breaker_open",
+			Help:      "Whether a route's circuit breaker is open (1) or closed (0), by method and route.",
+		},
+		[]string{"method", "route"},
+	)
+
+	httpCircuitBreakerRejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "circuit_breaker_rejections_total",
+			Help:      "Total number of HTTP requests rejected by open circuit breakers, by method and route.",
+		},
+		[]string{"method", "route"},
+	)
+
+	httpSerializationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "serialization_duration_seconds",
+			Help:      "Latency of request body decoding and response body encoding by operation (encode or decode), content type and route.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+		},
+		[]string{"operation", "content_type", "route"},
+	)
+
+	httpSerializationSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "serialization_size_bytes",
+			Help:      "Size of decoded request bodies and encoded response bodies by operation (encode or decode), content type and route.",
+			Buckets:   sizeBuckets,
+		},
+		[]string{"operation", "content_type", "route"},
+	)
+
+	// sizeBuckets range from 100 bytes to 100 MB.
+	sizeBuckets = prometheus.ExponentialBuckets(100, 10, 7)
+
+	// registeredMediaTypeLabels holds the media types that luddite knows, which
+	// are always used as metrics labels.
+	registeredMediaTypeLabels = map[string]bool{
+		ContentTypeCss:               true,
+		ContentTypeCsv:               true,
+		ContentTypeGif:               true,
+		ContentTypeHtml:              true,
+		ContentTypeJson:              true,
+		ContentTypeJsonPatch:         true,
+		ContentTypeMergePatch:        true,
+		ContentTypeMsgpack:           true,
+		ContentTypeMultipartFormData: true,
+		ContentTypeNdjson:            true,
+		ContentTypeOctetStream:       true,
+		ContentTypePlain:             true,
+		ContentTypePng:               true,
+		ContentTypeProtobuf:          true,
+		ContentTypeWwwFormUrlencoded: true,
+		ContentTypeXml:               true,
+		ContentTypeYaml:              true,
+	}
+
+	// mediaTypeLabels holds the other media types used as metrics labels so
+	// far. They come from clients, so their number is capped.
+	mediaTypeLabels   = make(map[string]bool)
+	mediaTypeLabelsMu sync.Mutex
+
+	registerMetricsOnce sync.Once
+)
+
+// routeLabel returns the metrics label for a request's route.
+func routeLabel(d *handlerDetails) string {
+	if d.route == "" {
+		return "other"
+	}
+	return d.route
+}
+
+// observeSerialization records the duration and payload size of encoding a
+// response body or decoding a request body.
+func observeSerialization(operation, contentType, route string, dur time.Duration, size int64) {
+	httpSerializationDuration.WithLabelValues(operation, contentType, route).Observe(dur.Seconds())
+	httpSerializationSize.WithLabelValues(operation, contentType, route).Observe(float64(size))
+}
+
+// mediaTypeLabel returns the metrics label for a client-supplied media type:
+// its base type, "invalid" if it isn't a well-formed media type, or "other" once
+// the maximum number of distinct labels is in use. Registered media types don't
+// count towards the maximum, so they are always labeled.
+func mediaTypeLabel(v string) string {
+	mt, err := ParseMediaType(v)
+	if err != nil || len(mt.Type) > maxMediaTypeNameSize || len(mt.Subtype) > maxMediaTypeNameSize {
+		return "invalid"
+	}
+	label := mt.Base()
+	if registeredMediaTypeLabels[label] {
+		return label
+	}
+	mediaTypeLabelsMu.Lock()
+	defer mediaTypeLabelsMu.Unlock()
+	if !mediaTypeLabels[label] {
+		if len(mediaTypeLabels) >= maxMediaTypeLabels {
+			return "other"
+		}
+		mediaTypeLabels[label] = true
+	}
+	return label
+}
+
+// observeUnsupportedContentType counts a request body content type that
+// couldn't be deserialized.
+func observeUnsupportedContentType(ct string) {
+	httpUnsupportedMediaTypes.WithLabelValues(headerLabelContentType, mediaTypeLabel(ct)).Inc()
+}
+
+// observeNotAcceptable counts each media range of an Accept header that
+// couldn't be satisfied.
+func observeNotAcceptable(accept string) {
+	for _, r := range strings.Split(accept, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			httpUnsupportedMediaTypes.WithLabelValues(headerLabelAccept, mediaTypeLabel(r)).Inc()
+		}
+	}
+}
+
+// registerMetrics registers luddite's own collectors with the default
+// Prometheus registry. It is safe to call more than once.
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		prometheus.MustRegister(
+			httpClientRequests,
+			httpClientDuration,
+			httpClientHedges,
+			httpRequestSize,
+			httpResponseSize,
+			httpClientAborts,
+			httpTruncatedResponses,
+			httpResponseHeadersDropped,
+			httpUnsupportedMediaTypes,
+			httpCancellations,
+			httpCircuitBreakerOpen,
+			httpCircuitBreakerRejections,
+			httpSerializationDuration,
+			httpSerializationSize,
+		)
+	})
+}
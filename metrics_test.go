@@ -0,0 +1,40 @@
+package luddite
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMediaTypeLabel(t *testing.T) {
+	saved := mediaTypeLabels
+	mediaTypeLabels = make(map[string]bool)
+	defer func() { mediaTypeLabels = saved }()
+
+	tests := []struct {
+		v     string
+		label string
+	}{
+		{"application/vnd.example+json; charset=utf-8", "application/vnd.example+json"},
+		{"text/", "invalid"},
+		{"not a media type", "invalid"},
+		{"application/" + strings.Repeat("x", maxMediaTypeNameSize+1), "invalid"},
+	}
+	for _, test := range tests {
+		if label := mediaTypeLabel(test.v); label != test.label {
+			t.Errorf("%q: expected label %q, got: %q", test.v, test.label, label)
+		}
+	}
+
+	// Once client-supplied labels are exhausted, registered media types are
+	// still labeled
+	for i := 0; len(mediaTypeLabels) < maxMediaTypeLabels; i++ {
+		mediaTypeLabel("application/x-" + strconv.Itoa(i))
+	}
+	if label := mediaTypeLabel("application/x-overflow"); label != "other" {
+		t.Errorf("expected overflow label, got: %q", label)
+	}
+	if label := mediaTypeLabel(ContentTypeMsgpack); label != ContentTypeMsgpack {
+		t.Errorf("expected registered media type label, got: %q", label)
+	}
+}
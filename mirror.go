@@ -0,0 +1,113 @@
+package luddite
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const mirrorHTTPClientName = "mirror"
+
+// hopByHopHeaders aren't copied to mirrored requests.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// mirror asynchronously copies a sample of requests to a shadow backend. The
+// shadow's responses are ignored. Requests whose bodies exceed maxBodySize
+// aren't mirrored, and requests are dropped rather than queued when
+// maxOutstanding mirrored requests are already in flight.
+type mirror struct {
+	s           *Service
+	baseURL     string
+	sampleRate  float64
+	maxBodySize int64
+	outstanding chan struct{}
+	logger      *log.Logger
+}
+
+func newMirrorHandler(s *Service, baseURL string, sampleRate float64, maxBodySize int64, maxOutstanding int) http.Handler {
+	return &mirror{
+		s:           s,
+		baseURL:     strings.TrimSuffix(baseURL, "/"),
+		sampleRate:  sampleRate,
+		maxBodySize: maxBodySize,
+		outstanding: make(chan struct{}, maxOutstanding),
+		logger:      s.defaultLogger,
+	}
+}
+
+func (m *mirror) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if m.sampleRate < 1 && rand.Float64() >= m.sampleRate {
+		return
+	}
+
+	// Buffer the body so that it can be read by both the service and the
+	// mirrored request. The service's own copy is restored even when the body
+	// is too large to mirror.
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		b, err := ioutil.ReadAll(io.LimitReader(req.Body, m.maxBodySize+1))
+		if int64(len(b)) > m.maxBodySize || err != nil {
+			req.Body = readCloser{io.MultiReader(bytes.NewReader(b), req.Body), req.Body}
+			return
+		}
+		body = b
+		req.Body = readCloser{bytes.NewReader(b), req.Body}
+	}
+
+	select {
+	case m.outstanding <- struct{}{}:
+	default:
+		return
+	}
+
+	shadow, err := http.NewRequest(req.Method, m.baseURL+req.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		<-m.outstanding
+		return
+	}
+	for k, v := range req.Header {
+		shadow.Header[k] = append([]string(nil), v...)
+	}
+	for _, v := range req.Header["Connection"] {
+		for _, token := range strings.Split(v, ",") {
+			shadow.Header.Del(strings.TrimSpace(token))
+		}
+	}
+	for _, k := range hopByHopHeaders {
+		shadow.Header.Del(k)
+	}
+	shadow.Header.Set(HeaderRequestId, ContextRequestId(req.Context()))
+	shadow.Header.Set(HeaderSpirentMirrored, "true")
+	shadow.Host = req.Host
+
+	go m.send(shadow)
+}
+
+func (m *mirror) send(shadow *http.Request) {
+	defer func() { <-m.outstanding }()
+	res, err := m.s.HTTPClient(mirrorHTTPClientName).Do(shadow.WithContext(context.Background()))
+	if err != nil {
+		m.logger.WithFields(log.Fields{
+			"request_id": shadow.Header.Get(HeaderRequestId),
+			"url":        shadow.URL.String(),
+		}).Debug("mirrored request failed: ", err)
+		return
+	}
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	_ = res.Body.Close()
+}
@@ -0,0 +1,67 @@
+package luddite
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mirrored struct {
+	uri    string
+	body   string
+	header http.Header
+}
+
+func TestMirror(t *testing.T) {
+	shadowed := make(chan mirrored, 1)
+	shadow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		shadowed <- mirrored{req.URL.RequestURI(), string(body), req.Header}
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer shadow.Close()
+
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Mirror.Enabled = true
+		config.Mirror.URL = shadow.URL
+	})
+	mustAddResource(t, s, 1, "/samples", &eventResource{})
+
+	req, _ := http.NewRequest("POST", "/samples?x=1", strings.NewReader(`{"id":"a"}`))
+	req.Header.Set(HeaderAccept, ContentTypeJson)
+	req.Header.Set(HeaderContentType, ContentTypeJson)
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	if rw.Code != http.StatusCreated {
+		t.Errorf("unexpected status: %d", rw.Code)
+	}
+
+	select {
+	case m := <-shadowed:
+		if m.uri != "/samples?x=1" {
+			t.Errorf("incorrect mirrored URI: %s", m.uri)
+		}
+		if m.body != `{"id":"a"}` {
+			t.Errorf("incorrect mirrored body: %s", m.body)
+		}
+		if m.header.Get(HeaderSpirentMirrored) != "true" || m.header.Get(HeaderRequestId) != rw.Header().Get(HeaderRequestId) {
+			t.Errorf("incorrect mirrored headers: %v", m.header)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request not mirrored")
+	}
+}
+
+func TestMirrorMaxBodySize(t *testing.T) {
+	s := &Service{config: new(ServiceConfig)}
+	m := newMirrorHandler(s, "http://127.0.0.1:1", 1, 4, 1)
+
+	req, _ := http.NewRequest("POST", "/samples", strings.NewReader("too large"))
+	m.ServeHTTP(httptest.NewRecorder(), req)
+	if body, _ := ioutil.ReadAll(req.Body); string(body) != "too large" {
+		t.Errorf("request body not restored: %s", body)
+	}
+}
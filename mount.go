@@ -0,0 +1,233 @@
+package luddite
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/dimfeld/httptreemux"
+	log "github.com/sirupsen/logrus"
+)
+
+// swapRetryAfter is the Retry-After value, in seconds, of requests to a
+// resource that is being removed or replaced.
+const swapRetryAfter = 1
+
+// routing holds a service's API routers along with the resources registered
+// with them. It's replaced atomically as resources are removed or replaced at
+// runtime, so requests see either the old or the new routes but never a mix.
+type routing struct {
+	routers   map[int]*httptreemux.ContextMux
+	mounted   map[int][]mountedResource
+	resources map[int][]registeredResource
+}
+
+func newRouting() *routing {
+	return &routing{
+		routers:   make(map[int]*httptreemux.ContextMux),
+		mounted:   make(map[int][]mountedResource),
+		resources: make(map[int][]registeredResource),
+	}
+}
+
+// clone copies the routing's maps so that one API version's routes can be
+// rebuilt without disturbing requests using the others.
+func (rt *routing) clone() *routing {
+	next := newRouting()
+	for v, router := range rt.routers {
+		next.routers[v] = router
+	}
+	for v, mounted := range rt.mounted {
+		next.mounted[v] = mounted
+	}
+	for v, resources := range rt.resources {
+		next.resources[v] = resources
+	}
+	return next
+}
+
+// mountedResource is a resource registered using AddResource.
+type mountedResource struct {
+	basePath string
+	r        interface{}
+	opts     []ResourceOption
+	inflight *int32
+}
+
+// mountedAt returns the mounted resource with the longest base path that a
+// request path belongs to, or nil if there is none.
+func (rt *routing) mountedAt(version int, p string) *mountedResource {
+	var match *mountedResource
+	mounted := rt.mounted[version]
+	for i := range mounted {
+		m := &mounted[i]
+		if underBasePath(m.basePath, p) && (match == nil || len(m.basePath) > len(match.basePath)) {
+			match = m
+		}
+	}
+	return match
+}
+
+// resourceSwap identifies the resource that is being removed or replaced.
+type resourceSwap struct {
+	version  int
+	basePath string
+}
+
+func (s *Service) currentRouting() *routing {
+	return s.routing.Load().(*routing)
+}
+
+func (s *Service) mountResource(rt *routing, router *httptreemux.ContextMux, version int, m mountedResource) {
+	if m.inflight == nil {
+		m.inflight = new(int32)
+	}
+	if len(m.opts) != 0 {
+		var o resourceOptions
+		for _, opt := range m.opts {
+			opt(&o)
+		}
+		rt.resources[version] = append(rt.resources[version], registeredResource{m.basePath, o})
+	}
+	rt.mounted[version] = append(rt.mounted[version], m)
+	s.addCollectionRoutes(router, m.basePath, m.r)
+	s.addSingletonRoutes(router, m.basePath, m.r)
+}
+
+// RemoveResource removes a resource that was added using AddResource (or
+// ReplaceResource) while the service is running. The resource's in-flight
+// requests are drained first, for up to the configured drain timeout; until
+// the API version's routes have been rebuilt, new requests to the resource
+// receive 503 responses with a Retry-After header. Requests to other resources
+// are unaffected. Once RemoveResource returns, no request is using the removed
+// resource.
+//
+// Routes are rebuilt from the resources registered using AddResource, so
+// resources can't be removed or replaced from API versions with routes added
+// directly to the router returned by Router.
+func (s *Service) RemoveResource(version int, basePath string) error {
+	return s.swapResource(version, basePath, nil, nil)
+}
+
+// ReplaceResource replaces a resource while the service is running, or adds
+// it if no resource is registered at basePath. Requests to the resource
+// receive 503 responses with a Retry-After header while the swap is in
+// progress. See RemoveResource for the routes that are rebuilt.
+func (s *Service) ReplaceResource(version int, basePath string, r interface{}, opts ...ResourceOption) error {
+	return s.swapResource(version, basePath, r, opts)
+}
+
+func (s *Service) swapResource(version int, basePath string, r interface{}, opts []ResourceOption) error {
+	if _, err := s.router(version); err != nil {
+		return err
+	}
+	s.routingMu.Lock()
+	defer s.routingMu.Unlock()
+	if s.directRoutes[version] {
+		return fmt.Errorf("API version %d has routes that weren't added using AddResource", version)
+	}
+
+	cur := s.currentRouting()
+	var old *mountedResource
+	for i, m := range cur.mounted[version] {
+		if m.basePath == basePath {
+			old = &cur.mounted[version][i]
+			break
+		}
+	}
+	if old == nil && r == nil {
+		return fmt.Errorf("no resource is registered at %s for API version %d", basePath, version)
+	}
+
+	event := LifecycleResourceReplaced
+	if r == nil {
+		event = LifecycleResourceRemoved
+	}
+
+	s.swap.Store(resourceSwap{version, basePath})
+	defer s.swap.Store(resourceSwap{})
+	if old != nil && !s.drainResource(old) {
+		s.defaultLogger.WithFields(log.Fields{
+			"api_version": version,
+			"base_path":   basePath,
+			"inflight":    atomic.LoadInt32(old.inflight),
+		}).Warn("swapping resource with requests still in flight")
+	}
+
+	next := cur.clone()
+	router := s.newRouter()
+	next.routers[version] = router
+	next.mounted[version] = nil
+	next.resources[version] = nil
+	for _, m := range cur.mounted[version] {
+		if m.basePath == basePath {
+			if r == nil {
+				continue
+			}
+			m = mountedResource{basePath: basePath, r: r, opts: opts}
+			r = nil
+		}
+		s.mountResource(next, router, version, m)
+	}
+	if r != nil {
+		s.mountResource(next, router, version, mountedResource{basePath: basePath, r: r, opts: opts})
+	}
+	s.routing.Store(next)
+
+	// Cached responses were produced by the old resource
+	if s.cache != nil {
+		s.cache.purge(version, basePath)
+	}
+
+	s.LogLifecycleEvent(event, log.Fields{"api_version": version, "base_path": basePath})
+	return nil
+}
+
+// testHookEnterRouting, if set, runs between loading a request's routing
+// snapshot and counting the request against its resource.
+var testHookEnterRouting func()
+
+// enterRouting returns the current routing snapshot along with the mounted
+// resource that a request path belongs to, whose in-flight count it increments.
+// The snapshot is re-read once the request is counted: if a swap replaced it in
+// between, the swap may already have drained the resource, so the request is
+// counted against the new snapshot instead.
+func (s *Service) enterRouting(version int, p string) (*routing, *mountedResource) {
+	for {
+		rt := s.currentRouting()
+		m := rt.mountedAt(version, p)
+		if testHookEnterRouting != nil {
+			testHookEnterRouting()
+		}
+		if m != nil {
+			atomic.AddInt32(m.inflight, 1)
+		}
+		if s.currentRouting() == rt {
+			return rt, m
+		}
+		if m != nil {
+			atomic.AddInt32(m.inflight, -1)
+		}
+	}
+}
+
+// swappingResource reports whether a request path belongs to a resource that
+// is being removed or replaced.
+func (s *Service) swappingResource(version int, p string) bool {
+	swap := s.swap.Load().(resourceSwap)
+	return swap.basePath != "" && swap.version == version && underBasePath(swap.basePath, p)
+}
+
+// drainResource waits for a resource's in-flight requests to complete, for up
+// to the configured drain timeout, and returns false if some are still in
+// flight.
+func (s *Service) drainResource(m *mountedResource) bool {
+	deadline := time.Now().Add(s.config.Transport.DrainTimeout)
+	for atomic.LoadInt32(m.inflight) > 0 {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(drainPollInterval)
+	}
+	return true
+}
@@ -0,0 +1,210 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReplaceResource(t *testing.T) {
+	s := newTestService(t, nil)
+	mustAddResource(t, s, 1, "/plugin", &blobResource{ContentTypePlain, []byte("v1")})
+	mustAddResource(t, s, 1, "/core", &blobResource{ContentTypePlain, []byte("core")})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set(HeaderAccept, ContentTypePlain)
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, req)
+		return rw
+	}
+
+	// Requests keep being served while the plugin is replaced
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if rw := get("/core"); rw.Code != http.StatusOK {
+				t.Errorf("expected 200 response from unaffected resource, got: %d", rw.Code)
+				return
+			}
+		}
+	}()
+	if err := s.ReplaceResource(1, "/plugin", &blobResource{ContentTypePlain, []byte("v2")}); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if rw := get("/plugin"); rw.Code != http.StatusOK || rw.Body.String() != "v2" {
+		t.Errorf("expected replaced resource, got: %d %q", rw.Code, rw.Body.String())
+	}
+
+	if err := s.RemoveResource(1, "/plugin"); err != nil {
+		t.Fatal(err)
+	}
+	if rw := get("/plugin"); rw.Code != http.StatusNotFound {
+		t.Errorf("expected 404 response from removed resource, got: %d", rw.Code)
+	}
+	if rw := get("/core"); rw.Code != http.StatusOK || rw.Body.String() != "core" {
+		t.Errorf("expected remaining resource, got: %d %q", rw.Code, rw.Body.String())
+	}
+	if err := s.RemoveResource(1, "/plugin"); err == nil {
+		t.Error("expected error removing unregistered resource")
+	}
+}
+
+func TestSwappingResource(t *testing.T) {
+	s := newTestService(t, nil)
+	mustAddResource(t, s, 1, "/plugin", &blobResource{ContentTypePlain, []byte("v1")})
+
+	s.swap.Store(resourceSwap{1, "/plugin"})
+	req, _ := http.NewRequest("GET", "/plugin", nil)
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 response during swap, got: %d", rw.Code)
+	}
+	if rw.Header().Get(HeaderRetryAfter) == "" {
+		t.Error("expected Retry-After header during swap")
+	}
+}
+
+type blockingResource struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingResource) Get(req *http.Request) (int, interface{}) {
+	close(r.started)
+	<-r.release
+	return http.StatusOK, "v1"
+}
+
+func TestReplaceResourceDrainsOldResource(t *testing.T) {
+	s := newTestService(t, nil)
+	old := &blockingResource{make(chan struct{}), make(chan struct{})}
+	mustAddResource(t, s, 1, "/plugin", old)
+	get := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/plugin", nil)
+		req.Header.Set(HeaderAccept, ContentTypePlain)
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, req)
+		return rw
+	}
+
+	inflight := make(chan *httptest.ResponseRecorder)
+	go func() { inflight <- get() }()
+	<-old.started
+	replaced := make(chan error)
+	go func() { replaced <- s.ReplaceResource(1, "/plugin", &blobResource{ContentTypePlain, []byte("v2")}) }()
+
+	// New requests are turned away until the old resource's request completes
+	for s.swap.Load().(resourceSwap).basePath == "" {
+		time.Sleep(time.Millisecond)
+	}
+	if rw := get(); rw.Code != http.StatusServiceUnavailable || rw.Header().Get(HeaderRetryAfter) == "" {
+		t.Errorf("expected 503 response with Retry-After during swap, got: %d", rw.Code)
+	}
+	select {
+	case <-replaced:
+		t.Fatal("swap completed with a request in flight on the old resource")
+	default:
+	}
+	close(old.release)
+	if rw := <-inflight; rw.Code != http.StatusOK || rw.Body.String() != "v1" {
+		t.Errorf("expected in-flight request to complete on the old resource, got: %d %q", rw.Code, rw.Body.String())
+	}
+	if err := <-replaced; err != nil {
+		t.Fatal(err)
+	}
+	if rw := get(); rw.Code != http.StatusOK || rw.Body.String() != "v2" {
+		t.Errorf("expected replaced resource, got: %d %q", rw.Code, rw.Body.String())
+	}
+}
+
+func TestReplaceResourceWithDirectRoutes(t *testing.T) {
+	s := newTestService(t, nil)
+	mustAddResource(t, s, 1, "/plugin", &blobResource{ContentTypePlain, []byte("v1")})
+	router, err := s.Router(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AddGetSingletonRoute(router, "/direct", &blobResource{ContentTypePlain, []byte("direct")})
+	if err = s.ReplaceResource(1, "/plugin", &blobResource{ContentTypePlain, []byte("v2")}); err == nil {
+		t.Error("expected swap to be rejected for an API version with direct routes")
+	}
+}
+
+// retiredResource detects requests dispatched to it after it was replaced.
+type retiredResource struct {
+	retired int32
+	late    *int32
+}
+
+func (r *retiredResource) Get(req *http.Request) (int, interface{}) {
+	if atomic.LoadInt32(&r.retired) != 0 {
+		atomic.AddInt32(r.late, 1)
+	}
+	return http.StatusOK, "ok"
+}
+
+func TestReplaceResourceUnderLoad(t *testing.T) {
+	s := newTestService(t, nil)
+	var late int32
+	cur := &retiredResource{late: &late}
+	mustAddResource(t, s, 1, "/swapped", cur)
+	get := func() {
+		req, _ := http.NewRequest("GET", "/swapped", nil)
+		req.Header.Set(HeaderAccept, ContentTypeJson)
+		s.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// The first request is held after loading its routing snapshot until
+	// the resource has been replaced
+	loaded, swapped := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	testHookEnterRouting = func() {
+		once.Do(func() {
+			close(loaded)
+			<-swapped
+		})
+	}
+	defer func() { testHookEnterRouting = nil }()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				get()
+			}
+		}()
+	}
+	<-loaded
+	for i := 0; i < 100; i++ {
+		next := &retiredResource{late: &late}
+		if err := s.ReplaceResource(1, "/swapped", next); err != nil {
+			t.Fatal(err)
+		}
+		atomic.StoreInt32(&cur.retired, 1)
+		cur = next
+		if i == 0 {
+			close(swapped)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if n := atomic.LoadInt32(&late); n != 0 {
+		t.Errorf("%d requests dispatched to a replaced resource", n)
+	}
+}
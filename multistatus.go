@@ -0,0 +1,120 @@
+package luddite
+
+import (
+	"encoding/xml"
+	"net/http"
+	"sort"
+)
+
+// MultiStatus is a composite response body describing the outcome of each of
+// several operations, e.g. the elements of a bulk create or a fan-out's
+// sub-requests. Resources return a MultiStatus with a 207 (Multi-Status)
+// status so that partial success is reported consistently:
+//
+//	ms := luddite.NewMultiStatus()
+//	for _, thing := range things {
+//		if err := create(thing); err != nil {
+//			ms.Add(thing.Id, http.StatusConflict, nil, err)
+//		} else {
+//			ms.Add(thing.Id, http.StatusCreated, nil, thing)
+//		}
+//	}
+//	return http.StatusMultiStatus, ms
+type MultiStatus struct {
+	XMLName xml.Name           `json:"-" xml:"multistatus"`
+	Items   []*MultiStatusItem `json:"items" xml:"response"`
+}
+
+// MultiStatusItem is the outcome of a single operation in a MultiStatus.
+type MultiStatusItem struct {
+	// Id identifies the operation, e.g. the id of the element it acted on.
+	Id string `json:"id,omitempty"`
+	// Status is the operation's HTTP status code.
+	Status int `json:"status"`
+	// Header holds the headers that the operation's response would have had,
+	// e.g. Location or ETag.
+	Header http.Header `json:"headers,omitempty"`
+	// Body is the operation's response body (or error).
+	Body interface{} `json:"body,omitempty"`
+}
+
+// NewMultiStatus creates an empty MultiStatus.
+func NewMultiStatus() *MultiStatus {
+	return &MultiStatus{Items: []*MultiStatusItem{}}
+}
+
+// Add appends an operation's outcome. Errors (other than *Error values) are
+// reported as internal errors when the response is written.
+func (ms *MultiStatus) Add(id string, status int, header http.Header, body interface{}) *MultiStatusItem {
+	item := &MultiStatusItem{Id: id, Status: status, Header: header, Body: body}
+	ms.Items = append(ms.Items, item)
+	return item
+}
+
+// Succeeded returns the number of operations with 2xx statuses.
+func (ms *MultiStatus) Succeeded() (n int) {
+	for _, item := range ms.Items {
+		if item.Status/100 == 2 {
+			n++
+		}
+	}
+	return
+}
+
+// Partial reports whether some, but not all, operations succeeded.
+func (ms *MultiStatus) Partial() bool {
+	n := ms.Succeeded()
+	return n > 0 && n < len(ms.Items)
+}
+
+// normalize converts item errors into *Error values so that they serialize
+// like top-level error responses.
+func (ms *MultiStatus) normalize() {
+	for _, item := range ms.Items {
+		switch v := item.Body.(type) {
+		case *Error:
+		case error:
+			item.Body = NewError(nil, EcodeInternal, v)
+		}
+	}
+}
+
+type multiStatusHeader struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+// MarshalXML encodes an item's headers as <header name="...">value</header>
+// elements, in name order.
+func (item *MultiStatusItem) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	names := make([]string, 0, len(item.Header))
+	for name := range item.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var headers []multiStatusHeader
+	for _, name := range names {
+		for _, value := range item.Header[name] {
+			headers = append(headers, multiStatusHeader{name, value})
+		}
+	}
+
+	v := struct {
+		Id      string              `xml:"id,omitempty"`
+		Status  int                 `xml:"status"`
+		Headers []multiStatusHeader `xml:"header"`
+		Body    interface{}         `xml:"body,omitempty"`
+	}{item.Id, item.Status, headers, item.Body}
+	return e.EncodeElement(v, start)
+}
+
+// MultiStatus converts a fan-out's results into a MultiStatus, so that
+// fan-outs can report partial success with a 207 status like other composite
+// operations.
+func (resp *FanOutResponse) MultiStatus() *MultiStatus {
+	ms := NewMultiStatus()
+	for _, r := range resp.Results {
+		ms.Add(r.Name, r.Status, nil, r.Body)
+	}
+	return ms
+}
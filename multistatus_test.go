@@ -0,0 +1,71 @@
+package luddite
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMultiStatusJson(t *testing.T) {
+	ms := NewMultiStatus()
+	ms.Add("a", http.StatusCreated, http.Header{HeaderLocation: {"/things/a"}}, map[string]string{"id": "a"})
+	ms.Add("b", http.StatusConflict, nil, errors.New("already exists"))
+	if !ms.Partial() || ms.Succeeded() != 1 {
+		t.Errorf("expected partial success, got: %d succeeded", ms.Succeeded())
+	}
+
+	rw := httptest.NewRecorder()
+	rw.Header().Set(HeaderContentType, ContentTypeJson)
+	if err := WriteResponse(rw, http.StatusMultiStatus, ms); err != nil {
+		t.Fatal(err)
+	}
+	if rw.Code != http.StatusMultiStatus {
+		t.Errorf("expected 207 response, got: %d", rw.Code)
+	}
+	var resp struct {
+		Items []struct {
+			Id      string
+			Status  int
+			Headers http.Header
+			Body    json.RawMessage
+		}
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Items) != 2 || resp.Items[0].Status != http.StatusCreated || resp.Items[0].Headers.Get(HeaderLocation) != "/things/a" {
+		t.Errorf("incorrect first item: %s", rw.Body.String())
+	}
+	var e Error
+	if err := json.Unmarshal(resp.Items[1].Body, &e); err != nil || e.Code != EcodeInternal {
+		t.Errorf("item error not converted: %s", rw.Body.String())
+	}
+}
+
+func TestMultiStatusXml(t *testing.T) {
+	ms := NewMultiStatus()
+	ms.Add("a", http.StatusOK, http.Header{HeaderETag: {`"1"`}}, "ok")
+
+	rw := httptest.NewRecorder()
+	rw.Header().Set(HeaderContentType, ContentTypeXml)
+	if err := WriteResponse(rw, http.StatusMultiStatus, ms); err != nil {
+		t.Fatal(err)
+	}
+	expected := `<multistatus><response><id>a</id><status>200</status><header name="ETag">&#34;1&#34;</header><body>ok</body></response></multistatus>`
+	if body := rw.Body.String(); body != expected {
+		t.Errorf("expected %s, got: %s", expected, body)
+	}
+}
+
+func TestFanOutMultiStatus(t *testing.T) {
+	resp := &FanOutResponse{Results: []*SubResult{
+		{Name: "a", Status: http.StatusOK, Body: "a"},
+		{Name: "b", Status: http.StatusGatewayTimeout},
+	}}
+	ms := resp.MultiStatus()
+	if len(ms.Items) != 2 || ms.Items[0].Id != "a" || ms.Items[1].Status != http.StatusGatewayTimeout || !ms.Partial() {
+		t.Errorf("fan-out results not converted in order: %+v", ms.Items)
+	}
+}
@@ -2,40 +2,133 @@ package luddite
 
 import (
 	"net/http"
+	"sync"
 
 	"github.com/K-Phoen/negotiation"
 )
 
+// errorContentTypes are the formats that error responses are serialized in.
+var errorContentTypes = []string{ContentTypeJson, ContentTypeXml, ContentTypePlain}
+
+// maxNegotiations limits the number of distinct Accept headers whose
+// negotiation results are cached. The cache is cleared once it fills up, so
+// that clients sending unique headers can't grow it without bound.
+const maxNegotiations = 1024
+
 type negotiator struct {
-	acceptedFormats []string
+	acceptedFormats  []string
+	errorContentType string
+
+	mu    sync.RWMutex
+	cache map[string]negotiated
 }
 
-func newNegotiatorHandler(acceptedFormats []string) http.Handler {
+// negotiated is the result of negotiating an Accept header. Either content
+// type is empty if negotiation failed.
+type negotiated struct {
+	contentType      string
+	errorContentType string
+}
+
+func newNegotiatorHandler(acceptedFormats []string, errorContentType string) http.Handler {
 	return &negotiator{
-		acceptedFormats: acceptedFormats,
+		acceptedFormats:  acceptedFormats,
+		errorContentType: errorContentType,
+		cache:            make(map[string]negotiated),
 	}
 }
 
 func (n *negotiator) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	// If no Accept header was included, default to the first accepted format
-	accept := req.Header.Get(HeaderAccept)
-	if accept == "" {
-		accept = n.acceptedFormats[0]
-	}
+	// Clients (typically SDKs) send identical Accept headers on every request,
+	// so negotiation results are cached by header value
+	result := n.negotiate(req.Header.Get(HeaderAccept))
 
-	// Negotiate and set a Content-Type
+	// Set the negotiated Content-Type
 	//
 	// Note: Negotation failures do not return 406 errors here. This allows
 	// resource handlers to potentially inspect/handle certain rarely-used
 	// content types on their own. If a negotiation failure has occurred and
 	// the resource handler doesn't deal with it, then we can expect a 406
 	// from WriteResponse.
-	if format, err := negotiation.NegotiateAccept(accept, n.acceptedFormats); err == nil {
-		rw.Header().Set(HeaderContentType, format.Value)
+	if result.contentType != "" {
+		rw.Header().Set(HeaderContentType, result.contentType)
+	}
+
+	// Error responses have their own negotiated format, which must be readable
+	// by the client whatever the resource would have returned
+	if res, ok := rw.(*responseWriter); ok {
+		res.accept = req.Header.Get(HeaderAccept)
+		res.errorContentType = n.errorContentType
+		if result.errorContentType != "" {
+			res.errorContentType = result.errorContentType
+		}
+	}
+}
+
+// negotiate returns the (possibly cached) negotiation result for an Accept
+// header.
+func (n *negotiator) negotiate(accept string) negotiated {
+	n.mu.RLock()
+	result, ok := n.cache[accept]
+	n.mu.RUnlock()
+	if ok {
+		return result
+	}
+
+	// If no Accept header was included, default to the first accepted format
+	// for successful responses and the configured default for errors
+	if accept == "" {
+		result.contentType = n.acceptedFormats[0]
+	} else {
+		if format, err := negotiation.NegotiateAccept(accept, n.acceptedFormats); err == nil {
+			result.contentType = format.Value
+		}
+		if format, err := negotiation.NegotiateAccept(accept, errorContentTypes); err == nil {
+			result.errorContentType = format.Value
+		}
+	}
+
+	n.mu.Lock()
+	if len(n.cache) >= maxNegotiations {
+		n.cache = make(map[string]negotiated)
+	}
+	n.cache[accept] = result
+	n.mu.Unlock()
+	return result
+}
+
+// isNegotiatedContentType reports whether the service negotiates a content
+// type for its responses.
+func isNegotiatedContentType(ct string) bool {
+	mt, err := ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	for _, x := range negotiatedContentTypes {
+		if mt.Is(x) {
+			return true
+		}
+	}
+	return false
+}
+
+// setErrorContentType ensures that an error response is serialized as JSON,
+// XML or plain text, as negotiated for errors, rather than in a content type
+// that a resource chose for its successful responses (e.g. CSV or PNG).
+func setErrorContentType(rw http.ResponseWriter) {
+	if mt := ResponseMediaType(rw); mt.Is(ContentTypeJson) || mt.Is(ContentTypeXml) {
+		return
+	}
+	ct := defaultErrorContentType
+	if res, ok := rw.(*responseWriter); ok && res.errorContentType != "" {
+		ct = res.errorContentType
 	}
+	rw.Header().Set(HeaderContentType, ct)
 }
 
-// RegisterFormat registers a new format and associated MIME types.
+// RegisterFormat registers a new format and associated MIME types. Formats
+// must be registered before the service handles requests because negotiation
+// results are cached.
 func RegisterFormat(format string, mimeTypes []string) {
 	negotiation.RegisterFormat(format, mimeTypes)
 }
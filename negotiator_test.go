@@ -1,8 +1,10 @@
 package luddite
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -10,7 +12,7 @@ func TestDefaultContentType(t *testing.T) {
 	req, _ := http.NewRequest("GET", "/", nil)
 	rw := httptest.NewRecorder()
 
-	n := newNegotiatorHandler([]string{ContentTypeJson, ContentTypeXml})
+	n := newNegotiatorHandler([]string{ContentTypeJson, ContentTypeXml}, ContentTypeJson)
 	n.ServeHTTP(rw, req)
 
 	if res := rw.Result(); res != nil && res.StatusCode != http.StatusOK {
@@ -26,7 +28,7 @@ func TestSupportedContentType(t *testing.T) {
 	req.Header.Set(HeaderAccept, ContentTypeJson)
 	rw := httptest.NewRecorder()
 
-	n := newNegotiatorHandler([]string{ContentTypeJson, ContentTypeXml})
+	n := newNegotiatorHandler([]string{ContentTypeJson, ContentTypeXml}, ContentTypeJson)
 	n.ServeHTTP(rw, req)
 
 	if res := rw.Result(); res != nil && res.StatusCode != http.StatusOK {
@@ -42,7 +44,7 @@ func TestUnsupportedContentType(t *testing.T) {
 	req.Header.Set(HeaderAccept, ContentTypeCsv)
 	rw := httptest.NewRecorder()
 
-	n := newNegotiatorHandler([]string{ContentTypeJson, ContentTypeXml})
+	n := newNegotiatorHandler([]string{ContentTypeJson, ContentTypeXml}, ContentTypeJson)
 	n.ServeHTTP(rw, req)
 
 	if res := rw.Result(); res != nil && res.StatusCode != http.StatusOK {
@@ -52,3 +54,88 @@ func TestUnsupportedContentType(t *testing.T) {
 		t.Errorf("incorrect content type negotiated: %s", ct)
 	}
 }
+
+type failingDownloadResource struct{}
+
+func (r *failingDownloadResource) Get(req *http.Request) (int, interface{}) {
+	ContextResponseWriter(req.Context()).Header().Set(HeaderContentType, ContentTypeCsv)
+	return http.StatusNotFound, NewError(nil, EcodeRouteNotFound, req.Method, req.URL.Path)
+}
+
+func TestErrorContentType(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Errors.DefaultContentType = ContentTypeXml
+	})
+	mustAddResource(t, s, 1, "/download", &failingDownloadResource{})
+
+	tests := []struct {
+		accept      string
+		contentType string
+		prefix      string
+	}{
+		{"", ContentTypeXml, "<error>"},
+		{ContentTypeCsv, ContentTypeXml, "<error>"},
+		{ContentTypeCsv + ", " + ContentTypeJson + ";q=0.5", ContentTypeJson, "{"},
+		{ContentTypePlain, ContentTypePlain, EcodeRouteNotFound + ": "},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/download", nil)
+		if test.accept != "" {
+			req.Header.Set(HeaderAccept, test.accept)
+		}
+		req.Header.Set(HeaderContentType, "invalid")
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, req)
+		if rw.Code != http.StatusNotFound {
+			t.Errorf("%q: expected 404 response, got: %d", test.accept, rw.Code)
+		}
+		if ct := rw.Header().Get(HeaderContentType); ct != test.contentType {
+			t.Errorf("%q: expected %s error, got: %s", test.accept, test.contentType, ct)
+		}
+		if body := rw.Body.String(); !strings.HasPrefix(body, test.prefix) {
+			t.Errorf("%q: expected body starting with %q, got: %s", test.accept, test.prefix, body)
+		}
+	}
+}
+
+func TestWriteNotAcceptable(t *testing.T) {
+	rw := httptest.NewRecorder()
+	rw.Header().Set(HeaderContentType, ContentTypePng)
+	if err := WriteResponse(rw, http.StatusOK, struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if rw.Code != http.StatusNotAcceptable {
+		t.Errorf("expected 406 response, got: %d", rw.Code)
+	}
+	if ct := rw.Header().Get(HeaderContentType); ct != ContentTypeJson || rw.Body.Len() == 0 {
+		t.Errorf("expected JSON error body, got: %s %q", ct, rw.Body.String())
+	}
+}
+
+func TestNegotiationCache(t *testing.T) {
+	n := newNegotiatorHandler([]string{ContentTypeJson, ContentTypeCsv}, ContentTypeJson).(*negotiator)
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set(HeaderAccept, "text/csv, application/xml;q=0.5")
+		rw := httptest.NewRecorder()
+		res := &responseWriter{}
+		res.init(rw)
+		n.ServeHTTP(res, req)
+		if ct := rw.Header().Get(HeaderContentType); ct != ContentTypeCsv {
+			t.Errorf("request %d: expected %s content type, got: %s", i, ContentTypeCsv, ct)
+		}
+		if res.errorContentType != ContentTypeXml {
+			t.Errorf("request %d: expected %s error content type, got: %s", i, ContentTypeXml, res.errorContentType)
+		}
+	}
+	if len(n.cache) != 1 {
+		t.Errorf("expected 1 cached negotiation, got: %d", len(n.cache))
+	}
+
+	for i := 0; i < maxNegotiations+1; i++ {
+		n.negotiate(fmt.Sprintf("application/x-%d", i))
+	}
+	if len(n.cache) > maxNegotiations {
+		t.Errorf("expected cache to be bounded by %d entries, got: %d", maxNegotiations, len(n.cache))
+	}
+}
@@ -0,0 +1,156 @@
+package luddite
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// NullPolicy controls whether null and zero-valued object members appear in
+// JSON responses. Policies allow an API version to change what's emitted
+// without changing the json tags (e.g. omitempty) of the types that resources
+// share across API versions.
+type NullPolicy string
+
+const (
+	// NullPolicyEmit emits object members as encoding/json produces them,
+	// including explicit nulls. This is the default.
+	NullPolicyEmit NullPolicy = "emit"
+
+	// NullPolicyOmitNull omits object members whose values are null.
+	NullPolicyOmitNull NullPolicy = "omit_null"
+
+	// NullPolicyOmitEmpty omits object members whose values are null, false,
+	// 0, "", [] or {}.
+	NullPolicyOmitEmpty NullPolicy = "omit_empty"
+)
+
+func (p NullPolicy) valid() bool {
+	switch p {
+	case NullPolicyEmit, NullPolicyOmitNull, NullPolicyOmitEmpty:
+		return true
+	}
+	return false
+}
+
+// WithNullPolicy sets the null policy of a resource's JSON responses,
+// overriding the policy configured for its API version.
+func WithNullPolicy(policy NullPolicy) ResourceOption {
+	return func(o *resourceOptions) {
+		o.nullPolicy = policy
+	}
+}
+
+// versionNullPolicy returns the null policy configured for an API version.
+func versionNullPolicy(policies map[int]string, version int) NullPolicy {
+	if p, ok := versionEntry(policies, version); ok {
+		return NullPolicy(p)
+	}
+	return NullPolicyEmit
+}
+
+func (rr *registeredResource) declaresNullPolicy() bool {
+	return rr.nullPolicy != ""
+}
+
+// setNullPolicy selects the null policy of the response to a request served
+// by a route. A resource's policy is resolved from the nearest resource that
+// declares one, so that sub-resources inherit their parent's policy.
+func setNullPolicy(rw http.ResponseWriter, req *http.Request, pattern string) {
+	res, ok := rw.(*responseWriter)
+	d := contextHandlerDetails(req.Context())
+	if !ok || d == nil || d.s == nil {
+		return
+	}
+	res.nullPolicy = versionNullPolicy(d.s.config.Version.NullPolicies, d.apiVersion)
+	if rr := d.currentRouting().matchResource(d.apiVersion, pattern, (*registeredResource).declaresNullPolicy); rr != nil {
+		res.nullPolicy = rr.nullPolicy
+	}
+}
+
+// responseNullPolicy returns the null policy of a response.
+func responseNullPolicy(rw http.ResponseWriter) NullPolicy {
+	if res, ok := rw.(*responseWriter); ok && res.nullPolicy != "" {
+		return res.nullPolicy
+	}
+	return NullPolicyEmit
+}
+
+// applyNullPolicy rewrites a serialized JSON value, omitting the object members
+// that a policy excludes. Member order is preserved; array elements are never
+// omitted.
+func applyNullPolicy(b []byte, policy NullPolicy) ([]byte, error) {
+	if policy == NullPolicyEmit || policy == "" {
+		return b, nil
+	}
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 || (b[0] != '{' && b[0] != '[') {
+		return b, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	out := new(bytes.Buffer)
+	out.WriteByte(b[0])
+	for i := 0; dec.More(); i++ {
+		var key string
+		if b[0] == '{' {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, _ = tok.(string)
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		v, err := applyNullPolicy(raw, policy)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] == '{' && omitted(v, policy) {
+			i--
+			continue
+		}
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		if b[0] == '{' {
+			k, _ := json.Marshal(key)
+			out.Write(k)
+			out.WriteByte(':')
+		}
+		out.Write(v)
+	}
+	end, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	out.WriteString(end.(json.Delim).String())
+	return out.Bytes(), nil
+}
+
+// omitted reports whether a policy excludes an object member with the given
+// (compact) JSON value.
+func omitted(v []byte, policy NullPolicy) bool {
+	s := string(v)
+	if s == "null" {
+		return true
+	}
+	if policy != NullPolicyOmitEmpty {
+		return false
+	}
+	switch s {
+	case "false", `""`, "[]", "{}":
+		return true
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && f == 0 {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestApplyNullPolicy(t *testing.T) {
+	const body = `{"b":null,"a":0,"c":[null,{"x":null,"y":""}],"d":{"e":false},"f":"v"}`
+	tests := []struct {
+		policy   NullPolicy
+		expected string
+	}{
+		{NullPolicyEmit, body},
+		{NullPolicyOmitNull, `{"a":0,"c":[null,{"y":""}],"d":{"e":false},"f":"v"}`},
+		{NullPolicyOmitEmpty, `{"c":[null,{}],"f":"v"}`},
+	}
+	for _, test := range tests {
+		b, err := applyNullPolicy([]byte(body), test.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != test.expected {
+			t.Errorf("%s: expected %s, got: %s", test.policy, test.expected, b)
+		}
+	}
+}
+
+type nullableSample struct {
+	Id   int     `json:"id"`
+	Name *string `json:"name"`
+}
+
+type nullableResource struct{}
+
+func (r *nullableResource) Get(req *http.Request) (int, interface{}) {
+	return http.StatusOK, &nullableSample{Id: 1}
+}
+
+func TestVersionNullPolicy(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Version.Max = 3
+		config.Version.NullPolicies = map[int]string{2: string(NullPolicyOmitNull)}
+	})
+	for v := 1; v <= 3; v++ {
+		mustAddResource(t, s, v, "/nullable", &nullableResource{})
+	}
+	mustAddResource(t, s, 1, "/omitted", &nullableResource{}, WithNullPolicy(NullPolicyOmitNull))
+	mustAddResource(t, s, 1, "/omitted/sub", &nullableResource{}, WithCompression(CompressionOff))
+
+	tests := []struct {
+		version  int
+		path     string
+		expected string
+	}{
+		{1, "/nullable", `{"id":1,"name":null}`},
+		{2, "/nullable", `{"id":1}`},
+		{3, "/nullable", `{"id":1}`},
+		{1, "/omitted", `{"id":1}`},
+		{1, "/omitted/sub", `{"id":1}`},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.path, nil)
+		req.Header.Set(HeaderAccept, ContentTypeJson)
+		req.Header.Set(HeaderSpirentApiVersion, strconv.Itoa(test.version))
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, req)
+		if body := rw.Body.String(); body != test.expected {
+			t.Errorf("v%d %s: expected %s, got: %s", test.version, test.path, test.expected, body)
+		}
+	}
+}
+
+func TestInvalidNullPolicy(t *testing.T) {
+	config := &ServiceConfig{}
+	config.Version.Min = 1
+	config.Version.Max = 1
+	config.Version.NullPolicies = map[int]string{1: "omit_zero"}
+	if _, err := NewService(config); err != ErrInvalidNullPolicy {
+		t.Errorf("expected ErrInvalidNullPolicy, got: %v", err)
+	}
+}
+
+func TestInvalidVersionKey(t *testing.T) {
+	config := &ServiceConfig{}
+	config.Version.Min = 1
+	config.Version.Max = 1
+	config.Version.NullPolicies = map[int]string{0: string(NullPolicyOmitNull)}
+	if _, err := NewService(config); err != ErrInvalidVersionKey {
+		t.Errorf("expected ErrInvalidVersionKey for null policy, got: %v", err)
+	}
+
+	config.Version.NullPolicies = nil
+	config.Version.DefaultContentTypes = map[int]string{-1: ContentTypeJson}
+	if _, err := NewService(config); err != ErrInvalidVersionKey {
+		t.Errorf("expected ErrInvalidVersionKey for default content type, got: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package luddite
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+// contextPageSizeKey carries the effective page size of a list request.
+const contextPageSizeKey = contextKey(2)
+
+// PageSizer is implemented by collection resources that declare their page
+// sizes. Luddite enforces them on list requests before the resource's List
+// method runs: requests without a page size receive defaultSize, and requests
+// for larger pages than maxSize are either clamped or rejected with a 400
+// response, depending on the service's StrictPageSize config value. Either
+// size may be zero, in which case it isn't applied.
+//
+// The sizes aren't added to the service's schema, which luddite serves from
+// static files; resources should document them there.
+type PageSizer interface {
+	PageSizes() (defaultSize, maxSize int)
+}
+
+// applyPageSize enforces a page sizer's page sizes on a list request. The
+// effective page size is carried by the returned request's context, so that it
+// is returned by RequestPageSize, and echoed in the response, which varies on
+// the X-Spirent-Page-Size header. The request's header is left as the client
+// sent it. It returns nil if the request was rejected.
+func applyPageSize(rw http.ResponseWriter, req *http.Request, p PageSizer) *http.Request {
+	defaultSize, maxSize := p.PageSizes()
+	addVary(rw.Header(), HeaderSpirentPageSize)
+
+	pageSize := defaultSize
+	if value := req.Header.Get(HeaderSpirentPageSize); value != "" {
+		var err error
+		if pageSize, err = strconv.Atoi(value); err != nil || pageSize < 1 {
+			_ = WriteResponse(rw, http.StatusBadRequest, NewError(nil, EcodeInvalidParameterValue, HeaderSpirentPageSize, value))
+			return nil
+		}
+	}
+
+	if maxSize > 0 && pageSize > maxSize {
+		if s := ContextService(req.Context()); s != nil && s.config.Limits.StrictPageSize {
+			_ = WriteResponse(rw, http.StatusBadRequest, NewError(nil, EcodePageSizeTooLarge, maxSize))
+			return nil
+		}
+		pageSize = maxSize
+	}
+
+	if pageSize > 0 {
+		req = req.WithContext(context.WithValue(req.Context(), contextPageSizeKey, pageSize))
+		rw.Header().Set(HeaderSpirentPageSize, strconv.Itoa(pageSize))
+	}
+	return req
+}
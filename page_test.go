@@ -0,0 +1,78 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dimfeld/httptreemux"
+)
+
+type pagedResource struct {
+	pageSize int
+	header   string
+}
+
+func (r *pagedResource) PageSizes() (int, int) { return 10, 100 }
+
+func (r *pagedResource) List(req *http.Request) (int, interface{}) {
+	r.pageSize = RequestPageSize(req)
+	r.header = req.Header.Get(HeaderSpirentPageSize)
+	return http.StatusOK, []string{}
+}
+
+func TestPageSizes(t *testing.T) {
+	r := &pagedResource{}
+	router := httptreemux.NewContextMux()
+	AddListCollectionRoute(router, "/samples", r)
+
+	tests := []struct {
+		header   string
+		status   int
+		pageSize int
+	}{
+		{"", http.StatusOK, 10},
+		{"50", http.StatusOK, 50},
+		{"500", http.StatusOK, 100},
+		{"zero", http.StatusBadRequest, 0},
+	}
+	for _, test := range tests {
+		r.pageSize = 0
+		req, _ := http.NewRequest("GET", "/samples", nil)
+		if test.header != "" {
+			req.Header.Set(HeaderSpirentPageSize, test.header)
+		}
+		rw := httptest.NewRecorder()
+		rw.Header().Set(HeaderContentType, ContentTypeJson)
+		router.ServeHTTP(rw, req)
+		if rw.Code != test.status {
+			t.Errorf("%q: expected %d response, got: %d", test.header, test.status, rw.Code)
+		}
+		if r.pageSize != test.pageSize {
+			t.Errorf("%q: expected page size %d, got: %d", test.header, test.pageSize, r.pageSize)
+		}
+		if test.status == http.StatusOK && r.header != test.header {
+			t.Errorf("%q: request header rewritten to %q", test.header, r.header)
+		}
+	}
+}
+
+func TestStrictPageSize(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Limits.StrictPageSize = true
+	})
+	r := &pagedResource{}
+	mustAddResource(t, s, 1, "/samples", r)
+
+	req, _ := http.NewRequest("GET", "/samples", nil)
+	req.Header.Set(HeaderAccept, ContentTypeJson)
+	req.Header.Set(HeaderSpirentPageSize, "500")
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("expected 400/Bad Request response for oversized page, got: %d", rw.Code)
+	}
+	if r.pageSize != 0 {
+		t.Error("oversized page request reached the resource")
+	}
+}
@@ -0,0 +1,149 @@
+package luddite
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PluginFrameworkVersion is the version of the plugin interface implemented by
+// this package. Plugins built for a different version aren't loaded.
+const PluginFrameworkVersion = 1
+
+// PluginInfo describes a plugin and the versions it is compatible with.
+type PluginInfo struct {
+	// Name uniquely identifies the plugin.
+	Name string
+	// FrameworkVersion is the PluginFrameworkVersion the plugin was built for.
+	FrameworkVersion int
+	// MinApiVersion and MaxApiVersion bound the service API versions that the
+	// plugin's resources support. Zero values leave the range open.
+	MinApiVersion int
+	MaxApiVersion int
+}
+
+// Plugin is an optional API module that adds resources to a service. Plugins
+// are built into the service and registered from an init function using
+// RegisterPlugin, or built as Go plugins (-buildmode=plugin) whose init
+// functions do the same and which are opened from the service's plugin
+// directory by the plugins package.
+type Plugin interface {
+	// PluginInfo describes the plugin.
+	PluginInfo() PluginInfo
+
+	// AddResources adds the plugin's resources to a service for a single
+	// API version. It's called once for each API version supported by both
+	// the plugin and the service.
+	AddResources(s *Service, version int) error
+}
+
+var (
+	plugins   = make(map[string]Plugin)
+	pluginsMu sync.Mutex
+)
+
+// RegisterPlugin makes a plugin available to services that call LoadPlugins.
+// It is intended to be called from init functions and panics if a plugin with
+// the same name is registered twice.
+func RegisterPlugin(p Plugin) {
+	if p == nil {
+		panic("nil plugin")
+	}
+	name := p.PluginInfo().Name
+	if name == "" {
+		panic("empty plugin name")
+	}
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	if _, ok := plugins[name]; ok {
+		panic(fmt.Sprintf("%s plugin registered twice", name))
+	}
+	plugins[name] = p
+}
+
+// LoadPlugins adds the resources of all registered plugins that aren't
+// disabled in the service config. Plugins that are incompatible with this
+// package or with the service's API versions are skipped with a warning.
+// LoadPlugins should be called once, before the service is run. Services that
+// load Go plugins from their plugin directory call plugins.Load instead.
+func (s *Service) LoadPlugins() error {
+	config := s.config
+	disabled := make(map[string]bool, len(config.Plugins.Disabled))
+	for _, name := range config.Plugins.Disabled {
+		disabled[name] = true
+	}
+
+	pluginsMu.Lock()
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	registered := make(map[string]Plugin, len(plugins))
+	for name, p := range plugins {
+		registered[name] = p
+	}
+	pluginsMu.Unlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		if disabled[name] || s.loadedPlugin(name) {
+			continue
+		}
+		p := registered[name]
+		info := p.PluginInfo()
+		minVersion, maxVersion, err := s.pluginApiVersions(info)
+		if err != nil {
+			s.defaultLogger.WithFields(log.Fields{"plugin": name}).Warn("plugin not loaded: ", err)
+			continue
+		}
+		for v := minVersion; v <= maxVersion; v++ {
+			if err = p.AddResources(s, v); err != nil {
+				return fmt.Errorf("plugin %s failed to add resources for API version %d: %v", name, v, err)
+			}
+		}
+		s.plugins = append(s.plugins, info)
+		s.LogLifecycleEvent(LifecyclePluginLoaded, log.Fields{
+			"plugin":          name,
+			"min_api_version": minVersion,
+			"max_api_version": maxVersion,
+		})
+	}
+	return nil
+}
+
+// Plugins describes the plugins that the service has loaded.
+func (s *Service) Plugins() []PluginInfo {
+	return append([]PluginInfo(nil), s.plugins...)
+}
+
+func (s *Service) loadedPlugin(name string) bool {
+	for _, info := range s.plugins {
+		if info.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// pluginApiVersions checks a plugin's compatibility and returns the range of
+// API versions supported by both the plugin and the service.
+func (s *Service) pluginApiVersions(info PluginInfo) (minVersion, maxVersion int, err error) {
+	if info.FrameworkVersion != PluginFrameworkVersion {
+		err = fmt.Errorf("plugin framework version %d is incompatible with version %d", info.FrameworkVersion, PluginFrameworkVersion)
+		return
+	}
+	minVersion, maxVersion = s.config.Version.Min, s.config.Version.Max
+	if info.MinApiVersion > minVersion {
+		minVersion = info.MinApiVersion
+	}
+	if info.MaxApiVersion > 0 && info.MaxApiVersion < maxVersion {
+		maxVersion = info.MaxApiVersion
+	}
+	if minVersion > maxVersion {
+		err = fmt.Errorf("plugin API versions [%d, %d] don't overlap the service's API versions [%d, %d]",
+			info.MinApiVersion, info.MaxApiVersion, s.config.Version.Min, s.config.Version.Max)
+	}
+	return
+}
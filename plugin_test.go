@@ -0,0 +1,91 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testPlugin struct {
+	info     PluginInfo
+	versions []int
+}
+
+func (p *testPlugin) PluginInfo() PluginInfo {
+	return p.info
+}
+
+func (p *testPlugin) AddResources(s *Service, version int) error {
+	p.versions = append(p.versions, version)
+	return s.AddResource(version, "/"+p.info.Name, &blobResource{ContentTypePlain, []byte(p.info.Name)})
+}
+
+// resetPlugins restores the plugin registry once a test completes, so that
+// tests registering plugins can run more than once.
+func resetPlugins(t *testing.T) {
+	pluginsMu.Lock()
+	saved := make(map[string]Plugin, len(plugins))
+	for name, p := range plugins {
+		saved[name] = p
+	}
+	pluginsMu.Unlock()
+	t.Cleanup(func() {
+		pluginsMu.Lock()
+		plugins = saved
+		pluginsMu.Unlock()
+	})
+}
+
+func TestLoadPlugins(t *testing.T) {
+	resetPlugins(t)
+	compatible := &testPlugin{info: PluginInfo{Name: "test-compatible", FrameworkVersion: PluginFrameworkVersion, MinApiVersion: 2}}
+	disabled := &testPlugin{info: PluginInfo{Name: "test-disabled", FrameworkVersion: PluginFrameworkVersion}}
+	tooNew := &testPlugin{info: PluginInfo{Name: "test-too-new", FrameworkVersion: PluginFrameworkVersion, MinApiVersion: 4}}
+	framework := &testPlugin{info: PluginInfo{Name: "test-framework", FrameworkVersion: PluginFrameworkVersion + 1}}
+	for _, p := range []*testPlugin{compatible, disabled, tooNew, framework} {
+		RegisterPlugin(p)
+	}
+
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Version.Max = 3
+		config.Plugins.Disabled = []string{"test-disabled"}
+	})
+	if err := s.LoadPlugins(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(compatible.versions) != 2 || compatible.versions[0] != 2 || compatible.versions[1] != 3 {
+		t.Errorf("expected plugin resources for API versions 2 and 3, got: %v", compatible.versions)
+	}
+	for _, p := range []*testPlugin{disabled, tooNew, framework} {
+		if len(p.versions) != 0 {
+			t.Errorf("%s: expected plugin to be skipped", p.info.Name)
+		}
+	}
+	if loaded := s.Plugins(); len(loaded) != 1 || loaded[0].Name != "test-compatible" {
+		t.Errorf("expected one loaded plugin, got: %+v", loaded)
+	}
+
+	req, _ := http.NewRequest("GET", "/test-compatible", nil)
+	req.Header.Set(HeaderSpirentApiVersion, "3")
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK || rw.Body.String() != "test-compatible" {
+		t.Errorf("expected plugin resource response, got: %d %q", rw.Code, rw.Body.String())
+	}
+
+	// Loading again doesn't add resources twice
+	if err := s.LoadPlugins(); err != nil {
+		t.Fatal(err)
+	}
+	if len(compatible.versions) != 2 {
+		t.Errorf("expected plugin to be loaded once, got: %v", compatible.versions)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected duplicate plugin registration to panic")
+		}
+	}()
+	RegisterPlugin(&testPlugin{info: PluginInfo{Name: "test-compatible"}})
+}
@@ -0,0 +1,39 @@
+// Package plugins loads luddite plugins built as Go plugins
+// (-buildmode=plugin). It's kept out of the luddite package so that services
+// that only compile their plugins in don't link the plugin runtime.
+package plugins
+
+import (
+	"fmt"
+	"path/filepath"
+	"plugin"
+
+	"github.com/SpirentOrion/luddite.v2"
+)
+
+// Open opens the Go plugins (*.so files) in a directory. Each plugin's init
+// functions register it with luddite.RegisterPlugin.
+func Open(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.so"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if _, err = plugin.Open(path); err != nil {
+			return fmt.Errorf("failed to open plugin %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// Load opens the Go plugins in the service's plugin directory, if any, and
+// then calls the service's LoadPlugins to add the resources of all registered
+// plugins.
+func Load(s *luddite.Service) error {
+	if dir := s.Config().Plugins.Dir; dir != "" {
+		if err := Open(dir); err != nil {
+			return err
+		}
+	}
+	return s.LoadPlugins()
+}
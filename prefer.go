@@ -0,0 +1,64 @@
+package luddite
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	PreferReturnMinimal        = "minimal"
+	PreferReturnRepresentation = "representation"
+)
+
+// RequestPreferReturn returns the value of a request's "return" preference
+// (RFC 7240), e.g. "minimal" for `Prefer: return=minimal`, or an empty string
+// if the client has no preference.
+func RequestPreferReturn(r *http.Request) string {
+	for _, hdr := range r.Header[HeaderPrefer] {
+		for _, pref := range strings.Split(hdr, ",") {
+			if i := strings.Index(pref, ";"); i >= 0 {
+				pref = pref[:i]
+			}
+			if kv := strings.SplitN(strings.TrimSpace(pref), "=", 2); len(kv) == 2 && strings.EqualFold(kv[0], "return") {
+				return strings.ToLower(strings.Trim(strings.TrimSpace(kv[1]), `"`))
+			}
+		}
+	}
+	return ""
+}
+
+// applyPreferReturn adjusts a successful mutation response according to the
+// request's return preference. For return=minimal the body is omitted (and 200
+// becomes 204). For return=representation a body-less response is replaced
+// by the given representation, if any, which allows deletes to return the
+// deleted resource.
+func applyPreferReturn(rw http.ResponseWriter, req *http.Request, status int, v, representation interface{}) (int, interface{}) {
+	pref := RequestPreferReturn(req)
+	if pref == "" || status/100 != 2 {
+		return status, v
+	}
+
+	switch pref {
+	case PreferReturnMinimal:
+		if status == http.StatusOK {
+			status = http.StatusNoContent
+		}
+		v = nil
+	case PreferReturnRepresentation:
+		if v == nil {
+			if representation == nil {
+				return status, v
+			}
+			if status == http.StatusNoContent {
+				status = http.StatusOK
+			}
+			v = representation
+		}
+	default:
+		return status, v
+	}
+
+	rw.Header().Set(HeaderPreferenceApplied, "return="+pref)
+	addVary(rw.Header(), HeaderPrefer)
+	return status, v
+}
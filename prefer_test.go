@@ -0,0 +1,63 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dimfeld/httptreemux"
+)
+
+func TestRequestPreferReturn(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/", nil)
+	req.Header.Set(HeaderPrefer, `respond-async, return="minimal"; foo=bar`)
+	if pref := RequestPreferReturn(req); pref != PreferReturnMinimal {
+		t.Errorf("incorrect return preference: %s", pref)
+	}
+
+	req.Header.Del(HeaderPrefer)
+	if pref := RequestPreferReturn(req); pref != "" {
+		t.Errorf("unexpected return preference: %s", pref)
+	}
+}
+
+func TestPreferReturnMinimal(t *testing.T) {
+	r := &versionedResource{current: &versionedSample{Id: "a", Rev: "1"}}
+	router := httptreemux.NewContextMux()
+	AddUpdateCollectionRoute(router, "/samples", r)
+
+	req, _ := http.NewRequest("PUT", "/samples/a", strings.NewReader(`{"id":"a"}`))
+	req.Header.Set(HeaderContentType, ContentTypeJson)
+	req.Header.Set(HeaderPrefer, "return=minimal")
+	rw := httptest.NewRecorder()
+	rw.Header().Set(HeaderContentType, ContentTypeJson)
+	router.ServeHTTP(rw, req)
+	if rw.Code != http.StatusNoContent {
+		t.Errorf("expected 204/No Content response, got: %d", rw.Code)
+	}
+	if rw.Body.Len() != 0 {
+		t.Errorf("unexpected body: %s", rw.Body.String())
+	}
+	if pa := rw.Header().Get(HeaderPreferenceApplied); pa != "return=minimal" {
+		t.Errorf("incorrect %s header: %s", HeaderPreferenceApplied, pa)
+	}
+}
+
+func TestPreferReturnRepresentationOnDelete(t *testing.T) {
+	r := &versionedResource{current: &versionedSample{Id: "a", Rev: "1"}}
+	router := httptreemux.NewContextMux()
+	AddDeleteCollectionRoute(router, "/samples", r)
+
+	req, _ := http.NewRequest("DELETE", "/samples/a", nil)
+	req.Header.Set(HeaderPrefer, "return=representation")
+	rw := httptest.NewRecorder()
+	rw.Header().Set(HeaderContentType, ContentTypeJson)
+	router.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Errorf("expected 200/OK response, got: %d", rw.Code)
+	}
+	if body := rw.Body.String(); body != `{"id":"a","rev":"1"}` {
+		t.Errorf("deleted representation not returned: %s", body)
+	}
+}
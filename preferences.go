@@ -0,0 +1,136 @@
+package luddite
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Unit systems for rendering quantities.
+const (
+	UnitsMetric   = "metric"
+	UnitsImperial = "imperial"
+)
+
+// Preferences holds the rendering preferences that apply to the current
+// request: the locale (a BCP 47 language tag), time zone, and unit system.
+type Preferences struct {
+	Locale   string
+	TimeZone *time.Location
+	Units    string
+}
+
+// PreferencesResolver returns the stored preferences of the principal making a
+// request (e.g. from a user profile). Zero-valued fields, or a nil result,
+// leave the service defaults in place. Explicit request headers take
+// precedence over resolved preferences. It's called when the preferences are
+// first read, so it sees the principal set by authentication middleware.
+type PreferencesResolver func(req *http.Request) *Preferences
+
+func (p *Preferences) merge(other *Preferences) {
+	if other == nil {
+		return
+	}
+	if other.Locale != "" {
+		p.Locale = other.Locale
+	}
+	if other.TimeZone != nil {
+		p.TimeZone = other.TimeZone
+	}
+	if other.Units != "" {
+		p.Units = other.Units
+	}
+}
+
+// preferredLanguage returns the highest weighted language tag from an
+// Accept-Language header.
+func preferredLanguage(header string) string {
+	type tag struct {
+		lang string
+		q    float64
+	}
+	var tags []tag
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		lang := strings.TrimSpace(fields[0])
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if param = strings.TrimSpace(param); strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			tags = append(tags, tag{lang, q})
+		}
+	}
+	if len(tags) == 0 {
+		return ""
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	return tags[0].lang
+}
+
+type preferencesHandler struct {
+	s        *Service
+	defaults Preferences
+}
+
+func newPreferencesHandler(s *Service, defaults Preferences) http.Handler {
+	return &preferencesHandler{
+		s:        s,
+		defaults: defaults,
+	}
+}
+
+func (h *preferencesHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	// Explicit request headers take precedence
+	var explicit Preferences
+	if lang := preferredLanguage(req.Header.Get(HeaderAcceptLanguage)); lang != "" {
+		explicit.Locale = lang
+	}
+	if tz := req.Header.Get(HeaderSpirentTimeZone); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			_ = WriteResponse(rw, http.StatusBadRequest, NewError(nil, EcodeInvalidParameterValue, HeaderSpirentTimeZone, tz))
+			return
+		}
+		explicit.TimeZone = loc
+	}
+	if units := req.Header.Get(HeaderSpirentUnits); units != "" {
+		switch units = strings.ToLower(units); units {
+		case UnitsMetric, UnitsImperial:
+			explicit.Units = units
+		default:
+			_ = WriteResponse(rw, http.StatusBadRequest, NewError(nil, EcodeInvalidParameterValue, HeaderSpirentUnits, units))
+			return
+		}
+	}
+	addVary(rw.Header(), HeaderAcceptLanguage, HeaderSpirentTimeZone, HeaderSpirentUnits)
+
+	// The principal's stored preferences are resolved on first access, since
+	// the middleware that authenticates the principal runs after this handler
+	if d := contextHandlerDetails(req.Context()); d != nil {
+		d.preferences = nil
+		d.preferencesHandler = h
+		d.preferencesRequest = req
+		d.explicitPreferences = explicit
+	}
+}
+
+// resolve returns the preferences that apply to a request given those set by
+// its headers.
+func (h *preferencesHandler) resolve(req *http.Request, explicit *Preferences) *Preferences {
+	prefs := h.defaults
+	if resolver := h.s.preferencesResolver; resolver != nil {
+		prefs.merge(resolver(req))
+	}
+	prefs.merge(explicit)
+	return &prefs
+}
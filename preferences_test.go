@@ -0,0 +1,96 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPreferredLanguage(t *testing.T) {
+	if lang := preferredLanguage("fr;q=0.5, en-US, de;q=0.8"); lang != "en-US" {
+		t.Errorf("incorrect preferred language: %s", lang)
+	}
+	if lang := preferredLanguage("*, fr;q=0"); lang != "" {
+		t.Errorf("unexpected preferred language: %s", lang)
+	}
+}
+
+func TestPreferencesHandler(t *testing.T) {
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	s := &Service{config: new(ServiceConfig)}
+	s.SetPreferencesResolver(func(req *http.Request) *Preferences {
+		return &Preferences{Locale: "ja-JP", TimeZone: tokyo}
+	})
+	h := newPreferencesHandler(s, Preferences{Locale: "en", TimeZone: time.UTC, Units: UnitsMetric})
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set(HeaderSpirentUnits, "Imperial")
+	d := &handlerDetails{}
+	req = req.WithContext(withHandlerDetails(req.Context(), d))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	ctx := req.Context()
+	if locale := ContextLocale(ctx); locale != "ja-JP" {
+		t.Errorf("profile locale not applied: %s", locale)
+	}
+	if loc := ContextTimeZone(ctx); loc != tokyo {
+		t.Errorf("profile time zone not applied: %s", loc)
+	}
+	if units := ContextUnits(ctx); units != UnitsImperial {
+		t.Errorf("units header not applied: %s", units)
+	}
+
+	req.Header.Set(HeaderAcceptLanguage, "de-DE")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if locale := ContextLocale(ctx); locale != "de-DE" {
+		t.Errorf("Accept-Language header not preferred over profile: %s", locale)
+	}
+
+	req.Header.Set(HeaderSpirentTimeZone, "Nowhere/Special")
+	rw := httptest.NewRecorder()
+	rw.Header().Set(HeaderContentType, ContentTypeJson)
+	h.ServeHTTP(rw, req)
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("expected 400/Bad Request response for unknown time zone, got: %d", rw.Code)
+	}
+}
+
+type localeResource struct{}
+
+func (r *localeResource) Get(req *http.Request) (int, interface{}) {
+	return http.StatusOK, ContextLocale(req.Context())
+}
+
+func TestPreferencesResolvedAfterMiddleware(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Preferences.Enabled = true
+		config.Preferences.DefaultLocale = "en"
+	})
+	profiles := map[string]string{"yuki": "ja-JP"}
+	s.SetPreferencesResolver(func(req *http.Request) *Preferences {
+		if p := ContextPrincipal(req.Context()); p != nil {
+			return &Preferences{Locale: profiles[p.Id]}
+		}
+		return nil
+	})
+	// Authentication middleware runs after the built-in handlers
+	s.AddHandler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if token := RequestBearerToken(req); token != "" {
+			SetContextPrincipal(req.Context(), &Principal{Id: token})
+		}
+	}))
+	mustAddResource(t, s, 1, "/locale", &localeResource{})
+
+	for token, expected := range map[string]string{"": `"en"`, "yuki": `"ja-JP"`} {
+		req, _ := http.NewRequest("GET", "/locale", nil)
+		req.Header.Set(HeaderAccept, ContentTypeJson)
+		if token != "" {
+			req.Header.Set(HeaderAuthorization, "Bearer "+token)
+		}
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, req)
+		if body := rw.Body.String(); body != expected {
+			t.Errorf("%q: expected locale %s, got: %s", token, expected, body)
+		}
+	}
+}
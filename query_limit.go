@@ -0,0 +1,82 @@
+package luddite
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// queryLimiter rejects requests with pathological query strings before they
+// are routed.
+type queryLimiter struct {
+	maxLength int
+	maxParams int
+	maxValues int
+}
+
+func newQueryLimiterHandler(maxLength, maxParams, maxValues int) http.Handler {
+	return &queryLimiter{
+		maxLength: maxLength,
+		maxParams: maxParams,
+		maxValues: maxValues,
+	}
+}
+
+func (q *queryLimiter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.RawQuery
+	if query == "" {
+		return
+	}
+	if q.maxLength > 0 && len(query) > q.maxLength {
+		_ = WriteResponse(rw, http.StatusRequestURITooLong, NewError(nil, EcodeQueryTooLong, q.maxLength))
+		return
+	}
+
+	// Count parameters without splitting or decoding the query so that the
+	// work done on oversized queries stays proportional to the limits
+	n := strings.Count(query, "&") + 1
+	if q.maxParams > 0 && n > q.maxParams {
+		_ = WriteResponse(rw, http.StatusBadRequest, NewError(nil, EcodeTooManyQueryParams, q.maxParams))
+		return
+	}
+	if q.maxValues > 0 && n > q.maxValues {
+		values := make(map[string]int)
+		for rest := query; rest != ""; {
+			param := rest
+			if i := strings.IndexByte(rest, '&'); i >= 0 {
+				param, rest = rest[:i], rest[i+1:]
+			} else {
+				rest = ""
+			}
+			key := param
+			if i := strings.IndexByte(key, '='); i >= 0 {
+				key = key[:i]
+			}
+			if k, err := url.QueryUnescape(key); err == nil {
+				key = k
+			}
+			if values[key]++; values[key] > q.maxValues {
+				_ = WriteResponse(rw, http.StatusBadRequest, NewError(nil, EcodeTooManyQueryValues, truncateKey(key), q.maxValues))
+				return
+			}
+		}
+	}
+}
+
+// maxEchoedKeyLength bounds the length of a query parameter name echoed in an
+// error message.
+const maxEchoedKeyLength = 64
+
+// truncateKey shortens a client-supplied query parameter name for echoing.
+func truncateKey(key string) string {
+	if len(key) <= maxEchoedKeyLength {
+		return key
+	}
+	// Don't split a UTF-8 sequence
+	n := maxEchoedKeyLength
+	for n > 0 && !utf8.RuneStart(key[n]) {
+		n--
+	}
+	return key[:n] + "..."
+}
@@ -0,0 +1,51 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestQueryLimiter(t *testing.T) {
+	h := newQueryLimiterHandler(64, 4, 2)
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{"", 0},
+		{"a=1&b=2&c=3", 0},
+		{"tag=x&tag=y", 0},
+		{"q=" + strings.Repeat("x", 64), http.StatusRequestURITooLong},
+		{"a&b&c&d&e", http.StatusBadRequest},
+		{"tag=x&t%61g=y&tag=z", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/search?"+test.query, nil)
+		rw := httptest.NewRecorder()
+		res := &responseWriter{}
+		res.init(rw)
+		res.Header().Set(HeaderContentType, ContentTypeJson)
+		h.ServeHTTP(res, req)
+		if res.Status() != test.status {
+			t.Errorf("%q: expected status %d, got: %d", test.query, test.status, res.Status())
+		}
+	}
+}
+
+func TestQueryLimiterTruncatesEchoedKey(t *testing.T) {
+	h := newQueryLimiterHandler(0, 0, 1)
+	key := strings.Repeat("k", 1000)
+	req, _ := http.NewRequest("GET", "/search?"+key+"=1&"+key+"=2", nil)
+	rw := httptest.NewRecorder()
+	res := &responseWriter{}
+	res.init(rw)
+	res.Header().Set(HeaderContentType, ContentTypeJson)
+	h.ServeHTTP(res, req)
+	if res.Status() != http.StatusBadRequest {
+		t.Fatalf("expected 400 response, got: %d", res.Status())
+	}
+	if strings.Contains(rw.Body.String(), key) || !strings.Contains(rw.Body.String(), key[:maxEchoedKeyLength]+"...") {
+		t.Errorf("expected echoed key to be truncated, got: %s", rw.Body.String())
+	}
+}
@@ -0,0 +1,65 @@
+package luddite
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Rate limit scopes, identifying what a limit is applied to.
+const (
+	RateLimitScopeIP        = "ip"
+	RateLimitScopeKey       = "key"
+	RateLimitScopeTenant    = "tenant"
+	RateLimitScopePrincipal = "principal"
+)
+
+// RateLimit describes the state of a rate limit. Whichever limiting layer
+// rejects a request reports its limit the same way, using WriteRateLimited
+// (or returning NewRateLimitError from a resource), so that clients can back
+// off uniformly.
+type RateLimit struct {
+	// Limit is the number of requests allowed per window.
+	Limit int `json:"limit" xml:"limit"`
+	// Remaining is the number of requests left in the current window.
+	Remaining int `json:"remaining" xml:"remaining"`
+	// Reset is when the current window ends.
+	Reset time.Time `json:"reset" xml:"reset"`
+	// Scope is what the limit applies to, e.g. RateLimitScopeTenant.
+	Scope string `json:"scope" xml:"scope"`
+}
+
+// NewRateLimitError creates the error body of a 429 response for an exceeded
+// rate limit. WriteResponse adds the matching rate limit headers.
+func NewRateLimitError(rl *RateLimit) *Error {
+	e := NewError(nil, EcodeRateLimited, rl.Scope)
+	e.RateLimit = rl
+	return e
+}
+
+// WriteRateLimited writes a 429 response for an exceeded rate limit.
+func WriteRateLimited(rw http.ResponseWriter, rl *RateLimit) error {
+	return WriteResponse(rw, http.StatusTooManyRequests, NewRateLimitError(rl))
+}
+
+// SetRateLimitHeaders adds RateLimit-Limit, RateLimit-Remaining,
+// RateLimit-Reset (in seconds) and X-Spirent-Limit-Scope headers to a
+// response, and a Retry-After header once the limit is exhausted. Limiting
+// layers may also add them to responses for requests they allow.
+func SetRateLimitHeaders(rw http.ResponseWriter, rl *RateLimit) {
+	header := rw.Header()
+	reset := int64(math.Ceil(time.Until(rl.Reset).Seconds()))
+	if reset < 0 {
+		reset = 0
+	}
+	header.Set(HeaderRateLimitLimit, strconv.Itoa(rl.Limit))
+	header.Set(HeaderRateLimitRemaining, strconv.Itoa(rl.Remaining))
+	header.Set(HeaderRateLimitReset, strconv.FormatInt(reset, 10))
+	if rl.Scope != "" {
+		header.Set(HeaderSpirentLimitScope, rl.Scope)
+	}
+	if rl.Remaining <= 0 {
+		header.Set(HeaderRetryAfter, strconv.FormatInt(reset, 10))
+	}
+}
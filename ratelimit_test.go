@@ -0,0 +1,54 @@
+package luddite
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWriteRateLimited(t *testing.T) {
+	rl := &RateLimit{Limit: 100, Remaining: 0, Reset: time.Now().Add(30 * time.Second), Scope: RateLimitScopeTenant}
+	rw := httptest.NewRecorder()
+	rw.Header().Set(HeaderContentType, ContentTypeJson)
+	if err := WriteRateLimited(rw, rl); err != nil {
+		t.Fatal(err)
+	}
+	if rw.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 response, got: %d", rw.Code)
+	}
+
+	header := rw.Header()
+	if header.Get(HeaderRateLimitLimit) != "100" || header.Get(HeaderRateLimitRemaining) != "0" {
+		t.Errorf("incorrect rate limit headers: %v", header)
+	}
+	if reset := header.Get(HeaderRateLimitReset); reset != "30" && reset != "29" {
+		t.Errorf("expected 30s reset, got: %s", reset)
+	}
+	if header.Get(HeaderRetryAfter) != header.Get(HeaderRateLimitReset) {
+		t.Errorf("expected Retry-After to match reset, got: %s", header.Get(HeaderRetryAfter))
+	}
+	if header.Get(HeaderSpirentLimitScope) != RateLimitScopeTenant {
+		t.Errorf("expected tenant scope, got: %s", header.Get(HeaderSpirentLimitScope))
+	}
+
+	var e Error
+	if err := json.Unmarshal(rw.Body.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Code != EcodeRateLimited || e.RateLimit == nil || e.RateLimit.Limit != 100 || e.RateLimit.Scope != RateLimitScopeTenant {
+		t.Errorf("incorrect error body: %s", rw.Body.String())
+	}
+}
+
+func TestSetRateLimitHeadersRemaining(t *testing.T) {
+	rw := httptest.NewRecorder()
+	SetRateLimitHeaders(rw, &RateLimit{Limit: 10, Remaining: 4, Reset: time.Now().Add(time.Minute), Scope: RateLimitScopeIP})
+	if rw.Header().Get(HeaderRateLimitRemaining) != "4" {
+		t.Errorf("expected 4 remaining, got: %s", rw.Header().Get(HeaderRateLimitRemaining))
+	}
+	if rw.Header().Get(HeaderRetryAfter) != "" {
+		t.Error("unexpected Retry-After header before the limit is exhausted")
+	}
+}
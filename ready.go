@@ -0,0 +1,36 @@
+package luddite
+
+import (
+	"encoding/xml"
+	"net/http"
+)
+
+const defaultReadyURIPath = "/ready"
+
+// readiness is the body of readiness responses.
+type readiness struct {
+	XMLName      xml.Name       `json:"-" xml:"readiness"`
+	Ready        bool           `json:"ready" xml:"ready"`
+	OpenCircuits []*openCircuit `json:"open_circuits,omitempty" xml:"open_circuits>circuit,omitempty"`
+}
+
+func (s *Service) addReadyRoute() {
+	s.globalRouter.GET(s.config.Ready.URIPath, s.serveReady)
+}
+
+// serveReady reports whether the service is ready to handle requests, along
+// with the routes that are failing fast because their circuit breakers are
+// open. Services that are draining respond with a 503 status so that load
+// balancers stop sending them requests.
+func (s *Service) serveReady(rw http.ResponseWriter, req *http.Request) {
+	r := &readiness{Ready: !s.isDraining()}
+	if s.breakers != nil {
+		r.OpenCircuits = s.breakers.open()
+	}
+	status := http.StatusOK
+	if !r.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	rw.Header().Set(HeaderCacheControl, "no-store")
+	_ = WriteResponse(rw, status, r)
+}
@@ -0,0 +1,179 @@
+package luddite
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const (
+	// RelationBelongsTo relates an element to a single element of another
+	// collection, e.g. a document to its owner.
+	RelationBelongsTo = "belongs_to"
+	// RelationHasMany relates an element to the elements of another collection
+	// that refer to it, e.g. a user to their documents.
+	RelationHasMany = "has_many"
+)
+
+// Relationship declares how the elements of a collection resource relate to
+// those of another resource. Relationships are declared once, by implementing
+// Relater, and links (such as Link headers) are generated from them.
+type Relationship struct {
+	// Name is the relationship's link relation, e.g. "owner" or "documents".
+	Name string
+	// Kind is RelationBelongsTo or RelationHasMany.
+	Kind string
+	// Resource is the base path of the related resource, e.g. "/users".
+	Resource string
+	// Id returns the id of a belongs-to relationship's related element, or ""
+	// if there is none.
+	Id func(v interface{}) string
+	// Param names the related collection's query parameter that selects the
+	// elements of a has-many relationship, e.g. "owner_id". The element's id,
+	// encoded by the declaring resource's IdCodec if it has one, is the
+	// parameter's value; it's decoded again before the related collection's
+	// List is called.
+	Param string
+}
+
+// Relater is implemented by collection resources that declare relationships.
+// Successful GET /resource/id responses include a Link header for each
+// relationship.
+type Relater interface {
+	Relationships() []Relationship
+}
+
+// Link is a link from a resource element to a related resource.
+type Link struct {
+	Rel  string `json:"rel" xml:"rel,attr"`
+	Href string `json:"href" xml:"href,attr"`
+}
+
+// String formats a link as a Link header value.
+func (l Link) String() string {
+	return fmt.Sprintf(`<%s>; rel="%s"`, l.Href, l.Rel)
+}
+
+// Relationships returns the relationships declared by the resources of an API
+// version, keyed by base path, e.g. for generating API documentation.
+func (s *Service) Relationships(version int) map[string][]Relationship {
+	rels := make(map[string][]Relationship)
+	for _, m := range s.currentRouting().mounted[version] {
+		if r, ok := m.r.(Relater); ok {
+			rels[m.basePath] = r.Relationships()
+		}
+	}
+	return rels
+}
+
+// RelationshipLinks returns the links from a resource element to its related
+// resources. Related ids are encoded using the related resource's IdCodec, if
+// it has one.
+func RelationshipLinks(req *http.Request, r Relater, id string, v interface{}) []Link {
+	var links []Link
+	for _, rel := range r.Relationships() {
+		var href string
+		switch rel.Kind {
+		case RelationBelongsTo:
+			if rel.Id == nil {
+				continue
+			}
+			relatedId := rel.Id(v)
+			if relatedId == "" {
+				continue
+			}
+			href = path.Join(rel.Resource, url.PathEscape(encodeId(relatedResource(req, rel.Resource), relatedId)))
+		case RelationHasMany:
+			if rel.Param == "" || id == "" {
+				continue
+			}
+			href = rel.Resource + "?" + url.Values{rel.Param: {id}}.Encode()
+		default:
+			continue
+		}
+		links = append(links, Link{Rel: rel.Name, Href: href})
+	}
+	return links
+}
+
+// setRelationshipLinks adds Link headers for a resource element's
+// relationships to a response.
+func setRelationshipLinks(rw http.ResponseWriter, req *http.Request, r interface{}, status int, id string, v interface{}) {
+	rr, ok := r.(Relater)
+	if !ok || status != http.StatusOK {
+		return
+	}
+	links := RelationshipLinks(req, rr, encodeId(r, id), v)
+	if len(links) == 0 {
+		return
+	}
+	values := make([]string, len(links))
+	for i, l := range links {
+		values[i] = l.String()
+	}
+	rw.Header().Add(HeaderLink, strings.Join(values, ", "))
+}
+
+// relatedResource returns the resource registered at a base path for the
+// request's API version, or nil if there is none.
+func relatedResource(req *http.Request, basePath string) interface{} {
+	d := contextHandlerDetails(req.Context())
+	if d == nil || d.s == nil {
+		return nil
+	}
+	for _, m := range d.currentRouting().mounted[d.apiVersion] {
+		if m.basePath == basePath {
+			return m.r
+		}
+	}
+	return nil
+}
+
+// decodeRelationshipParams decodes the has-many relationship parameters of a
+// List request to the collection at a base path, e.g. owner_id in
+// GET /documents?owner_id=NkK9, using the IdCodec of the resource that declared
+// each relationship. It returns the request to pass to the collection, or nil
+// if a value can't be decoded and an error response has been written.
+func decodeRelationshipParams(rw http.ResponseWriter, req *http.Request, basePath string) *http.Request {
+	d := contextHandlerDetails(req.Context())
+	if d == nil || d.s == nil || req.URL.RawQuery == "" {
+		return req
+	}
+	var query url.Values
+	for _, m := range d.currentRouting().mounted[d.apiVersion] {
+		rr, ok := m.r.(Relater)
+		if !ok {
+			continue
+		}
+		if _, ok = m.r.(IdCodecProvider); !ok {
+			continue
+		}
+		for _, rel := range rr.Relationships() {
+			if rel.Kind != RelationHasMany || rel.Resource != basePath || rel.Param == "" {
+				continue
+			}
+			if query == nil {
+				query = req.URL.Query()
+			}
+			for i, v := range query[rel.Param] {
+				id, ok := routeId(rw, m.r, v)
+				if !ok {
+					return nil
+				}
+				query[rel.Param][i] = id
+			}
+		}
+	}
+	if query == nil {
+		return req
+	}
+	// Leave the inbound request as the client sent it
+	u := *req.URL
+	u.RawQuery = query.Encode()
+	decoded := new(http.Request)
+	*decoded = *req
+	decoded.URL = &u
+	return decoded
+}
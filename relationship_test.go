@@ -0,0 +1,107 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type document struct {
+	Id      string `json:"id"`
+	OwnerId string `json:"owner_id"`
+}
+
+type documentResource struct{}
+
+func (r *documentResource) Relationships() []Relationship {
+	return []Relationship{
+		{Name: "owner", Kind: RelationBelongsTo, Resource: "/users", Id: func(v interface{}) string { return v.(*document).OwnerId }},
+		{Name: "revisions", Kind: RelationHasMany, Resource: "/revisions", Param: "document_id"},
+	}
+}
+
+func (r *documentResource) Get(req *http.Request, id string) (int, interface{}) {
+	return http.StatusOK, &document{Id: id, OwnerId: "12345"}
+}
+
+type hashidUserResource struct{}
+
+func (r *hashidUserResource) IdCodec() IdCodec { return NewHashidCodec("this is my salt", 0) }
+
+func (r *hashidUserResource) Get(req *http.Request, id string) (int, interface{}) {
+	return http.StatusOK, id
+}
+
+func TestRelationshipLinks(t *testing.T) {
+	s := newTestService(t, nil)
+	mustAddResource(t, s, 1, "/documents", &documentResource{})
+	mustAddResource(t, s, 1, "/users", &hashidUserResource{})
+
+	req, _ := http.NewRequest("GET", "/documents/d1", nil)
+	req.Header.Set(HeaderAccept, ContentTypeJson)
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected 200 response, got: %d", rw.Code)
+	}
+	expected := `</users/NkK9>; rel="owner", </revisions?document_id=d1>; rel="revisions"`
+	if link := rw.Header().Get(HeaderLink); link != expected {
+		t.Errorf("expected Link header %q, got: %q", expected, link)
+	}
+
+	rels := s.Relationships(1)
+	if len(rels) != 1 || len(rels["/documents"]) != 2 {
+		t.Errorf("expected declared relationships to be reported, got: %v", rels)
+	}
+}
+
+type hashidOwnerResource struct{}
+
+func (r *hashidOwnerResource) IdCodec() IdCodec { return NewHashidCodec("this is my salt", 0) }
+
+func (r *hashidOwnerResource) Relationships() []Relationship {
+	return []Relationship{{Name: "documents", Kind: RelationHasMany, Resource: "/owned", Param: "owner_id"}}
+}
+
+func (r *hashidOwnerResource) Get(req *http.Request, id string) (int, interface{}) {
+	return http.StatusOK, id
+}
+
+type ownedResource struct{}
+
+func (r *ownedResource) List(req *http.Request) (int, interface{}) {
+	return http.StatusOK, []string{req.URL.Query().Get("owner_id")}
+}
+
+func TestRelationshipParamsDecoded(t *testing.T) {
+	s := newTestService(t, nil)
+	mustAddResource(t, s, 1, "/owners", &hashidOwnerResource{})
+	mustAddResource(t, s, 1, "/owned", &ownedResource{})
+
+	req, _ := http.NewRequest("GET", "/owners/NkK9", nil)
+	req.Header.Set(HeaderAccept, ContentTypeJson)
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	expected := `</owned?owner_id=NkK9>; rel="documents"`
+	if link := rw.Header().Get(HeaderLink); link != expected {
+		t.Fatalf("expected Link header %q, got: %q", expected, link)
+	}
+
+	req, _ = http.NewRequest("GET", "/owned?owner_id=NkK9", nil)
+	req.Header.Set(HeaderAccept, ContentTypeJson)
+	rw = httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK || rw.Body.String() != `["12345"]` {
+		t.Errorf("expected decoded relationship param, got: %d %s", rw.Code, rw.Body.String())
+	}
+	if req.URL.RawQuery != "owner_id=NkK9" {
+		t.Errorf("expected inbound query to be left alone, got: %s", req.URL.RawQuery)
+	}
+
+	req, _ = http.NewRequest("GET", "/owned?owner_id=!!", nil)
+	rw = httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	if rw.Code != http.StatusNotFound {
+		t.Errorf("expected 404 response for an undecodable id, got: %d", rw.Code)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"time"
 
 	"github.com/dimfeld/httptreemux"
 )
@@ -16,6 +17,48 @@ const (
 	RouteParamId     = RouteTagSeg1 // e.g. in `GET /resource/id`
 )
 
+// acceptPatch lists the patch document media types that PATCH routes accept.
+const acceptPatch = ContentTypeMergePatch + ", " + ContentTypeJsonPatch + ", " + ContentTypeJson
+
+// handleRoute registers a resource route handler. The handler records the
+// route's pattern in the request context before it runs; this is used for
+// per-route metrics and limits. Required scopes are enforced before the
+// handler runs.
+func handleRoute(router *httptreemux.ContextMux, method, pattern string, h http.HandlerFunc) {
+	router.Handle(method, pattern, func(rw http.ResponseWriter, req *http.Request) {
+		setContextRoute(req.Context(), method, pattern)
+		if !checkScopes(rw, req, method, pattern) {
+			return
+		}
+		if !checkBreaker(rw, req, method, pattern) {
+			return
+		}
+		enableCompression(rw, req, pattern)
+		setNullPolicy(rw, req, pattern)
+		if t := contextTiming(rw); t != nil {
+			t.routeStart = time.Now()
+		}
+		h(rw, req)
+	})
+}
+
+// getOnce memoizes a resource getter so that it's called at most once per
+// request.
+func getOnce(get func() (int, interface{})) func() (int, interface{}) {
+	var (
+		done   bool
+		status int
+		v      interface{}
+	)
+	return func() (int, interface{}) {
+		if !done {
+			status, v = get()
+			done = true
+		}
+		return status, v
+	}
+}
+
 // CollectionLister is a collection-style resource that returns all its elements
 // in response to `GET /resource`.
 type CollectionLister interface {
@@ -25,10 +68,27 @@ type CollectionLister interface {
 
 // AddListCollectionRoute adds a route for a CollectionLister.
 func AddListCollectionRoute(router *httptreemux.ContextMux, basePath string, r CollectionLister) {
-	router.GET(basePath, func(rw http.ResponseWriter, req *http.Request) {
+	handleRoute(router, "GET", basePath, func(rw http.ResponseWriter, req *http.Request) {
 		ctx := req.Context()
 		SetContextRequestProgress(ctx, "luddite.ListCollectionRoute.begin")
+		if p, ok := r.(PageSizer); ok {
+			if req = applyPageSize(rw, req, p); req == nil {
+				return
+			}
+		}
+		if req = decodeRelationshipParams(rw, req, basePath); req == nil {
+			return
+		}
 		if status, v := r.List(req); status > 0 {
+			status, v = guardListSize(rw, req, r, status, v)
+			setResponseETag(rw, status, v)
+			if status == http.StatusOK && !checkIfNoneMatch(req, v) {
+				SetContextRequestProgress(ctx, "luddite.ListCollectionRoute.not_modified")
+				setCacheHeaders(rw, http.StatusNotModified, r)
+				_ = WriteResponse(rw, http.StatusNotModified, nil)
+				return
+			}
+			setCacheHeaders(rw, status, r)
 			SetContextRequestProgress(ctx, "luddite.ListCollectionRoute.write")
 			_ = WriteResponse(rw, status, v)
 		}
@@ -44,10 +104,11 @@ type CollectionCounter interface {
 
 // AddCountCollectionRoute adds a route for a CollectionCounter.
 func AddCountCollectionRoute(router *httptreemux.ContextMux, basePath string, r CollectionCounter) {
-	router.GET(path.Join(basePath, "all", "count"), func(rw http.ResponseWriter, req *http.Request) {
+	handleRoute(router, "GET", path.Join(basePath, "all", "count"), func(rw http.ResponseWriter, req *http.Request) {
 		ctx := req.Context()
 		SetContextRequestProgress(ctx, "luddite.CountCollectionRoute.begin")
 		if status, v := r.Count(req); status > 0 {
+			setCacheHeaders(rw, status, r)
 			SetContextRequestProgress(ctx, "luddite.CountCollectionRoute.write")
 			_ = WriteResponse(rw, status, v)
 		}
@@ -63,11 +124,24 @@ type CollectionGetter interface {
 
 // AddGetCollectionRoute adds a route for a CollectionGetter.
 func AddGetCollectionRoute(router *httptreemux.ContextMux, basePath string, r CollectionGetter) {
-	router.GET(path.Join(basePath, ":"+RouteParamId), func(rw http.ResponseWriter, req *http.Request) {
+	handleRoute(router, "GET", path.Join(basePath, ":"+RouteParamId), func(rw http.ResponseWriter, req *http.Request) {
 		ctx := req.Context()
 		SetContextRequestProgress(ctx, "luddite.GetCollectionRoute.begin")
 		params := httptreemux.ContextParams(ctx)
-		if status, v := r.Get(req, params[RouteParamId]); status > 0 {
+		id, ok := routeId(rw, r, params[RouteParamId])
+		if !ok {
+			return
+		}
+		if status, v := r.Get(req, id); status > 0 {
+			setResponseETag(rw, status, v)
+			setRelationshipLinks(rw, req, r, status, id, v)
+			if status == http.StatusOK && !checkIfNoneMatch(req, v) {
+				SetContextRequestProgress(ctx, "luddite.GetCollectionRoute.not_modified")
+				setCacheHeaders(rw, http.StatusNotModified, r)
+				_ = WriteResponse(rw, http.StatusNotModified, nil)
+				return
+			}
+			setCacheHeaders(rw, status, r)
 			SetContextRequestProgress(ctx, "luddite.GetCollectionRoute.write")
 			_ = WriteResponse(rw, status, v)
 		}
@@ -89,13 +163,11 @@ type CollectionCreator interface {
 
 // AddCreateCollectionRoute adds a route for a CollectionCreator.
 func AddCreateCollectionRoute(router *httptreemux.ContextMux, basePath string, r CollectionCreator) {
-	router.POST(basePath, func(rw http.ResponseWriter, req *http.Request) {
+	handleRoute(router, "POST", basePath, func(rw http.ResponseWriter, req *http.Request) {
 		ctx := req.Context()
 		SetContextRequestProgress(ctx, "luddite.CreateCollectionRoute.begin")
 		v0 := r.New()
-		if err := ReadRequest(req, v0); err != nil {
-			SetContextRequestProgress(ctx, "luddite.CreateCollectionRoute.body_error")
-			_ = WriteResponse(rw, http.StatusBadRequest, err)
+		if !readRequestBody(rw, req, v0, "luddite.CreateCollectionRoute") {
 			return
 		}
 		if status, v1 := r.Create(req, v0); status > 0 {
@@ -103,10 +175,12 @@ func AddCreateCollectionRoute(router *httptreemux.ContextMux, basePath string, r
 				url := url.URL{
 					Scheme: req.URL.Scheme,
 					Host:   req.URL.Host,
-					Path:   path.Join(basePath, r.Id(v1)),
+					Path:   path.Join(basePath, encodeId(r, r.Id(v1))),
 				}
 				rw.Header().Add(HeaderLocation, url.String())
 			}
+			setResponseETag(rw, status, v1)
+			status, v1 = applyPreferReturn(rw, req, status, v1, nil)
 			SetContextRequestProgress(ctx, "luddite.CreateCollectionRoute.write")
 			_ = WriteResponse(rw, status, v1)
 		}
@@ -128,29 +202,84 @@ type CollectionUpdater interface {
 
 // AddUpdateCollectionRoute adds a route for a CollectionUpdater.
 func AddUpdateCollectionRoute(router *httptreemux.ContextMux, basePath string, r CollectionUpdater) {
-	router.PUT(path.Join(basePath, ":"+RouteParamId), func(rw http.ResponseWriter, req *http.Request) {
+	handleRoute(router, "PUT", path.Join(basePath, ":"+RouteParamId), func(rw http.ResponseWriter, req *http.Request) {
 		ctx := req.Context()
 		SetContextRequestProgress(ctx, "luddite.UpdateCollectionRoute.begin")
 		v0 := r.New()
-		if err := ReadRequest(req, v0); err != nil {
-			SetContextRequestProgress(ctx, "luddite.UpdateCollectionRoute.body_error")
-			_ = WriteResponse(rw, http.StatusBadRequest, err)
+		if !readRequestBody(rw, req, v0, "luddite.UpdateCollectionRoute") {
 			return
 		}
 		params := httptreemux.ContextParams(ctx)
-		id := params[RouteParamId]
-		if id != r.Id(v0) {
+		id, ok := routeId(rw, r, params[RouteParamId])
+		if !ok {
+			return
+		}
+		if bodyId := r.Id(v0); bodyId != id && bodyId != params[RouteParamId] {
 			SetContextRequestProgress(ctx, "luddite.UpdateCollectionRoute.id_error")
 			_ = WriteResponse(rw, http.StatusBadRequest, NewError(nil, EcodeResourceIdMismatch))
 			return
 		}
+		var get func() (int, interface{})
+		if g, ok := r.(CollectionGetter); ok {
+			get = func() (int, interface{}) { return g.Get(req, id) }
+		}
+		if status, v := checkIfMatch(req, v0, get); status > 0 {
+			SetContextRequestProgress(ctx, "luddite.UpdateCollectionRoute.precondition_error")
+			_ = WriteResponse(rw, status, v)
+			return
+		}
 		if status, v1 := r.Update(req, id, v0); status > 0 {
+			setResponseETag(rw, status, v1)
+			status, v1 = applyPreferReturn(rw, req, status, v1, nil)
 			SetContextRequestProgress(ctx, "luddite.UpdateCollectionRoute.write")
 			_ = WriteResponse(rw, status, v1)
 		}
 	})
 }
 
+// CollectionPatcher is a collection-style resource that partially updates a
+// specific element in response to `PATCH /resource/id`.
+type CollectionPatcher interface {
+	// NewPatch returns a new instance of the resource's patch document, e.g. a
+	// JSON Merge Patch struct with pointer fields.
+	NewPatch() interface{}
+
+	// Patch returns an HTTP status code and a patched resource (or error).
+	Patch(req *http.Request, id string, patch interface{}) (int, interface{})
+}
+
+// AddPatchCollectionRoute adds a route for a CollectionPatcher.
+func AddPatchCollectionRoute(router *httptreemux.ContextMux, basePath string, r CollectionPatcher) {
+	handleRoute(router, "PATCH", path.Join(basePath, ":"+RouteParamId), func(rw http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		SetContextRequestProgress(ctx, "luddite.PatchCollectionRoute.begin")
+		rw.Header().Set(HeaderAcceptPatch, acceptPatch)
+		patch := r.NewPatch()
+		if !readRequestBody(rw, req, patch, "luddite.PatchCollectionRoute") {
+			return
+		}
+		id, ok := routeId(rw, r, httptreemux.ContextParams(ctx)[RouteParamId])
+		if !ok {
+			return
+		}
+		var get func() (int, interface{})
+		if g, ok := r.(CollectionGetter); ok {
+			get = func() (int, interface{}) { return g.Get(req, id) }
+		}
+		if status, v := checkIfMatch(req, patch, get); status > 0 {
+			SetContextRequestProgress(ctx, "luddite.PatchCollectionRoute.precondition_error")
+			_ = WriteResponse(rw, status, v)
+			return
+		}
+		if status, v := r.Patch(req, id, patch); status > 0 {
+			setResponseETag(rw, status, v)
+			status, v = applyPreferReturn(rw, req, status, v, nil)
+			SetContextRequestProgress(ctx, "luddite.PatchCollectionRoute.write")
+			_ = WriteResponse(rw, status, v)
+		}
+	})
+}
+
 // CollectionDeleter is a collection-style resource that deletes a specific
 // element in response to `DELETE /resource/id`. It may also optionally delete
 // the entire collection in response to `DELETE /resource`.
@@ -161,19 +290,40 @@ type CollectionDeleter interface {
 
 // AddDeleteCollectionRoute adds routes for a CollectionDeleter.
 func AddDeleteCollectionRoute(router *httptreemux.ContextMux, basePath string, r CollectionDeleter) {
-	router.DELETE(path.Join(basePath, ":"+RouteParamId), func(rw http.ResponseWriter, req *http.Request) {
+	handleRoute(router, "DELETE", path.Join(basePath, ":"+RouteParamId), func(rw http.ResponseWriter, req *http.Request) {
 		ctx := req.Context()
 		SetContextRequestProgress(ctx, "luddite.DeleteCollectionRoute.begin")
 		params := httptreemux.ContextParams(ctx)
-		if status, v := r.Delete(req, params[RouteParamId]); status > 0 {
+		id, ok := routeId(rw, r, params[RouteParamId])
+		if !ok {
+			return
+		}
+		var get func() (int, interface{})
+		if g, ok := r.(CollectionGetter); ok {
+			get = getOnce(func() (int, interface{}) { return g.Get(req, id) })
+		}
+		if status, v := checkIfMatch(req, nil, get); status > 0 {
+			SetContextRequestProgress(ctx, "luddite.DeleteCollectionRoute.precondition_error")
+			_ = WriteResponse(rw, status, v)
+			return
+		}
+		var deleted interface{}
+		if get != nil && RequestPreferReturn(req) == PreferReturnRepresentation {
+			if status, v := get(); status == http.StatusOK {
+				deleted = v
+			}
+		}
+		if status, v := r.Delete(req, id); status > 0 {
+			status, v = applyPreferReturn(rw, req, status, v, deleted)
 			SetContextRequestProgress(ctx, "luddite.DeleteCollectionRoute.write")
 			_ = WriteResponse(rw, status, v)
 		}
 	})
-	router.DELETE(basePath, func(rw http.ResponseWriter, req *http.Request) {
+	handleRoute(router, "DELETE", basePath, func(rw http.ResponseWriter, req *http.Request) {
 		ctx := req.Context()
 		SetContextRequestProgress(ctx, "luddite.DeleteCollectionRoute.begin")
 		if status, v := r.Delete(req, ""); status > 0 {
+			status, v = applyPreferReturn(rw, req, status, v, nil)
 			SetContextRequestProgress(ctx, "luddite.DeleteCollectionRoute.write")
 			_ = WriteResponse(rw, status, v)
 		}
@@ -189,11 +339,15 @@ type CollectionActioner interface {
 
 // AddActionCollectionRoute adds a route for a CollectionActioner.
 func AddActionCollectionRoute(router *httptreemux.ContextMux, basePath string, r CollectionActioner) {
-	router.POST(path.Join(basePath, ":"+RouteParamId, ":"+RouteParamAction), func(rw http.ResponseWriter, req *http.Request) {
+	handleRoute(router, "POST", path.Join(basePath, ":"+RouteParamId, ":"+RouteParamAction), func(rw http.ResponseWriter, req *http.Request) {
 		ctx := req.Context()
 		SetContextRequestProgress(ctx, "luddite.ActionCollectionRoute.begin")
 		params := httptreemux.ContextParams(ctx)
-		if status, v := r.Action(req, params[RouteParamId], params[RouteParamAction]); status > 0 {
+		id, ok := routeId(rw, r, params[RouteParamId])
+		if !ok {
+			return
+		}
+		if status, v := r.Action(req, id, params[RouteParamAction]); status > 0 {
 			SetContextRequestProgress(ctx, "luddite.ActionCollectionRoute.write")
 			_ = WriteResponse(rw, status, v)
 		}
@@ -209,10 +363,18 @@ type SingletonGetter interface {
 
 // AddGetSingletonRoute adds a route for a SingletonGetter.
 func AddGetSingletonRoute(router *httptreemux.ContextMux, basePath string, r SingletonGetter) {
-	router.GET(basePath, func(rw http.ResponseWriter, req *http.Request) {
+	handleRoute(router, "GET", basePath, func(rw http.ResponseWriter, req *http.Request) {
 		ctx := req.Context()
 		SetContextRequestProgress(ctx, "luddite.GetSingletonRoute.begin")
 		if status, v := r.Get(req); status > 0 {
+			setResponseETag(rw, status, v)
+			if status == http.StatusOK && !checkIfNoneMatch(req, v) {
+				SetContextRequestProgress(ctx, "luddite.GetSingletonRoute.not_modified")
+				setCacheHeaders(rw, http.StatusNotModified, r)
+				_ = WriteResponse(rw, http.StatusNotModified, nil)
+				return
+			}
+			setCacheHeaders(rw, status, r)
 			SetContextRequestProgress(ctx, "luddite.GetSingletonRoute.write")
 			_ = WriteResponse(rw, status, v)
 		}
@@ -231,22 +393,69 @@ type SingletonUpdater interface {
 
 // AddUpdateSingletonRoute adds a route for a SingletonUpdater.
 func AddUpdateSingletonRoute(router *httptreemux.ContextMux, basePath string, r SingletonUpdater) {
-	router.PUT(basePath, func(rw http.ResponseWriter, req *http.Request) {
+	handleRoute(router, "PUT", basePath, func(rw http.ResponseWriter, req *http.Request) {
 		ctx := req.Context()
 		SetContextRequestProgress(ctx, "luddite.UpdateSingletonRoute.begin")
 		v0 := r.New()
-		if err := ReadRequest(req, v0); err != nil {
-			SetContextRequestProgress(ctx, "luddite.UpdateSingletonRoute.body_error")
-			_ = WriteResponse(rw, http.StatusBadRequest, err)
+		if !readRequestBody(rw, req, v0, "luddite.UpdateSingletonRoute") {
+			return
+		}
+		var get func() (int, interface{})
+		if g, ok := r.(SingletonGetter); ok {
+			get = func() (int, interface{}) { return g.Get(req) }
+		}
+		if status, v := checkIfMatch(req, v0, get); status > 0 {
+			SetContextRequestProgress(ctx, "luddite.UpdateSingletonRoute.precondition_error")
+			_ = WriteResponse(rw, status, v)
 			return
 		}
 		if status, v1 := r.Update(req, v0); status > 0 {
+			setResponseETag(rw, status, v1)
+			status, v1 = applyPreferReturn(rw, req, status, v1, nil)
 			SetContextRequestProgress(ctx, "luddite.UpdateSingletonRoute.write")
 			_ = WriteResponse(rw, status, v1)
 		}
 	})
 }
 
+// SingletonPatcher is a singleton-style resource that is partially updated in
+// response to `PATCH /resource`.
+type SingletonPatcher interface {
+	// NewPatch returns a new instance of the resource's patch document.
+	NewPatch() interface{}
+
+	// Patch returns an HTTP status code and a patched resource (or error).
+	Patch(req *http.Request, patch interface{}) (int, interface{})
+}
+
+// AddPatchSingletonRoute adds a route for a SingletonPatcher.
+func AddPatchSingletonRoute(router *httptreemux.ContextMux, basePath string, r SingletonPatcher) {
+	handleRoute(router, "PATCH", basePath, func(rw http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		SetContextRequestProgress(ctx, "luddite.PatchSingletonRoute.begin")
+		rw.Header().Set(HeaderAcceptPatch, acceptPatch)
+		patch := r.NewPatch()
+		if !readRequestBody(rw, req, patch, "luddite.PatchSingletonRoute") {
+			return
+		}
+		var get func() (int, interface{})
+		if g, ok := r.(SingletonGetter); ok {
+			get = func() (int, interface{}) { return g.Get(req) }
+		}
+		if status, v := checkIfMatch(req, patch, get); status > 0 {
+			SetContextRequestProgress(ctx, "luddite.PatchSingletonRoute.precondition_error")
+			_ = WriteResponse(rw, status, v)
+			return
+		}
+		if status, v := r.Patch(req, patch); status > 0 {
+			setResponseETag(rw, status, v)
+			status, v = applyPreferReturn(rw, req, status, v, nil)
+			SetContextRequestProgress(ctx, "luddite.PatchSingletonRoute.write")
+			_ = WriteResponse(rw, status, v)
+		}
+	})
+}
+
 // SingletonActioner is a singleton-style resource that executes an action in
 // response to `POST /resource/action`.
 type SingletonActioner interface {
@@ -256,7 +465,7 @@ type SingletonActioner interface {
 
 // AddActionSingletonRoute adds a route for a SingletonActioner.
 func AddActionSingletonRoute(router *httptreemux.ContextMux, basePath string, r SingletonActioner) {
-	router.POST(path.Join(basePath, ":"+RouteParamAction), func(rw http.ResponseWriter, req *http.Request) {
+	handleRoute(router, "POST", path.Join(basePath, ":"+RouteParamAction), func(rw http.ResponseWriter, req *http.Request) {
 		ctx := req.Context()
 		SetContextRequestProgress(ctx, "luddite.ActionSingletonRoute.begin")
 		params := httptreemux.ContextParams(ctx)
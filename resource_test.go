@@ -0,0 +1,73 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type patchSample struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Flag bool   `json:"flag"`
+}
+
+type samplePatch struct {
+	Name *string `json:"name"`
+	Flag *bool   `json:"flag"`
+}
+
+type patchableResource struct {
+	items map[string]*patchSample
+}
+
+func (r *patchableResource) NewPatch() interface{} {
+	return &samplePatch{}
+}
+
+func (r *patchableResource) Patch(req *http.Request, id string, patch interface{}) (int, interface{}) {
+	item, ok := r.items[id]
+	if !ok {
+		return http.StatusNotFound, nil
+	}
+	p := patch.(*samplePatch)
+	if p.Name != nil {
+		item.Name = *p.Name
+	}
+	if p.Flag != nil {
+		item.Flag = *p.Flag
+	}
+	return http.StatusOK, item
+}
+
+func TestPatchCollectionRoute(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.ResponseHeaders.Enabled = true
+	})
+	r := &patchableResource{items: map[string]*patchSample{"1": {Id: "1", Name: "dave", Flag: true}}}
+	mustAddResource(t, s, 1, "/items", r)
+
+	req, _ := http.NewRequest("PATCH", "/items/1", strings.NewReader(`{"name":"hal"}`))
+	req.Header.Set(HeaderContentType, ContentTypeMergePatch)
+	req.Header.Set(HeaderAccept, ContentTypeJson)
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected 200 response, got: %d %s", rw.Code, rw.Body.String())
+	}
+	if body := rw.Body.String(); body != `{"id":"1","name":"hal","flag":true}` {
+		t.Errorf("unexpected patched resource: %s", body)
+	}
+
+	req, _ = http.NewRequest("PATCH", "/items/1", strings.NewReader(`name=hal`))
+	req.Header.Set(HeaderContentType, ContentTypePlain)
+	rw = httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	if rw.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415 response, got: %d", rw.Code)
+	}
+	if !strings.Contains(rw.Header().Get(HeaderAcceptPatch), ContentTypeMergePatch) {
+		t.Errorf("expected Accept-Patch header, got: %q", rw.Header().Get(HeaderAcceptPatch))
+	}
+}
@@ -0,0 +1,327 @@
+package luddite
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultResponseCacheMaxEntries = 1000
+
+	warningStale              = `110 - "Response is Stale"`
+	warningRevalidationFailed = `111 - "Revalidation Failed"`
+)
+
+// contextCacheRevalidationKey marks the background requests that refresh
+// stale cache entries.
+const contextCacheRevalidationKey = contextKey(1)
+
+// cachedResponse is a stored GET response along with the freshness lifetimes
+// given by its Cache-Control header.
+type cachedResponse struct {
+	apiVersion           int
+	path                 string
+	route                string
+	header               http.Header
+	vary                 http.Header
+	status               int
+	body                 []byte
+	stored               time.Time
+	maxAge               time.Duration
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+	revalidating         int32
+}
+
+// varyHeaders returns the values of the request headers named by a response's
+// Vary header. It returns false if the response varies on "*", in which case
+// it can't be stored.
+func varyHeaders(header http.Header, req *http.Request) (http.Header, bool) {
+	vary := make(http.Header)
+	for _, v := range header[HeaderVary] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name == "*" {
+				return nil, false
+			} else if name != "" {
+				vary[http.CanonicalHeaderKey(name)] = append([]string(nil), req.Header.Values(name)...)
+			}
+		}
+	}
+	return vary, true
+}
+
+// matches reports whether a request selects a stored response: its headers
+// named by the response's Vary header must have the same values as those of
+// the request that the response was stored for.
+func (e *cachedResponse) matches(req *http.Request) bool {
+	for name, values := range e.vary {
+		other := req.Header.Values(name)
+		if len(other) != len(values) {
+			return false
+		}
+		for i := range values {
+			if other[i] != values[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (e *cachedResponse) expired(age time.Duration) bool {
+	stale := e.staleWhileRevalidate
+	if e.staleIfError > stale {
+		stale = e.staleIfError
+	}
+	return age >= e.maxAge+stale
+}
+
+// write serves a stored response to the client, adding Age and (if given)
+// Warning headers. Matching If-None-Match requests receive 304 responses.
+func (e *cachedResponse) write(res *responseWriter, req *http.Request, age time.Duration, warning string) {
+	header := res.Header()
+	for k, v := range e.header {
+		if k != HeaderRequestId {
+			header[k] = append([]string(nil), v...)
+		}
+	}
+	header.Set(HeaderAge, strconv.FormatInt(int64(age/time.Second), 10))
+	if warning != "" {
+		header.Set(HeaderWarning, warning)
+	}
+	if etag := header.Get(HeaderETag); etag != "" {
+		if inm := req.Header.Get(HeaderIfNoneMatch); inm != "" && matchETag(inm, etag) {
+			header.Del(HeaderContentLength)
+			res.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	res.WriteHeader(e.status)
+	_, _ = res.Write(e.body)
+}
+
+// cacheLifetimes returns the freshness lifetimes of a response for storage in
+// a shared cache. It returns false if the response may not be stored:
+// responses that set cookies or are private are never shared, since requests
+// without credentials share a cache key.
+func cacheLifetimes(status int, header http.Header) (maxAge, staleWhileRevalidate, staleIfError time.Duration, ok bool) {
+	if status != http.StatusOK || len(header[HeaderSetCookie]) != 0 {
+		return
+	}
+	for _, v := range header[HeaderCacheControl] {
+		for _, directive := range strings.Split(v, ",") {
+			name, value := strings.TrimSpace(directive), ""
+			if i := strings.IndexByte(name, '='); i >= 0 {
+				name, value = name[:i], name[i+1:]
+			}
+			seconds, _ := strconv.Atoi(value)
+			dur := time.Duration(seconds) * time.Second
+			switch strings.ToLower(name) {
+			case "no-store", "no-cache", "private":
+				return 0, 0, 0, false
+			case "max-age":
+				maxAge = dur
+			case "stale-while-revalidate":
+				staleWhileRevalidate = dur
+			case "stale-if-error":
+				staleIfError = dur
+			}
+		}
+	}
+	ok = maxAge > 0
+	return
+}
+
+// responseCache is a server-side cache of GET responses. Responses are stored
+// according to their own Cache-Control headers, and are keyed by everything
+// that selects a representation: the request key (see requestKey) and the
+// request headers named by the stored response's Vary header. Stale responses are served
+// while they're revalidated in the background (stale-while-revalidate) and in
+// place of 5xx responses (stale-if-error).
+type responseCache struct {
+	s          *Service
+	maxEntries int
+	mu         sync.Mutex
+	entries    map[string]*cachedResponse
+}
+
+func newResponseCache(s *Service, maxEntries int) *responseCache {
+	return &responseCache{
+		s:          s,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*cachedResponse),
+	}
+}
+
+func (c *responseCache) get(key string) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[key]
+}
+
+func (c *responseCache) put(key string, e *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		// Evict expired entries, falling back to an arbitrary one
+		now := time.Now()
+		for k, old := range c.entries {
+			if old.expired(now.Sub(old.stored)) {
+				delete(c.entries, k)
+			}
+		}
+		for k := range c.entries {
+			if len(c.entries) < c.maxEntries {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = e
+}
+
+// purge drops the cached responses of an API version's resources under a base
+// path, e.g. when the resource is removed or replaced.
+func (c *responseCache) purge(apiVersion int, basePath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if e.apiVersion == apiVersion && underBasePath(basePath, e.path) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// serve answers a GET from the cache when possible and otherwise dispatches it
+// to next, storing cacheable responses.
+func (c *responseCache) serve(res *responseWriter, req *http.Request, apiVersion int, next http.Handler) {
+	key := requestKey(res, req, apiVersion)
+	if req.Context().Value(contextCacheRevalidationKey) == nil {
+		if e := c.get(key); e != nil && e.matches(req) {
+			// Cached responses are only served to principals that may
+			// still access the route
+			if !checkScopes(res, req, req.Method, e.route) {
+				return
+			}
+			age := time.Since(e.stored)
+			switch {
+			case age < e.maxAge:
+				SetContextRequestProgress(req.Context(), "luddite.responseCache.fresh")
+				e.write(res, req, age, "")
+				return
+			case age < e.maxAge+e.staleWhileRevalidate:
+				SetContextRequestProgress(req.Context(), "luddite.responseCache.stale")
+				c.revalidate(e, req)
+				e.write(res, req, age, warningStale)
+				return
+			case age < e.maxAge+e.staleIfError:
+				c.serveStaleIfError(res, req, apiVersion, key, e, next)
+				return
+			}
+		}
+	}
+	c.dispatch(res, req, apiVersion, key, next)
+}
+
+// dispatch runs the handler and stores its response if it's cacheable.
+func (c *responseCache) dispatch(res *responseWriter, req *http.Request, apiVersion int, key string, next http.Handler) {
+	capture := new(bytes.Buffer)
+	prevCapture := res.capture
+	res.capture = capture
+	defer func() { res.capture = prevCapture }()
+
+	next.ServeHTTP(res, req)
+	if res.clientAborted() {
+		return
+	}
+	if maxAge, swr, sie, ok := cacheLifetimes(res.Status(), res.Header()); ok {
+		vary, ok := varyHeaders(res.Header(), req)
+		if !ok {
+			return
+		}
+		header := make(http.Header, len(res.Header()))
+		for k, v := range res.Header() {
+			header[k] = append([]string(nil), v...)
+		}
+		c.put(key, &cachedResponse{
+			apiVersion:           apiVersion,
+			path:                 req.URL.Path,
+			route:                res.route,
+			header:               header,
+			vary:                 vary,
+			status:               res.Status(),
+			body:                 append([]byte(nil), capture.Bytes()...),
+			stored:               time.Now(),
+			maxAge:               maxAge,
+			staleWhileRevalidate: swr,
+			staleIfError:         sie,
+		})
+	}
+}
+
+// serveStaleIfError dispatches a request whose cached response is stale but
+// may still stand in for a 5xx response.
+func (c *responseCache) serveStaleIfError(res *responseWriter, req *http.Request, apiVersion int, key string, e *cachedResponse, next http.Handler) {
+	res.hold()
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			res.discard()
+			panic(rcv)
+		}
+	}()
+	c.dispatch(res, req, apiVersion, key, next)
+	if res.Status()/100 != 5 {
+		res.release()
+		return
+	}
+
+	SetContextRequestProgress(req.Context(), "luddite.responseCache.stale_if_error")
+	res.discard()
+	header := res.Header()
+	for k := range header {
+		if k != HeaderRequestId {
+			delete(header, k)
+		}
+	}
+	e.write(res, req, time.Since(e.stored), warningRevalidationFailed)
+}
+
+// revalidate refreshes a stale entry by replaying its request in the
+// background. At most one revalidation per entry is in flight.
+func (c *responseCache) revalidate(e *cachedResponse, req *http.Request) {
+	if !atomic.CompareAndSwapInt32(&e.revalidating, 0, 1) {
+		return
+	}
+	ctx := context.WithValue(context.Background(), contextCacheRevalidationKey, true)
+	req1 := req.WithContext(ctx)
+	req1.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		req1.Header[k] = append([]string(nil), v...)
+	}
+	req1.Header.Del(HeaderRequestId)
+	go func() {
+		defer atomic.StoreInt32(&e.revalidating, 0)
+		defer func() {
+			// Nothing above a background goroutine can recover its panics
+			if rcv := recover(); rcv != nil {
+				c.s.defaultLogger.WithField("path", req1.URL.Path).Error("cache revalidation panicked: ", rcv)
+			}
+		}()
+		c.s.ServeHTTP(&discardResponseWriter{header: make(http.Header)}, req1)
+	}()
+}
+
+// discardResponseWriter is the http.ResponseWriter for background requests.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (rw *discardResponseWriter) Header() http.Header         { return rw.header }
+func (rw *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (rw *discardResponseWriter) WriteHeader(int)             {}
@@ -0,0 +1,191 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type staleResource struct {
+	calls int32
+	fail  int32
+}
+
+func (r *staleResource) New() interface{}            { return &versionedSample{} }
+func (r *staleResource) Id(value interface{}) string { return value.(*versionedSample).Id }
+
+func (r *staleResource) CacheControl() (time.Duration, bool) { return 10 * time.Second, true }
+
+func (r *staleResource) StaleCacheControl() (time.Duration, time.Duration) {
+	return 10 * time.Second, time.Minute
+}
+
+func (r *staleResource) Get(req *http.Request, id string) (int, interface{}) {
+	atomic.AddInt32(&r.calls, 1)
+	if atomic.LoadInt32(&r.fail) != 0 {
+		return http.StatusServiceUnavailable, nil
+	}
+	return http.StatusOK, &versionedSample{Id: id}
+}
+
+func TestStaleCacheHeaders(t *testing.T) {
+	rw := httptest.NewRecorder()
+	setCacheHeaders(rw, http.StatusOK, &staleResource{})
+	if cc := rw.Header().Get(HeaderCacheControl); cc != "public, max-age=10, stale-while-revalidate=10, stale-if-error=60" {
+		t.Errorf("incorrect Cache-Control header: %s", cc)
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Cache.Enabled = true
+	})
+	r := &staleResource{}
+	mustAddResource(t, s, 1, "/samples", r)
+
+	get := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/samples/a", nil)
+		req.Header.Set(HeaderAccept, ContentTypeJson)
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, req)
+		return rw
+	}
+	backdate := func(d time.Duration) {
+		s.cache.mu.Lock()
+		for _, e := range s.cache.entries {
+			e.stored = time.Now().Add(-d)
+		}
+		s.cache.mu.Unlock()
+	}
+
+	get()
+	if rw := get(); rw.Code != http.StatusOK || rw.Header().Get(HeaderAge) == "" {
+		t.Errorf("fresh response not served from cache: %d %v", rw.Code, rw.Header())
+	}
+	if calls := atomic.LoadInt32(&r.calls); calls != 1 {
+		t.Errorf("expected 1 handler execution, got: %d", calls)
+	}
+
+	// Stale while revalidating
+	backdate(15 * time.Second)
+	if rw := get(); rw.Code != http.StatusOK || rw.Header().Get(HeaderWarning) != warningStale {
+		t.Errorf("stale response not served: %d %v", rw.Code, rw.Header())
+	}
+	for start := time.Now(); atomic.LoadInt32(&r.calls) != 2; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("stale response not revalidated")
+		}
+	}
+	for start := time.Now(); get().Header().Get(HeaderWarning) != ""; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("revalidated response not stored")
+		}
+	}
+
+	// Stale if error
+	atomic.StoreInt32(&r.fail, 1)
+	backdate(30 * time.Second)
+	rw := get()
+	if rw.Code != http.StatusOK || rw.Header().Get(HeaderWarning) != warningRevalidationFailed {
+		t.Errorf("stale response not served for error: %d %v", rw.Code, rw.Header())
+	}
+	if rw.Body.Len() == 0 {
+		t.Error("stale response body missing")
+	}
+	if calls := atomic.LoadInt32(&r.calls); calls != 3 {
+		t.Errorf("expected 3 handler executions, got: %d", calls)
+	}
+
+	// Expired
+	backdate(2 * time.Minute)
+	if rw := get(); rw.Code != http.StatusServiceUnavailable {
+		t.Errorf("expired response served: %d", rw.Code)
+	}
+}
+
+func TestCacheLifetimesNotShared(t *testing.T) {
+	for _, header := range []http.Header{
+		{HeaderCacheControl: {"public, max-age=60"}, HeaderSetCookie: {"session=abc"}},
+		{HeaderCacheControl: {"private, max-age=60"}},
+		{HeaderCacheControl: {"max-age=60, no-store"}},
+	} {
+		if _, _, _, ok := cacheLifetimes(http.StatusOK, header); ok {
+			t.Errorf("expected response with %v not to be stored", header)
+		}
+	}
+}
+
+func TestCachedResponseScopes(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Cache.Enabled = true
+	})
+	scope := "samples:read"
+	s.AddHandler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		SetContextPrincipal(req.Context(), &Principal{Id: "user", Scopes: []string{scope}})
+	}))
+	r := &staleResource{}
+	mustAddResource(t, s, 1, "/samples", r, WithScopes("samples:read"))
+
+	get := func() int {
+		req, _ := http.NewRequest("GET", "/samples/a", nil)
+		req.Header.Set(HeaderAccept, ContentTypeJson)
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, req)
+		return rw.Code
+	}
+	if status := get(); status != http.StatusOK {
+		t.Fatalf("expected 200 response, got: %d", status)
+	}
+	if len(s.cache.entries) != 1 {
+		t.Fatal("expected response to be cached")
+	}
+
+	// Revoke the principal's scope
+	scope = "other"
+	if status := get(); status != http.StatusForbidden {
+		t.Errorf("expected cached response to be refused with 403, got: %d", status)
+	}
+}
+
+type pagedCachedResource struct {
+	calls int32
+}
+
+func (r *pagedCachedResource) PageSizes() (int, int)               { return 3, 100 }
+func (r *pagedCachedResource) CacheControl() (time.Duration, bool) { return 10 * time.Second, true }
+
+func (r *pagedCachedResource) List(req *http.Request) (int, interface{}) {
+	atomic.AddInt32(&r.calls, 1)
+	return http.StatusOK, make([]int, RequestPageSize(req))
+}
+
+func TestResponseCacheVary(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Cache.Enabled = true
+	})
+	r := &pagedCachedResource{}
+	mustAddResource(t, s, 1, "/pages", r)
+
+	get := func(pageSize string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/pages", nil)
+		req.Header.Set(HeaderAccept, ContentTypeJson)
+		if pageSize != "" {
+			req.Header.Set(HeaderSpirentPageSize, pageSize)
+		}
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, req)
+		return rw
+	}
+	get("")
+	if rw := get("7"); rw.Body.String() != "[0,0,0,0,0,0,0]" || rw.Header().Get(HeaderSpirentPageSize) != "7" {
+		t.Errorf("cached response served for a different page size: %s %v", rw.Body.String(), rw.Header())
+	}
+	if rw := get("7"); rw.Header().Get(HeaderAge) == "" {
+		t.Error("response not served from cache for the same page size")
+	}
+	if calls := atomic.LoadInt32(&r.calls); calls != 2 {
+		t.Errorf("expected 2 handler executions, got: %d", calls)
+	}
+}
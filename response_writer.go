@@ -2,10 +2,20 @@ package luddite
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
 	"net"
 	"net/http"
+	"reflect"
+	"strconv"
 )
 
+// ErrResponseTooLarge is returned by ResponseWriter.Write when a response body
+// would exceed the route's maximum response size.
+var ErrResponseTooLarge = errors.New("response exceeds maximum size")
+
 // ResponseWriter is a wrapper around http.ResponseWriter that
 // provides extra information about the response.
 type ResponseWriter interface {
@@ -25,23 +35,111 @@ type ResponseWriter interface {
 	Size() int64
 }
 
+// TruncationNotifier is optionally implemented by http.ResponseWriters passed
+// to Service.ServeHTTP, e.g. by embedding handlers, to learn that a response
+// body was cut short. Responses written directly to net/http's connection
+// writer instead abort the connection; responses written to other writers
+// are only logged and counted.
+type TruncationNotifier interface {
+	ResponseTruncated()
+}
+
+// isConnWriter reports whether rw is net/http's own writer for a request
+// received by an http.Server, i.e. whether panicking with
+// http.ErrAbortHandler closes the client's connection.
+func isConnWriter(rw http.ResponseWriter, req *http.Request) bool {
+	if req.Context().Value(http.ServerContextKey) == nil {
+		return false
+	}
+	t := reflect.TypeOf(rw)
+	return t.Kind() == reflect.Ptr && t.Elem().PkgPath() == "net/http"
+}
+
 // NB: New fields added to this structure must be explicitly initialized in the
 // init method below. This enables pool-based allocation.
 type responseWriter struct {
 	http.ResponseWriter
-	status int
-	size   int64
+	status   int
+	size     int64
+	maxSize  int64
+	overflow int64
+	timing   requestTiming
+	capture  io.Writer
+	writeErr error
+	cut      bool
+	held     bool
+	heldBody bytes.Buffer
+
+	compression responseCompression
+	gz          *gzip.Writer
+
+	allowlist *headerAllowlist
+	stripped  []string
+
+	headerLimits   *headerLimits
+	droppedHeaders []droppedHeader
+
+	hijacked *connSet
+
+	nullPolicy NullPolicy
+	listGuard  *listGuard
+
+	errorContentType string
+	accept           string
+	route            string
 }
 
 func (rw *responseWriter) init(base http.ResponseWriter) {
 	rw.ResponseWriter = base
 	rw.status = 0
 	rw.size = 0
+	rw.maxSize = 0
+	rw.overflow = 0
+	rw.timing.init()
+	rw.capture = nil
+	rw.writeErr = nil
+	rw.cut = false
+	rw.held = false
+	rw.heldBody.Reset()
+	rw.compression = responseCompression{}
+	rw.gz = nil
+	rw.allowlist = nil
+	rw.stripped = nil
+	rw.headerLimits = nil
+	rw.droppedHeaders = nil
+	rw.hijacked = nil
+	rw.nullPolicy = ""
+	rw.listGuard = nil
+	rw.errorContentType = ""
+	rw.accept = ""
+	rw.route = ""
+}
+
+// finishTrailers strips the trailers that aren't allowlisted once the
+// response is complete.
+func (rw *responseWriter) finishTrailers() {
+	if rw.allowlist != nil && rw.Written() {
+		rw.stripped = append(rw.stripped, rw.allowlist.sanitizeTrailers(rw.Header())...)
+	}
 }
 
 func (rw *responseWriter) WriteHeader(s int) {
+	if rw.timing.enabled && !rw.Written() {
+		rw.timing.setHeaders(rw.Header())
+	}
+	if rw.compression.enabled && !rw.Written() {
+		rw.startCompression(s)
+	}
+	if rw.allowlist != nil && !rw.Written() {
+		rw.stripped = append(rw.stripped, rw.allowlist.sanitize(rw.Header())...)
+	}
+	if rw.headerLimits != nil && !rw.Written() {
+		rw.droppedHeaders = append(rw.droppedHeaders, rw.headerLimits.limit(rw.Header())...)
+	}
 	rw.status = s
-	rw.ResponseWriter.WriteHeader(s)
+	if !rw.held {
+		rw.ResponseWriter.WriteHeader(s)
+	}
 }
 
 func (rw *responseWriter) Write(b []byte) (int, error) {
@@ -49,7 +147,35 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 		// The status will be StatusOK if WriteHeader has not been called yet
 		rw.WriteHeader(http.StatusOK)
 	}
-	size, err := rw.ResponseWriter.Write(b)
+	if rw.writeErr != nil {
+		// The client has gone away: fail fast so that serialization stops early
+		return 0, rw.writeErr
+	}
+	if rw.exceedsMaxSize(rw.size + int64(len(b))) {
+		if rw.size > 0 {
+			// Part of the body is already on its way to the client
+			rw.cut = true
+		}
+		return 0, ErrResponseTooLarge
+	}
+	if rw.held {
+		size, _ := rw.heldBody.Write(b)
+		if rw.capture != nil {
+			_, _ = rw.capture.Write(b)
+		}
+		rw.size += int64(size)
+		return size, nil
+	}
+	size, err := rw.writeBody(b)
+	if err == http.ErrContentLength {
+		// Writing more than the declared length is the server's fault
+		rw.cut = true
+	} else if err != nil {
+		rw.writeErr = err
+	}
+	if rw.capture != nil {
+		_, _ = rw.capture.Write(b[:size])
+	}
 	rw.size += int64(size)
 	return size, err
 }
@@ -66,12 +192,128 @@ func (rw *responseWriter) Size() int64 {
 	return rw.size
 }
 
+// hold defers writing the response to the client until it is released or
+// discarded. Headers are still accumulated in the client response's header map.
+func (rw *responseWriter) hold() {
+	rw.held = true
+}
+
+// release writes a held response to the client.
+func (rw *responseWriter) release() {
+	if !rw.held {
+		return
+	}
+	rw.held = false
+	if rw.status != 0 {
+		rw.ResponseWriter.WriteHeader(rw.status)
+		if rw.heldBody.Len() > 0 {
+			if _, err := rw.writeBody(rw.heldBody.Bytes()); err != nil {
+				rw.writeErr = err
+			}
+		}
+	}
+	rw.heldBody.Reset()
+}
+
+// discard drops a held response so that another may be written in its place.
+func (rw *responseWriter) discard() {
+	rw.held = false
+	rw.status = 0
+	rw.size = 0
+	rw.heldBody.Reset()
+	if rw.gz != nil {
+		rw.Header().Del(HeaderContentEncoding)
+		putGzipWriter(rw.gz, rw.compression.level)
+		rw.gz = nil
+	}
+}
+
+// startCompression decides whether to compress a response as its headers are
+// written. Responses that are captured (i.e. coalesced or cached) are shared
+// with other clients and so are never compressed.
+func (rw *responseWriter) startCompression(status int) {
+	header := rw.Header()
+	if !bodyAllowedForStatus(status) || rw.capture != nil || !rw.compression.compressible(header) {
+		return
+	}
+	addVary(header, HeaderAcceptEncoding)
+	if !rw.compression.accepted {
+		return
+	}
+	header.Set(HeaderContentEncoding, "gzip")
+	header.Del(HeaderContentLength)
+	rw.gz = getGzipWriter(rw.ResponseWriter, rw.compression.level)
+}
+
+// finishCompression flushes a compressed response body to the client.
+func (rw *responseWriter) finishCompression() {
+	if rw.gz == nil {
+		return
+	}
+	if err := rw.gz.Close(); err != nil && rw.writeErr == nil {
+		rw.writeErr = err
+	}
+	putGzipWriter(rw.gz, rw.compression.level)
+	rw.gz = nil
+}
+
+// writeBody writes response body bytes to the client, compressing them if
+// necessary.
+func (rw *responseWriter) writeBody(b []byte) (int, error) {
+	if rw.gz != nil {
+		return rw.gz.Write(b)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// clientAborted returns true if writing the response failed, typically because
+// the client went away (e.g. a broken pipe or reset connection).
+func (rw *responseWriter) clientAborted() bool {
+	return rw.writeErr != nil
+}
+
+// truncated returns true if the response body was cut short (or overran its
+// declared Content-Length) for reasons other than the client going away.
+func (rw *responseWriter) truncated() bool {
+	if rw.cut {
+		return true
+	}
+	if rw.clientAborted() || rw.held || !bodyAllowedForStatus(rw.status) {
+		return false
+	}
+	length, err := strconv.ParseInt(rw.Header().Get(HeaderContentLength), 10, 64)
+	return err == nil && rw.size < length
+}
+
+// exceedsMaxSize checks a prospective response body size against the
+// response's size limit. Violations are remembered so they can be logged.
+func (rw *responseWriter) exceedsMaxSize(size int64) bool {
+	if rw.maxSize > 0 && size > rw.maxSize {
+		if size > rw.overflow {
+			rw.overflow = size
+		}
+		return true
+	}
+	return false
+}
+
 func (rw *responseWriter) Flush() {
+	if rw.held {
+		return
+	}
+	if rw.gz != nil {
+		_ = rw.gz.Flush()
+	}
 	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
 		flusher.Flush()
 	}
 }
 
 func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	return rw.ResponseWriter.(http.Hijacker).Hijack()
+	hijacker := rw.ResponseWriter.(http.Hijacker)
+	if rw.hijacked != nil {
+		// Track the connection so that it's closed on shutdown
+		return rw.hijacked.hijack(hijacker.Hijack)
+	}
+	return hijacker.Hijack()
 }
@@ -0,0 +1,187 @@
+package luddite
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// schemaReloadDelay debounces bursts of file system events, e.g. when an
+// editor or deployment tool writes several files.
+const schemaReloadDelay = 100 * time.Millisecond
+
+// schemaSnapshot is an immutable, in-memory copy of a schema directory.
+type schemaSnapshot map[string]*schemaFile
+
+type schemaFile struct {
+	info     os.FileInfo
+	data     []byte
+	children []os.FileInfo
+}
+
+// loadSchemaSnapshot reads a schema directory tree into memory. It also
+// returns the tree's directories so that they can be watched.
+func loadSchemaSnapshot(root string) (schemaSnapshot, []string, error) {
+	snap := make(schemaSnapshot)
+	var dirs []string
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		name := path.Join("/", filepath.ToSlash(rel))
+		f := &schemaFile{info: info}
+		if info.IsDir() {
+			dirs = append(dirs, p)
+		} else if f.data, err = ioutil.ReadFile(p); err != nil {
+			return err
+		}
+		snap[name] = f
+		if name != "/" {
+			parent := snap[path.Dir(name)]
+			parent.children = append(parent.children, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, f := range snap {
+		sort.Slice(f.children, func(i, j int) bool { return f.children[i].Name() < f.children[j].Name() })
+	}
+	return snap, dirs, nil
+}
+
+// schemaWatcher is an http.FileSystem that serves a schema directory from
+// memory and atomically replaces its snapshot whenever the directory changes.
+// Requests never observe partially written or partially updated schemas.
+type schemaWatcher struct {
+	root     string
+	snapshot atomic.Value
+	watcher  *fsnotify.Watcher
+	logger   *log.Logger
+}
+
+func newSchemaWatcher(root string, logger *log.Logger) (*schemaWatcher, error) {
+	w := &schemaWatcher{
+		root:   root,
+		logger: logger,
+	}
+	snap, dirs, err := loadSchemaSnapshot(root)
+	if err != nil {
+		return nil, err
+	}
+	w.snapshot.Store(snap)
+
+	if w.watcher, err = fsnotify.NewWatcher(); err != nil {
+		return nil, err
+	}
+	w.watch(dirs)
+	go w.run()
+	return w, nil
+}
+
+func (w *schemaWatcher) watch(dirs []string) {
+	for _, dir := range dirs {
+		if err := w.watcher.Add(dir); err != nil {
+			w.logger.WithFields(log.Fields{"path": dir}).Warn("unable to watch schema directory: ", err)
+		}
+	}
+}
+
+func (w *schemaWatcher) run() {
+	var reload <-chan time.Time
+	for {
+		select {
+		case _, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if reload == nil {
+				reload = time.After(schemaReloadDelay)
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.logger.Warn("schema watcher error: ", err)
+		case <-reload:
+			reload = nil
+			w.reload()
+		}
+	}
+}
+
+func (w *schemaWatcher) reload() {
+	snap, dirs, err := loadSchemaSnapshot(w.root)
+	if err != nil {
+		// Keep serving the previous snapshot
+		w.logger.WithFields(log.Fields{"path": w.root}).Error("schema reload failed: ", err)
+		return
+	}
+	// Newly created directories must be watched too; re-adding existing ones is harmless
+	w.watch(dirs)
+	w.snapshot.Store(snap)
+	logLifecycleEvent(w.logger, LifecycleSchemasReloaded, log.Fields{"path": w.root, "files": len(snap)})
+}
+
+// Close stops watching the schema directory.
+func (w *schemaWatcher) Close() error {
+	return w.watcher.Close()
+}
+
+// Open implements http.FileSystem.
+func (w *schemaWatcher) Open(name string) (http.File, error) {
+	snap := w.snapshot.Load().(schemaSnapshot)
+	f, ok := snap[path.Clean("/"+strings.TrimPrefix(name, "/"))]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return &schemaFileReader{Reader: bytes.NewReader(f.data), f: f}, nil
+}
+
+// schemaFileReader implements http.File for a file in a schema snapshot.
+type schemaFileReader struct {
+	*bytes.Reader
+	f   *schemaFile
+	pos int
+}
+
+func (r *schemaFileReader) Close() error {
+	return nil
+}
+
+func (r *schemaFileReader) Stat() (os.FileInfo, error) {
+	return r.f.info, nil
+}
+
+func (r *schemaFileReader) Readdir(count int) ([]os.FileInfo, error) {
+	if !r.f.info.IsDir() {
+		return nil, os.ErrInvalid
+	}
+	children := r.f.children[r.pos:]
+	if count > 0 {
+		if len(children) == 0 {
+			return nil, io.EOF
+		}
+		if count < len(children) {
+			children = children[:count]
+		}
+	}
+	r.pos += len(children)
+	return children, nil
+}
@@ -0,0 +1,79 @@
+package luddite
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestSchemaWatcher(t *testing.T) {
+	root, err := ioutil.TempDir("", "luddite-schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err = os.Mkdir(filepath.Join(root, "v1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	schemaPath := filepath.Join(root, "v1", "schema.yaml")
+	if err = ioutil.WriteFile(schemaPath, []byte("version: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	w, err := newSchemaWatcher(root, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	get := func() string {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/schema.yaml", nil)
+		http.FileServer(w).ServeHTTP(rw, req)
+		return rw.Body.String()
+	}
+	if body := get(); body != "version: 1\n" {
+		t.Fatalf("incorrect schema: %q", body)
+	}
+
+	if err = ioutil.WriteFile(schemaPath, []byte("version: 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); get() != "version: 2\n"; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("schema not reloaded")
+		}
+	}
+
+	if _, err = w.Open("/v1/missing.yaml"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got: %v", err)
+	}
+}
+
+func TestSchemaWatcherClosedOnShutdown(t *testing.T) {
+	root, err := ioutil.TempDir("", "luddite-schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Schema.Enabled = true
+		config.Schema.FilePath = root
+		config.Schema.HotReload = true
+	})
+	if _, ok := s.schemas.(*schemaWatcher); !ok {
+		t.Fatal("expected schema watcher")
+	}
+	if r := s.shutdown(); r.WorkersStopped != 1 || r.WorkerErrors != nil {
+		t.Errorf("expected schema watcher to be stopped, got: %+v", r)
+	}
+}
@@ -0,0 +1,156 @@
+package luddite
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Principal is the authenticated caller of a request. Services authenticate
+// requests in their own middleware and record the resulting principal using
+// SetContextPrincipal.
+type Principal struct {
+	// Id identifies the principal, e.g. a user or client id.
+	Id string
+	// Scopes holds the scopes (or roles) granted to the principal.
+	Scopes []string
+}
+
+// HasScope reports whether the principal has been granted a scope.
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ResourceOption configures a resource added using Service.AddResource.
+type ResourceOption func(*resourceOptions)
+
+type resourceOptions struct {
+	scopes          []string
+	writeScopes     []string
+	compression     CompressionMode
+	compressionSet  bool
+	compressedTypes []string
+	nullPolicy      NullPolicy
+}
+
+// WithScopes requires the principal to have all of the given scopes for every
+// route of a resource.
+func WithScopes(scopes ...string) ResourceOption {
+	return func(o *resourceOptions) {
+		o.scopes = append(o.scopes, scopes...)
+	}
+}
+
+// WithWriteScopes requires the principal to have all of the given scopes for
+// a resource's routes that don't use safe methods (i.e. other than GET, HEAD
+// and OPTIONS).
+func WithWriteScopes(scopes ...string) ResourceOption {
+	return func(o *resourceOptions) {
+		o.writeScopes = append(o.writeScopes, scopes...)
+	}
+}
+
+// registeredResource holds the options that apply to routes under a base path.
+type registeredResource struct {
+	basePath string
+	resourceOptions
+}
+
+func (rr *registeredResource) matches(pattern string) bool {
+	return underBasePath(rr.basePath, pattern)
+}
+
+// underBasePath reports whether a route pattern or request path is a
+// resource's base path or one of its sub-paths.
+func underBasePath(basePath, p string) bool {
+	return p == basePath || strings.HasPrefix(p, strings.TrimSuffix(basePath, "/")+"/")
+}
+
+func (rr *registeredResource) required(method string) []string {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return rr.scopes
+	}
+	return append(append([]string(nil), rr.scopes...), rr.writeScopes...)
+}
+
+// matchResource returns the registered resource with the longest base path
+// matching a route among those accepted by filter (or all, if filter is nil).
+func (rt *routing) matchResource(version int, pattern string, filter func(*registeredResource) bool) *registeredResource {
+	var match *registeredResource
+	resources := rt.resources[version]
+	for i := range resources {
+		rr := &resources[i]
+		if rr.matches(pattern) && (filter == nil || filter(rr)) && (match == nil || len(rr.basePath) > len(match.basePath)) {
+			match = rr
+		}
+	}
+	return match
+}
+
+func (rr *registeredResource) declaresScopes() bool {
+	return len(rr.scopes) != 0 || len(rr.writeScopes) != 0
+}
+
+// requiredScopes returns the scopes required by a route. They're resolved only
+// from resources that declare scopes, so that a sub-resource registered with
+// other options (e.g. WithCompression) still inherits its parent's scopes.
+func (rt *routing) requiredScopes(version int, method, pattern string) []string {
+	if rr := rt.matchResource(version, pattern, (*registeredResource).declaresScopes); rr != nil {
+		return rr.required(method)
+	}
+	return nil
+}
+
+// checkScopes enforces the scopes required by the route handling a request. It
+// returns false if a 401 or 403 response was written.
+func checkScopes(rw http.ResponseWriter, req *http.Request, method, pattern string) bool {
+	d := contextHandlerDetails(req.Context())
+	if d == nil || d.s == nil {
+		return true
+	}
+	scopes := d.currentRouting().requiredScopes(d.apiVersion, method, pattern)
+	if len(scopes) == 0 {
+		return true
+	}
+	if d.principal == nil {
+		_ = WriteResponse(rw, http.StatusUnauthorized, NewError(nil, EcodeUnauthenticated))
+		return false
+	}
+	var missing []string
+	for _, scope := range scopes {
+		if !d.principal.HasScope(scope) {
+			missing = append(missing, scope)
+		}
+	}
+	if len(missing) != 0 {
+		e := NewError(nil, EcodeMissingScope, strings.Join(missing, ", "))
+		e.Details = missing
+		_ = WriteResponse(rw, http.StatusForbidden, e)
+		return false
+	}
+	return true
+}
+
+// ContextPrincipal returns the authenticated principal of the current HTTP
+// request from a context.Context, if possible.
+func ContextPrincipal(ctx context.Context) (p *Principal) {
+	if d, ok := ctx.Value(contextHandlerDetailsKey).(*handlerDetails); ok {
+		p = d.principal
+	}
+	return
+}
+
+// SetContextPrincipal records the authenticated principal of the current HTTP
+// request in its context. This is typically called by the service's
+// authentication middleware.
+func SetContextPrincipal(ctx context.Context, p *Principal) {
+	if d, ok := ctx.Value(contextHandlerDetailsKey).(*handlerDetails); ok {
+		d.principal = p
+	}
+}
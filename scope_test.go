@@ -0,0 +1,78 @@
+package luddite
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequiredScopes(t *testing.T) {
+	s := newTestService(t, nil)
+	s.AddHandler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if token := RequestBearerToken(req); token != "" {
+			SetContextPrincipal(req.Context(), &Principal{Id: "user", Scopes: strings.Split(token, ",")})
+		}
+	}))
+	r := &versionedResource{current: &versionedSample{Id: "a", Rev: "1"}}
+	mustAddResource(t, s, 1, "/samples", r, WithScopes("samples:read"), WithWriteScopes("samples:write"))
+
+	tests := []struct {
+		method  string
+		token   string
+		status  int
+		missing []string
+	}{
+		{"GET", "", http.StatusUnauthorized, nil},
+		{"GET", "samples:read", http.StatusOK, nil},
+		{"PUT", "samples:read", http.StatusForbidden, []string{"samples:write"}},
+		{"PUT", "samples:read,samples:write", http.StatusOK, nil},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, "/samples/a", strings.NewReader(`{"id":"a"}`))
+		req.Header.Set(HeaderAccept, ContentTypeJson)
+		req.Header.Set(HeaderContentType, ContentTypeJson)
+		if test.token != "" {
+			req.Header.Set(HeaderAuthorization, "Bearer "+test.token)
+		}
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, req)
+		if rw.Code != test.status {
+			t.Errorf("%s %q: expected %d response, got: %d", test.method, test.token, test.status, rw.Code)
+			continue
+		}
+		if test.missing != nil {
+			var e Error
+			if err := json.Unmarshal(rw.Body.Bytes(), &e); err != nil {
+				t.Errorf("%s %q: error body not decoded: %v", test.method, test.token, err)
+			} else if e.Code != EcodeMissingScope || len(e.Details) != 1 || e.Details[0] != test.missing[0] {
+				t.Errorf("%s %q: incorrect error: %+v", test.method, test.token, e)
+			}
+		}
+	}
+}
+
+func TestScopesInheritedBySubResource(t *testing.T) {
+	s := newTestService(t, nil)
+	s.AddHandler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if token := RequestBearerToken(req); token != "" {
+			SetContextPrincipal(req.Context(), &Principal{Id: "user", Scopes: strings.Split(token, ",")})
+		}
+	}))
+	mustAddResource(t, s, 1, "/users", &versionedResource{current: &versionedSample{Id: "a", Rev: "1"}}, WithScopes("admin"))
+	mustAddResource(t, s, 1, "/users/photos", &versionedResource{current: &versionedSample{Id: "a", Rev: "1"}}, WithCompression(CompressionOff))
+
+	for token, status := range map[string]int{"": http.StatusUnauthorized, "user": http.StatusForbidden, "admin": http.StatusOK} {
+		req, _ := http.NewRequest("GET", "/users/photos/a", nil)
+		req.Header.Set(HeaderAccept, ContentTypeJson)
+		if token != "" {
+			req.Header.Set(HeaderAuthorization, "Bearer "+token)
+		}
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, req)
+		if rw.Code != status {
+			t.Errorf("%q: expected %d response, got: %d", token, status, rw.Code)
+		}
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -42,16 +43,41 @@ var (
 
 // Service implements a standalone RESTful web service.
 type Service struct {
-	config        *ServiceConfig
-	defaultLogger *log.Logger
-	accessLogger  *log.Logger
-	globalRouter  *httptreemux.ContextMux
-	apiRouters    map[int]*httptreemux.ContextMux
-	handlers      []http.Handler
-	cors          *cors.Cors
-	tracer        context.Context
-	schemas       http.FileSystem
-	once          sync.Once
+	config                  *ServiceConfig
+	defaultLogger           *log.Logger
+	accessLogger            *log.Logger
+	globalRouter            *httptreemux.ContextMux
+	routing                 atomic.Value
+	routingMu               sync.Mutex
+	swap                    atomic.Value
+	directRoutes            map[int]bool
+	handlers                []http.Handler
+	cors                    *cors.Cors
+	tracer                  context.Context
+	schemas                 http.FileSystem
+	httpClients             map[string]*http.Client
+	httpClientsMu           sync.Mutex
+	coalescer               *coalescer
+	cache                   *responseCache
+	preferencesResolver     PreferencesResolver
+	eventQueue              *eventQueue
+	notFoundHandler         http.Handler
+	methodNotAllowedHandler http.Handler
+	fallbacks               []fallback
+	draining                int32
+	baseCtx                 context.Context
+	cancelBase              context.CancelFunc
+	inflight                int32
+	drained                 int32
+	drainMu                 sync.Mutex
+	hijacked                connSet
+	shutdownHooks           []shutdownHook
+	effectiveConfig         interface{}
+	plugins                 []PluginInfo
+	headerAllowlist         *headerAllowlist
+	headerLimits            *headerLimits
+	breakers                *breakerSet
+	once                    sync.Once
 }
 
 // NewService creates a new Service instance based on the given config.
@@ -66,13 +92,16 @@ func NewService(config *ServiceConfig) (*Service, error) {
 
 	// Create the service and its routers
 	s := &Service{
-		config:       config,
-		globalRouter: newRouter(),
-		apiRouters:   make(map[int]*httptreemux.ContextMux, config.Version.Max-config.Version.Min+1),
+		config: config,
 	}
+	s.baseCtx, s.cancelBase = context.WithCancel(context.WithValue(context.Background(), contextBaseKey, s))
+	s.globalRouter = s.newRouter()
+	rt := newRouting()
 	for v := config.Version.Min; v <= config.Version.Max; v++ {
-		s.apiRouters[v] = newRouter()
+		rt.routers[v] = s.newRouter()
 	}
+	s.routing.Store(rt)
+	s.swap.Store(resourceSwap{})
 
 	// Create the service loggers
 	s.defaultLogger = &log.Logger{
@@ -119,12 +148,64 @@ func NewService(config *ServiceConfig) (*Service, error) {
 	}
 
 	// Add default middleware handlers
-	s.AddHandler(newNegotiatorHandler(negotiatedContentTypes))
-	s.AddHandler(newVersionHandler(s.config.Version.Min, s.config.Version.Max))
+	if config.Timing.Enabled {
+		s.AddHandler(newTimingHandler())
+	}
+	if config.Mirror.Enabled {
+		s.AddHandler(newMirrorHandler(s, config.Mirror.URL, config.Mirror.SampleRate, config.Mirror.MaxBodySize, config.Mirror.MaxOutstanding))
+	}
+	if config.Classification.Enabled {
+		s.AddHandler(newClassifierHandler(config.Classification.Rules, config.Classification.Default))
+	}
+	s.AddHandler(newNegotiatorHandler(negotiatedContentTypes, config.Errors.DefaultContentType))
+	if l := config.Limits; l.MaxQueryLength > 0 || l.MaxQueryParams > 0 || l.MaxQueryValues > 0 {
+		s.AddHandler(newQueryLimiterHandler(l.MaxQueryLength, l.MaxQueryParams, l.MaxQueryValues))
+	}
+	s.AddHandler(newVersionHandler(s.config.Version.Min, s.config.Version.Max, s.config.Version.DefaultContentTypes))
+	if config.Preferences.Enabled {
+		loc, _ := time.LoadLocation(config.Preferences.DefaultTimeZone)
+		s.AddHandler(newPreferencesHandler(s, Preferences{
+			Locale:   config.Preferences.DefaultLocale,
+			TimeZone: loc,
+			Units:    config.Preferences.DefaultUnits,
+		}))
+	}
+
+	// Fail fast on routes whose handlers keep failing
+	if config.CircuitBreaker.Enabled {
+		cb := config.CircuitBreaker
+		s.breakers = newBreakerSet(cb.ErrorRate, cb.Latency, cb.MinRequests, cb.Window, cb.CoolDown)
+	}
+
+	// Strip response headers that aren't allowlisted
+	if config.ResponseHeaders.Enabled {
+		s.headerAllowlist = newHeaderAllowlist(config.ResponseHeaders.Allowed)
+	}
+
+	// Drop response headers that proxies might reject as oversized
+	if config.Limits.MaxResponseHeaderSize > 0 || config.Limits.MaxResponseHeadersSize > 0 {
+		s.headerLimits = newHeaderLimits(config.Limits.MaxResponseHeaderSize, config.Limits.MaxResponseHeadersSize)
+	}
+
+	// Coalesce identical concurrent GETs and cache their responses
+	if config.Coalescing.Enabled {
+		s.coalescer = newCoalescer(config.Coalescing.PathPrefixes)
+	}
+	if config.Cache.Enabled {
+		s.cache = newResponseCache(s, config.Cache.MaxEntries)
+	}
 
 	// Create the default schema filesystem
 	if config.Schema.Enabled {
 		s.schemas = http.Dir(config.Schema.FilePath)
+		if config.Schema.HotReload {
+			if w, err := newSchemaWatcher(config.Schema.FilePath, s.defaultLogger); err == nil {
+				s.schemas = w
+				s.OnShutdown("schemas", w.Close)
+			} else {
+				s.defaultLogger.WithFields(log.Fields{"path": config.Schema.FilePath}).Error("schema hot reload disabled: ", err)
+			}
+		}
 	}
 
 	// Dump goroutine stacks on demand
@@ -143,12 +224,28 @@ func (s *Service) Logger() *log.Logger {
 }
 
 // Router returns the service's router instance for the given API version.
+// Resources can't be removed or replaced (see RemoveResource) from an API
+// version whose router has been returned, since routes may have been added to
+// it directly.
 func (s *Service) Router(version int) (*httptreemux.ContextMux, error) {
+	router, err := s.router(version)
+	if err != nil {
+		return nil, err
+	}
+	s.routingMu.Lock()
+	if s.directRoutes == nil {
+		s.directRoutes = make(map[int]bool)
+	}
+	s.directRoutes[version] = true
+	s.routingMu.Unlock()
+	return router, nil
+}
+
+func (s *Service) router(version int) (*httptreemux.ContextMux, error) {
 	if version < s.config.Version.Min || version > s.config.Version.Max {
 		return nil, fmt.Errorf("API version is out of range (min: %d, max: %d)", s.config.Version.Min, s.config.Version.Max)
 	}
-	router := s.apiRouters[version]
-	return router, nil
+	return s.currentRouting().routers[version], nil
 }
 
 // AddHandler adds a middleware handler to the service's middleware stack. All
@@ -161,15 +258,15 @@ func (s *Service) AddHandler(h http.Handler) {
 // a resource handler and adds routes as appropriate based on what interfaces
 // are implemented. The same effect can be achieved by calling the various
 // "Add*CollectionResource" and "Add*SingletonResource" functions with the
-// appropriate router instance.
-func (s *Service) AddResource(version int, basePath string, r interface{}) error {
-	router, err := s.Router(version)
+// appropriate router instance. Options (e.g. WithScopes or WithCompression)
+// apply to all of the resource's routes. Resources must be added before the
+// service is run; use ReplaceResource to add them at runtime.
+func (s *Service) AddResource(version int, basePath string, r interface{}, opts ...ResourceOption) error {
+	router, err := s.router(version)
 	if err != nil {
 		return err
 	}
-
-	s.addCollectionRoutes(router, basePath, r)
-	s.addSingletonRoutes(router, basePath, r)
+	s.mountResource(s.currentRouting(), router, version, mountedResource{basePath: basePath, r: r, opts: opts})
 	return nil
 }
 
@@ -180,6 +277,13 @@ func (s *Service) SetSchemas(schemas http.FileSystem) {
 	s.schemas = schemas
 }
 
+// SetPreferencesResolver allows a service to resolve the rendering preferences
+// stored for the principal making each request, e.g. from a user profile. It
+// has no effect unless preference resolution is enabled in the service config.
+func (s *Service) SetPreferencesResolver(resolver PreferencesResolver) {
+	s.preferencesResolver = resolver
+}
+
 // Run starts the service's HTTP server and runs it forever or until SIGINT is
 // received. This method should be invoked once per service.
 func (s *Service) Run() (err error) {
@@ -188,6 +292,7 @@ func (s *Service) Run() (err error) {
 }
 
 func (s *Service) addMetricsRoute() {
+	registerMetrics()
 	h := prometheus.UninstrumentedHandler()
 	s.globalRouter.GET(s.config.Metrics.URIPath, h.ServeHTTP)
 }
@@ -248,12 +353,18 @@ func (s *Service) addCollectionRoutes(router *httptreemux.ContextMux, basePath s
 	if x, ok := r.(CollectionUpdater); ok {
 		AddUpdateCollectionRoute(router, basePath, x)
 	}
+	if x, ok := r.(CollectionPatcher); ok {
+		AddPatchCollectionRoute(router, basePath, x)
+	}
 	if x, ok := r.(CollectionDeleter); ok {
 		AddDeleteCollectionRoute(router, basePath, x)
 	}
 	if x, ok := r.(CollectionActioner); ok {
 		AddActionCollectionRoute(router, basePath, x)
 	}
+	if x, ok := r.(CollectionExporter); ok {
+		AddExportCollectionRoute(router, basePath, x)
+	}
 }
 
 func (s *Service) addSingletonRoutes(router *httptreemux.ContextMux, basePath string, r interface{}) {
@@ -263,6 +374,9 @@ func (s *Service) addSingletonRoutes(router *httptreemux.ContextMux, basePath st
 	if x, ok := r.(SingletonUpdater); ok {
 		AddUpdateSingletonRoute(router, basePath, x)
 	}
+	if x, ok := r.(SingletonPatcher); ok {
+		AddPatchSingletonRoute(router, basePath, x)
+	}
 	if x, ok := r.(SingletonActioner); ok {
 		AddActionSingletonRoute(router, basePath, x)
 	}
@@ -327,6 +441,12 @@ func (s *Service) run() error {
 	}
 
 	// Add optional HTTP handlers
+	if s.config.Admin.Enabled {
+		s.addAdminRoutes()
+	}
+	if s.config.Ready.Enabled {
+		s.addReadyRoute()
+	}
 	if s.config.Metrics.Enabled {
 		s.addMetricsRoute()
 	}
@@ -362,13 +482,27 @@ func (s *Service) run() error {
 		h = s.ServeHTTP
 	}
 
-	// Run the HTTP server
-	if err = http.Serve(l, h); err != nil {
+	s.LogLifecycleEvent(LifecycleListenerStarted, log.Fields{"addr": l.Addr().String(), "tls": config.Transport.TLS})
+
+	// Run the HTTP server. Once it stops, wait for requests that are still
+	// in flight to drain. Keep-alives are disabled so that idle connections are
+	// closed and busy ones close after their current request rather than
+	// serving new ones. Request contexts derive from the base context, so that
+	// cancelling it reaches them without a watcher per request.
+	srv := &http.Server{
+		Handler:     h,
+		BaseContext: func(net.Listener) context.Context { return s.baseCtx },
+	}
+	err = srv.Serve(l)
+	s.setDraining()
+	srv.SetKeepAlivesEnabled(false)
+	if err != nil {
 		// Ignore ListenerStoppedError
 		if _, ok := err.(*ListenerStoppedError); ok {
 			err = nil
 		}
 	}
+	s.shutdown()
 	return err
 }
 
@@ -380,8 +514,38 @@ func (s *Service) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		res      *responseWriter
 		d        *handlerDetails
 		err      error
+		abort    bool
 	)
 
+	// Track in-flight requests so that shutdown can drain them, and count the
+	// ones that complete while it does
+	atomic.AddInt32(&s.inflight, 1)
+	predrain := !s.isDraining()
+	defer func() {
+		if predrain && s.isDraining() {
+			s.drainMu.Lock()
+			atomic.AddInt32(&s.drained, 1)
+			atomic.AddInt32(&s.inflight, -1)
+			s.drainMu.Unlock()
+		} else {
+			atomic.AddInt32(&s.inflight, -1)
+		}
+	}()
+	if !predrain {
+		// Requests arriving on open connections while draining close them
+		rw.Header().Set(HeaderConnection, "close")
+	}
+
+	// Close the connection when a response is truncated so that the client
+	// can't mistake a partial body for a complete one. This happens
+	// after (and so escapes) the panic recovery below, and only when rw is
+	// net/http's own connection writer.
+	defer func() {
+		if abort {
+			panic(http.ErrAbortHandler)
+		}
+	}()
+
 	// Don't allow panics to escape under any circumstances!
 	defer func() {
 		if rcv := recover(); rcv != nil {
@@ -418,6 +582,17 @@ func (s *Service) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		}
 	}
 
+	// Apply the service's per-request deadline
+	if timeout := s.config.Limits.RequestTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx0, cancel = context.WithTimeout(ctx0, timeout)
+		defer cancel()
+	}
+
+	// Cancel the request if it's abandoned on shutdown
+	ctx0, cancelOnShutdown := s.withBaseContext(ctx0)
+	defer cancelOnShutdown()
+
 	// Trace using either using an existing trace id (recovered from the
 	// X-Request-Id header in the form "traceId:parentId") or a newly
 	// generated one. Add the trace id to the request context.
@@ -441,6 +616,9 @@ func (s *Service) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		// Create a new response writer
 		res = responseWriterPool.Get().(*responseWriter)
 		res.init(rw)
+		res.allowlist = s.headerAllowlist
+		res.headerLimits = s.headerLimits
+		res.hijacked = &s.hijacked
 
 		// Create new handler details and to the request context
 		d = handlerDetailsPool.Get().(*handlerDetails)
@@ -452,6 +630,13 @@ func (s *Service) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		req = req.WithContext(ctx1)
 		d.request = req
 
+		// Count request body bytes when the length isn't known in advance
+		var body *countingBody
+		if req.ContentLength < 0 && req.Body != nil {
+			body = &countingBody{ReadCloser: req.Body}
+			req.Body = body
+		}
+
 		defer func() {
 			var (
 				latency = time.Since(start)
@@ -462,27 +647,117 @@ func (s *Service) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 
 			// If a panic occurs in a downstream handler generate a fail-safe response
 			if rcv = recover(); rcv != nil {
-				var resp *Error
+				var resp interface{}
 				if err, ok := rcv.(error); ok && err == context.Canceled {
 					// Context cancelation is not an error: use the 418 status as a log marker
 					status = http.StatusTeapot
+				} else if abortStatus, abortResp, ok := recoveredResponse(s, rcv); ok {
+					// Deliberate abort: write the response as if the resource had returned it
+					s.defaultLogger.WithFields(log.Fields{
+						"request_id": requestId,
+						"status":     abortStatus,
+					}).Debug("request aborted: ", rcv)
+					status, resp = abortStatus, abortResp
 				} else {
 					// Unhandled error: return a 500 response
 					stackBuffer := make([]byte, maxStackSize)
 					stack = string(stackBuffer[:runtime.Stack(stackBuffer, false)])
 					s.defaultLogger.WithFields(log.Fields{"stack": stack}).Error(rcv)
 
-					resp = NewError(nil, EcodeInternal, rcv)
+					// Errors raised with an unmapped code keep their code and
+					// message. They're copied since the panicked value may be
+					// shared, e.g. a package-level error.
+					var e *Error
+					if panicked, ok := rcv.(*Error); ok {
+						copied := *panicked
+						e = &copied
+					} else {
+						e = NewError(nil, EcodeInternal, rcv)
+					}
 					if s.config.Debug.Stacks {
 						if respStackSize := s.config.Debug.StackSize; len(stack) > respStackSize {
-							resp.Stack = stack[:respStackSize]
+							e.Stack = stack[:respStackSize]
 						} else {
-							resp.Stack = stack
+							e.Stack = stack
 						}
 					}
+					resp = e
 					status = http.StatusInternalServerError
 				}
-				_ = WriteResponse(res, status, resp)
+				if res.Written() {
+					// The response is already under way: an error body would corrupt it
+					res.cut = true
+				} else {
+					_ = WriteResponse(res, status, resp)
+				}
+			}
+			res.finishCompression()
+			res.finishTrailers()
+
+			// Discard events the resource neither committed nor rolled back
+			s.resolveEventTxs(d)
+
+			// Count the outcome against the route's circuit breaker
+			if d.breaker != nil {
+				d.breaker.record(status, latency, time.Now())
+			}
+
+			// Record request and response sizes
+			requestSize := req.ContentLength
+			if body != nil {
+				requestSize = body.size
+			}
+			route := routeLabel(d)
+			httpRequestSize.WithLabelValues(req.Method, route).Observe(float64(requestSize))
+			httpResponseSize.WithLabelValues(req.Method, route).Observe(float64(res.Size()))
+			if res.clientAborted() {
+				httpClientAborts.WithLabelValues(req.Method, route).Inc()
+			}
+			cancelReason := s.cancelReason(ctx1)
+			if cancelReason != "" {
+				httpCancellations.WithLabelValues(req.Method, route, cancelReason).Inc()
+			}
+			if len(res.stripped) != 0 {
+				s.defaultLogger.WithFields(log.Fields{
+					"request_id": requestId,
+					"method":     req.Method,
+					"route":      d.route,
+					"headers":    res.stripped,
+				}).Warn("stripped response headers that aren't allowlisted")
+			}
+			if len(res.droppedHeaders) != 0 {
+				httpResponseHeadersDropped.WithLabelValues(req.Method, route).Add(float64(len(res.droppedHeaders)))
+				s.defaultLogger.WithFields(log.Fields{
+					"request_id": requestId,
+					"method":     req.Method,
+					"route":      d.route,
+					"headers":    res.droppedHeaders,
+				}).Warn("dropped oversized response headers")
+			}
+			truncated := res.truncated()
+			if truncated {
+				httpTruncatedResponses.WithLabelValues(req.Method, route).Inc()
+				s.defaultLogger.WithFields(log.Fields{
+					"request_id": requestId,
+					"method":     req.Method,
+					"route":      d.route,
+					"status":     res.Status(),
+					"size":       res.Size(),
+				}).Error("response body truncated")
+				if n, ok := rw.(TruncationNotifier); ok {
+					n.ResponseTruncated()
+				} else {
+					abort = isConnWriter(rw, req)
+				}
+			}
+			if res.overflow > 0 {
+				s.defaultLogger.WithFields(log.Fields{
+					"request_id": requestId,
+					"method":     req.Method,
+					"route":      d.route,
+					"size":       res.overflow,
+					"max_size":   res.maxSize,
+				}).Error("response exceeded maximum size")
 			}
 
 			// Log the request
@@ -507,8 +782,22 @@ func (s *Service) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			if sessionId != "" {
 				fields["session_id"] = sessionId
 			}
+			if d.requestClass != "" {
+				fields["request_class"] = d.requestClass
+			}
+			if cancelReason != "" {
+				fields["cancel_reason"] = cancelReason
+			}
+			if res.clientAborted() {
+				// Write failures are the client's doing, not server errors
+				fields["client_abort"] = true
+				fields["write_error"] = res.writeErr.Error()
+			}
+			if truncated {
+				fields["truncated"] = true
+			}
 			entry := s.accessLogger.WithFields(fields)
-			if status/100 != 5 {
+			if status/100 != 5 || res.clientAborted() {
 				entry.Info()
 			} else {
 				entry.Error()
@@ -521,6 +810,12 @@ func (s *Service) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 				data["request_progress"] = ContextRequestProgress(ctx1)
 				data["response_status"] = res.Status()
 				data["response_size"] = res.Size()
+				if res.clientAborted() {
+					data["client_abort"] = true
+				}
+				if truncated {
+					data["truncated"] = true
+				}
 				if req.URL.RawQuery != "" {
 					data["query"] = req.URL.RawQuery
 				}
@@ -551,22 +846,34 @@ func (s *Service) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		// Finally, dispatch to a resource via an API router
-		router := s.apiRouters[d.apiVersion]
-		router.ServeHTTP(res, req)
+		// Finally, dispatch to a resource via an API router, unless the
+		// resource is being removed or replaced. The request is counted
+		// against its resource before the check so that a swap waits for it.
+		rt, m := s.enterRouting(d.apiVersion, req.URL.Path)
+		if m != nil {
+			defer atomic.AddInt32(m.inflight, -1)
+		}
+		d.routing = rt
+		if s.swappingResource(d.apiVersion, req.URL.Path) {
+			res.Header().Set(HeaderRetryAfter, strconv.Itoa(swapRetryAfter))
+			_ = WriteResponse(res, http.StatusServiceUnavailable, NewError(nil, EcodeResourceUnavailable, req.URL.Path))
+			return
+		}
+		router := rt.routers[d.apiVersion]
+		var h http.Handler = router
+		if s.coalescer != nil && s.coalescer.match(req) {
+			h = http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+				s.coalescer.serve(res, req, d.apiVersion, router)
+			})
+		}
+		if s.cache != nil && req.Method == "GET" {
+			s.cache.serve(res, req, d.apiVersion, h)
+			return
+		}
+		h.ServeHTTP(res, req)
 	})
 }
 
-func newRouter() *httptreemux.ContextMux {
-	router := httptreemux.NewContextMux()
-	router.NotFoundHandler = notFoundHandler
-	return router
-}
-
-func notFoundHandler(rw http.ResponseWriter, _ *http.Request) {
-	rw.WriteHeader(http.StatusNotFound)
-}
-
 func openLogFile(logger *log.Logger, logPath string) {
 	sigs := make(chan os.Signal, 1)
 	logging := make(chan bool, 1)
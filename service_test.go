@@ -0,0 +1,33 @@
+package luddite
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// newTestService returns a service serving API version 1 with its default
+// logger discarded. configure, if non-nil, adjusts the config before the
+// service is created.
+func newTestService(t *testing.T, configure func(*ServiceConfig)) *Service {
+	t.Helper()
+	config := &ServiceConfig{}
+	config.Version.Min = 1
+	config.Version.Max = 1
+	if configure != nil {
+		configure(config)
+	}
+	s, err := NewService(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.defaultLogger.Out = ioutil.Discard
+	return s
+}
+
+// mustAddResource adds a resource to s, failing the test on error.
+func mustAddResource(t *testing.T, s *Service, version int, basePath string, r interface{}, opts ...ResourceOption) {
+	t.Helper()
+	if err := s.AddResource(version, basePath, r, opts...); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,180 @@
+package luddite
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ShutdownReport summarizes a graceful shutdown. It's logged with the
+// shutdown_complete lifecycle event and, if the service config's
+// Log.ShutdownReportPath is set, written there as JSON.
+type ShutdownReport struct {
+	// Started is when the service stopped listening.
+	Started time.Time `json:"started"`
+	// Duration is how long shutdown took, in seconds.
+	Duration float64 `json:"duration"`
+	// InFlight is the number of requests in flight when the service stopped listening.
+	InFlight int `json:"in_flight"`
+	// Drained is the number of requests in flight when the service stopped listening that completed before the drain timeout.
+	Drained int `json:"drained"`
+	// Aborted is the number of requests still in flight after the drain timeout.
+	Aborted int `json:"aborted"`
+	// HijackedClosed is the number of hijacked connections (e.g. websockets) closed, including those hijacked during the drain.
+	HijackedClosed int `json:"hijacked_closed"`
+	// WorkersStopped is the number of shutdown hooks that completed without error.
+	WorkersStopped int `json:"workers_stopped"`
+	// WorkerErrors holds the errors of shutdown hooks that failed, keyed by hook name.
+	WorkerErrors map[string]string `json:"worker_errors,omitempty"`
+}
+
+type shutdownHook struct {
+	name string
+	stop func() error
+}
+
+// OnShutdown registers a function that stops one of the service's workers
+// (e.g. a queue consumer) during graceful shutdown. Hooks run after in-flight
+// requests have drained, in reverse order of registration, and are reported by
+// name in the shutdown report.
+func (s *Service) OnShutdown(name string, stop func() error) {
+	s.shutdownHooks = append(s.shutdownHooks, shutdownHook{name, stop})
+}
+
+// shutdown closes hijacked connections, drains in-flight requests and stops
+// workers once the service has stopped listening, then reports the outcome.
+func (s *Service) shutdown() *ShutdownReport {
+	s.setDraining()
+	r := &ShutdownReport{
+		Started:  time.Now(),
+		InFlight: int(atomic.LoadInt32(&s.inflight)),
+	}
+	s.LogLifecycleEvent(LifecycleShutdownInitiated, log.Fields{"inflight": r.InFlight})
+
+	// Hijacked connections (e.g. websockets) never complete on their own:
+	// close them first so that their handlers return while draining
+	s.hijacked.closeAll()
+	aborted, drained := s.drain()
+	r.Aborted, r.Drained = int(aborted), int(drained)
+	if s.cancelBase != nil {
+		// Cancel the requests abandoned by the drain
+		s.cancelBase()
+	}
+	r.HijackedClosed = s.hijacked.closedCount()
+	s.LogLifecycleEvent(LifecycleDrainComplete, log.Fields{
+		"duration":  time.Since(r.Started).Seconds(),
+		"abandoned": r.Aborted,
+	})
+
+	for i := len(s.shutdownHooks) - 1; i >= 0; i-- {
+		hook := s.shutdownHooks[i]
+		if err := hook.stop(); err != nil {
+			if r.WorkerErrors == nil {
+				r.WorkerErrors = make(map[string]string)
+			}
+			r.WorkerErrors[hook.name] = err.Error()
+			continue
+		}
+		r.WorkersStopped++
+	}
+	r.Duration = time.Since(r.Started).Seconds()
+
+	s.LogLifecycleEvent(LifecycleShutdownComplete, log.Fields{
+		"duration":        r.Duration,
+		"in_flight":       r.InFlight,
+		"drained":         r.Drained,
+		"aborted":         r.Aborted,
+		"hijacked_closed": r.HijackedClosed,
+		"workers_stopped": r.WorkersStopped,
+		"worker_errors":   r.WorkerErrors,
+	})
+	if path := s.config.Log.ShutdownReportPath; path != "" {
+		b, err := json.MarshalIndent(r, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(path, b, 0644)
+		}
+		if err != nil {
+			s.defaultLogger.WithField("path", path).Warn("shutdown report not written: ", err)
+		}
+	}
+	return r
+}
+
+// connSet tracks hijacked connections so that they can be closed on shutdown.
+// Once closeAll has been called, connections are closed as soon as they're
+// hijacked.
+type connSet struct {
+	mu     sync.Mutex
+	conns  map[*trackedConn]struct{}
+	closed bool
+	count  int
+}
+
+func (cs *connSet) add(conn net.Conn) net.Conn {
+	c := &trackedConn{Conn: conn, set: cs}
+	cs.mu.Lock()
+	if cs.closed {
+		cs.count++
+		cs.mu.Unlock()
+		_ = conn.Close()
+		return c
+	}
+	if cs.conns == nil {
+		cs.conns = make(map[*trackedConn]struct{})
+	}
+	cs.conns[c] = struct{}{}
+	cs.mu.Unlock()
+	return c
+}
+
+func (cs *connSet) remove(c *trackedConn) {
+	cs.mu.Lock()
+	delete(cs.conns, c)
+	cs.mu.Unlock()
+}
+
+// closeAll closes the tracked connections and any hijacked from then on.
+func (cs *connSet) closeAll() {
+	cs.mu.Lock()
+	conns := cs.conns
+	cs.conns = nil
+	cs.closed = true
+	cs.count += len(conns)
+	cs.mu.Unlock()
+	for c := range conns {
+		_ = c.Conn.Close()
+	}
+}
+
+// closedCount returns the number of connections closed by closeAll.
+func (cs *connSet) closedCount() int {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.count
+}
+
+// trackedConn is a hijacked connection that leaves its connSet when closed.
+type trackedConn struct {
+	net.Conn
+	set *connSet
+}
+
+func (c *trackedConn) Close() error {
+	c.set.remove(c)
+	return c.Conn.Close()
+}
+
+// hijack hijacks a connection and tracks it until it's closed.
+func (cs *connSet) hijack(hijack func() (net.Conn, *bufio.ReadWriter, error)) (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := hijack()
+	if err != nil {
+		return conn, brw, err
+	}
+	return cs.add(conn), brw, nil
+}
@@ -0,0 +1,156 @@
+package soak
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Item is the synthetic resource served during a soak run.
+type Item struct {
+	Id   string   `json:"id" xml:"id"`
+	Name string   `json:"name" xml:"name"`
+	Tags []string `json:"tags" xml:"tags"`
+	Data string   `json:"data" xml:"data"`
+}
+
+// itemPatch is a JSON Merge Patch document for an Item.
+type itemPatch struct {
+	Name *string `json:"name"`
+	Data *string `json:"data"`
+}
+
+// items is a collection resource holding synthetic Items in memory. Listing
+// returns the seeded items only, so that list payloads stay the same size
+// however many items are created during a run.
+type items struct {
+	mu     sync.RWMutex
+	seeded []*Item
+	byId   map[string]*Item
+	nextId int
+}
+
+func newItems(count, size int) *items {
+	r := &items{byId: make(map[string]*Item, count)}
+	for i := 0; i < count; i++ {
+		item := newItem(strconv.Itoa(i), size)
+		r.seeded = append(r.seeded, item)
+		r.byId[item.Id] = item
+	}
+	r.nextId = count
+	return r
+}
+
+func newItem(id string, size int) *Item {
+	return &Item{
+		Id:   id,
+		Name: "item-" + id,
+		Tags: []string{"soak", "synthetic"},
+		Data: strings.Repeat("x", size),
+	}
+}
+
+func (r *items) New() interface{} {
+	return &Item{}
+}
+
+func (r *items) NewPatch() interface{} {
+	return &itemPatch{}
+}
+
+func (r *items) Id(value interface{}) string {
+	return value.(*Item).Id
+}
+
+func (r *items) List(req *http.Request) (int, interface{}) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]Item, len(r.seeded))
+	for i, item := range r.seeded {
+		list[i] = *item
+	}
+	return http.StatusOK, list
+}
+
+func (r *items) Get(req *http.Request, id string) (int, interface{}) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	item, ok := r.byId[id]
+	if !ok {
+		return http.StatusNotFound, nil
+	}
+	v := *item
+	return http.StatusOK, &v
+}
+
+func (r *items) Create(req *http.Request, value interface{}) (int, interface{}) {
+	item := value.(*Item)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item.Id = strconv.Itoa(r.nextId)
+	r.nextId++
+	r.byId[item.Id] = item
+	v := *item
+	return http.StatusCreated, &v
+}
+
+func (r *items) Update(req *http.Request, id string, value interface{}) (int, interface{}) {
+	item := value.(*Item)
+	item.Id = id
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byId[id]; !ok {
+		return http.StatusNotFound, nil
+	}
+	r.replace(item)
+	v := *item
+	return http.StatusOK, &v
+}
+
+func (r *items) Patch(req *http.Request, id string, patch interface{}) (int, interface{}) {
+	p := patch.(*itemPatch)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cur, ok := r.byId[id]
+	if !ok {
+		return http.StatusNotFound, nil
+	}
+	item := *cur
+	if p.Name != nil {
+		item.Name = *p.Name
+	}
+	if p.Data != nil {
+		item.Data = *p.Data
+	}
+	r.replace(&item)
+	return http.StatusOK, &item
+}
+
+// Delete removes an item. Deleting a missing item succeeds so that deletes
+// are idempotent.
+func (r *items) Delete(req *http.Request, id string) (int, interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.seededIndex(id); ok {
+		// Seeded items back the other scenarios and are never removed
+		return http.StatusConflict, nil
+	}
+	delete(r.byId, id)
+	return http.StatusNoContent, nil
+}
+
+// replace stores a new version of an item. Items are replaced rather than
+// modified so that values already returned to handlers aren't mutated.
+func (r *items) replace(item *Item) {
+	r.byId[item.Id] = item
+	if i, ok := r.seededIndex(item.Id); ok {
+		r.seeded[i] = item
+	}
+}
+
+// seededIndex returns the index of a seeded item given its id.
+func (r *items) seededIndex(id string) (int, bool) {
+	i, err := strconv.Atoi(id)
+	return i, err == nil && i >= 0 && i < len(r.seeded) && strconv.Itoa(i) == id
+}
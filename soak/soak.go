@@ -0,0 +1,470 @@
+// Package soak is a load-test harness for the luddite handler stack. It runs a
+// Service with synthetic resources behind a real HTTP server, drives it with a
+// configurable mix of concurrent requests and reports latency, allocation and
+// goroutine counts that can be checked against budgets, so that performance
+// regressions are caught before release.
+package soak
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SpirentOrion/luddite.v2"
+)
+
+// Op identifies the request that a scenario makes.
+type Op string
+
+const (
+	// OpList lists the seeded items: `GET /items`.
+	OpList Op = "list"
+	// OpGet gets a seeded item: `GET /items/id`.
+	OpGet Op = "get"
+	// OpCreate creates an item: `POST /items`.
+	OpCreate Op = "create"
+	// OpUpdate replaces a seeded item: `PUT /items/id`.
+	OpUpdate Op = "update"
+	// OpPatch patches a seeded item: `PATCH /items/id`.
+	OpPatch Op = "patch"
+	// OpDelete deletes an item previously created by the same worker (or a
+	// missing item): `DELETE /items/id`.
+	OpDelete Op = "delete"
+)
+
+const (
+	defaultConcurrency = 8
+	defaultRequests    = 1000
+	defaultItems       = 100
+	defaultItemSize    = 256
+
+	// settleTimeout bounds how long goroutines are given to exit after a run.
+	settleTimeout = 2 * time.Second
+)
+
+var (
+	// ErrInvalidConfig is returned by Run for configs with unknown ops or
+	// negative values.
+	ErrInvalidConfig = errors.New("soak config must have known ops and non-negative values")
+
+	// DefaultMix is a read-heavy mix of requests with small payloads.
+	DefaultMix = []Scenario{
+		{Name: "list", Op: OpList, Weight: 2},
+		{Name: "get", Op: OpGet, Weight: 10},
+		{Name: "create", Op: OpCreate, Weight: 2, PayloadSize: 1024},
+		{Name: "update", Op: OpUpdate, Weight: 1, PayloadSize: 1024},
+		{Name: "patch", Op: OpPatch, Weight: 1, PayloadSize: 256},
+		{Name: "delete", Op: OpDelete, Weight: 2},
+	}
+)
+
+// Scenario is one kind of request in a soak run's mix.
+type Scenario struct {
+	// Name identifies the scenario in reports. Defaults to the op.
+	Name string
+	// Op sets the request that the scenario makes.
+	Op Op
+	// Weight sets how often the scenario is chosen relative to the others in the mix. Defaults to 1.
+	Weight int
+	// PayloadSize sets the size in bytes of the data written by create, update and patch requests.
+	PayloadSize int
+}
+
+// Config configures a soak run. Zero values select the defaults.
+type Config struct {
+	// Service sets the config of the Service under test. Defaults to a config serving API version 1 only. The service's logs are discarded.
+	Service *luddite.ServiceConfig
+	// Concurrency sets the number of concurrent clients. Defaults to 8.
+	Concurrency int
+	// Requests sets the total number of requests made. Defaults to 1000; ignored if Duration is set.
+	Requests int
+	// Duration, if set, runs the clients for a fixed time rather than a fixed number of requests.
+	Duration time.Duration
+	// Mix sets the scenarios from which requests are chosen. Defaults to DefaultMix.
+	Mix []Scenario
+	// Items sets the number of items seeded before the run. Defaults to 100.
+	Items int
+	// ItemSize sets the size in bytes of the data of seeded items. Defaults to 256.
+	ItemSize int
+	// Seed seeds the choice of scenarios and items, so that runs are repeatable.
+	Seed int64
+}
+
+func (config *Config) normalize() error {
+	if config.Concurrency < 0 || config.Requests < 0 || config.Duration < 0 || config.Items < 0 || config.ItemSize < 0 {
+		return ErrInvalidConfig
+	}
+	if config.Concurrency == 0 {
+		config.Concurrency = defaultConcurrency
+	}
+	if config.Requests == 0 {
+		config.Requests = defaultRequests
+	}
+	if config.Items == 0 {
+		config.Items = defaultItems
+	}
+	if config.ItemSize == 0 {
+		config.ItemSize = defaultItemSize
+	}
+	if len(config.Mix) == 0 {
+		config.Mix = DefaultMix
+	}
+	mix := make([]Scenario, len(config.Mix))
+	for i, sc := range config.Mix {
+		switch sc.Op {
+		case OpList, OpGet, OpCreate, OpUpdate, OpPatch, OpDelete:
+		default:
+			return ErrInvalidConfig
+		}
+		if sc.Weight < 0 || sc.PayloadSize < 0 {
+			return ErrInvalidConfig
+		}
+		if sc.Name == "" {
+			sc.Name = string(sc.Op)
+		}
+		if sc.Weight == 0 {
+			sc.Weight = 1
+		}
+		mix[i] = sc
+	}
+	config.Mix = mix
+	return nil
+}
+
+// Stats summarizes the requests made by a scenario.
+type Stats struct {
+	Requests int           `json:"requests"`
+	Errors   int           `json:"errors"`
+	P50      time.Duration `json:"p50"`
+	P90      time.Duration `json:"p90"`
+	P99      time.Duration `json:"p99"`
+	Max      time.Duration `json:"max"`
+}
+
+// Report holds the results of a soak run. Allocation counts include the
+// clients' own allocations, so they're only meaningful when compared with
+// earlier runs of the same config.
+type Report struct {
+	Requests         int               `json:"requests"`
+	Errors           int               `json:"errors"`
+	Elapsed          time.Duration     `json:"elapsed"`
+	Scenarios        map[string]*Stats `json:"scenarios"`
+	AllocsPerRequest float64           `json:"allocs_per_request"`
+	BytesPerRequest  float64           `json:"bytes_per_request"`
+	GoroutineLeak    int               `json:"goroutine_leak"`
+	// FirstError describes the first failed request, if any.
+	FirstError string `json:"first_error,omitempty"`
+}
+
+// Budget sets the limits that a soak run must stay within.
+type Budget struct {
+	// P99 sets the maximum 99th percentile latency of every scenario. Unchecked if zero.
+	P99 time.Duration
+	// AllocsPerRequest sets the maximum number of heap allocations per request. Unchecked if zero.
+	AllocsPerRequest float64
+	// ErrorRate sets the maximum fraction of requests that may fail. Defaults to none.
+	ErrorRate float64
+	// GoroutineLeak sets the maximum number of goroutines that may remain after the run. Defaults to none.
+	GoroutineLeak int
+}
+
+// Check returns an error describing each budget that the run exceeded, or nil.
+func (r *Report) Check(b Budget) error {
+	var violations []string
+	names := make([]string, 0, len(r.Scenarios))
+	for name := range r.Scenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if p99 := r.Scenarios[name].P99; b.P99 > 0 && p99 > b.P99 {
+			violations = append(violations, fmt.Sprintf("%s p99 latency %s exceeds %s", name, p99, b.P99))
+		}
+	}
+	if b.AllocsPerRequest > 0 && r.AllocsPerRequest > b.AllocsPerRequest {
+		violations = append(violations, fmt.Sprintf("%.1f allocations per request exceeds %.1f", r.AllocsPerRequest, b.AllocsPerRequest))
+	}
+	if r.Requests > 0 && float64(r.Errors)/float64(r.Requests) > b.ErrorRate {
+		violations = append(violations, fmt.Sprintf("%d of %d requests failed (first: %s)", r.Errors, r.Requests, r.FirstError))
+	}
+	if r.GoroutineLeak > b.GoroutineLeak {
+		violations = append(violations, fmt.Sprintf("%d goroutines leaked", r.GoroutineLeak))
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	return errors.New("soak budget exceeded: " + strings.Join(violations, "; "))
+}
+
+// Run performs a soak run and reports its results.
+func Run(config Config) (*Report, error) {
+	if err := config.normalize(); err != nil {
+		return nil, err
+	}
+	svcConfig := config.Service
+	if svcConfig == nil {
+		svcConfig = &luddite.ServiceConfig{}
+		svcConfig.Version.Min = 1
+		svcConfig.Version.Max = 1
+	}
+	s, err := luddite.NewService(svcConfig)
+	if err != nil {
+		return nil, err
+	}
+	s.Logger().Out = ioutil.Discard
+	if err = s.AddResource(svcConfig.Version.Max, "/items", newItems(config.Items, config.ItemSize)); err != nil {
+		return nil, err
+	}
+
+	// Goroutines started by the service itself aren't leaks
+	baseline := runtime.NumGoroutine()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	server := httptest.NewServer(s)
+	transport := &http.Transport{MaxIdleConnsPerHost: config.Concurrency}
+	r := &runner{
+		config:    &config,
+		client:    &http.Client{Transport: transport},
+		baseURL:   server.URL + "/items",
+		version:   strconv.Itoa(svcConfig.Version.Max),
+		latencies: make(map[string][]time.Duration),
+		errors:    make(map[string]int),
+	}
+	for _, sc := range config.Mix {
+		r.totalWeight += sc.Weight
+	}
+	start := time.Now()
+	r.run()
+	elapsed := time.Since(start)
+
+	transport.CloseIdleConnections()
+	server.Close()
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	report := r.report(elapsed)
+	if report.Requests > 0 {
+		report.AllocsPerRequest = float64(after.Mallocs-before.Mallocs) / float64(report.Requests)
+		report.BytesPerRequest = float64(after.TotalAlloc-before.TotalAlloc) / float64(report.Requests)
+	}
+	report.GoroutineLeak = settle(baseline)
+	return report, nil
+}
+
+// settle waits for goroutines to exit, returning the number that remain above
+// a baseline.
+func settle(baseline int) int {
+	deadline := time.Now().Add(settleTimeout)
+	for {
+		n := runtime.NumGoroutine() - baseline
+		if n <= 0 {
+			return 0
+		}
+		if time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type runner struct {
+	config      *Config
+	client      *http.Client
+	baseURL     string
+	version     string
+	totalWeight int
+
+	mu         sync.Mutex
+	latencies  map[string][]time.Duration
+	errors     map[string]int
+	firstError string
+}
+
+func (r *runner) run() {
+	var (
+		wg        sync.WaitGroup
+		remaining = make(chan struct{}, r.config.Concurrency)
+	)
+
+	// Hand out requests until the run's quota or time is up
+	go func() {
+		defer close(remaining)
+		if r.config.Duration > 0 {
+			timer := time.NewTimer(r.config.Duration)
+			defer timer.Stop()
+			for {
+				select {
+				case remaining <- struct{}{}:
+				case <-timer.C:
+					return
+				}
+			}
+		}
+		for i := 0; i < r.config.Requests; i++ {
+			remaining <- struct{}{}
+		}
+	}()
+
+	for i := 0; i < r.config.Concurrency; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			w := &worker{runner: r, rand: rand.New(rand.NewSource(r.config.Seed + int64(n)))}
+			for range remaining {
+				w.request()
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func (r *runner) record(name string, latency time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies[name] = append(r.latencies[name], latency)
+	if err != nil {
+		r.errors[name]++
+		if r.firstError == "" {
+			r.firstError = name + ": " + err.Error()
+		}
+	}
+}
+
+func (r *runner) report(elapsed time.Duration) *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := &Report{
+		Elapsed:    elapsed,
+		Scenarios:  make(map[string]*Stats, len(r.latencies)),
+		FirstError: r.firstError,
+	}
+	for name, latencies := range r.latencies {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		stats := &Stats{
+			Requests: len(latencies),
+			Errors:   r.errors[name],
+			P50:      percentile(latencies, 0.5),
+			P90:      percentile(latencies, 0.9),
+			P99:      percentile(latencies, 0.99),
+			Max:      latencies[len(latencies)-1],
+		}
+		report.Scenarios[name] = stats
+		report.Requests += stats.Requests
+		report.Errors += stats.Errors
+	}
+	return report
+}
+
+// percentile returns the pth percentile of sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// worker is a single client. Each worker deletes only the items it created.
+type worker struct {
+	*runner
+	rand    *rand.Rand
+	created []string
+}
+
+func (w *worker) request() {
+	sc := w.choose()
+	req, expected := w.newRequest(sc)
+	start := time.Now()
+	resp, err := w.client.Do(req)
+	if err == nil {
+		var id string
+		if sc.Op == OpCreate && resp.StatusCode == http.StatusCreated {
+			var item Item
+			if err = json.NewDecoder(resp.Body).Decode(&item); err == nil {
+				id = item.Id
+			}
+		}
+		// Drain the body so that the connection is reused
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+		if err == nil && resp.StatusCode != expected {
+			err = fmt.Errorf("%s %s: expected %d response, got: %d", req.Method, req.URL.Path, expected, resp.StatusCode)
+		}
+		if id != "" {
+			w.created = append(w.created, id)
+		}
+	}
+	w.record(sc.Name, time.Since(start), err)
+}
+
+func (w *worker) choose() *Scenario {
+	n := w.rand.Intn(w.totalWeight)
+	for i := range w.config.Mix {
+		if n -= w.config.Mix[i].Weight; n < 0 {
+			return &w.config.Mix[i]
+		}
+	}
+	return &w.config.Mix[len(w.config.Mix)-1]
+}
+
+// newRequest returns a scenario's next request and the status of a successful
+// response.
+func (w *worker) newRequest(sc *Scenario) (*http.Request, int) {
+	var (
+		method   = "GET"
+		url      = w.baseURL
+		body     interface{}
+		ct       = luddite.ContentTypeJson
+		expected = http.StatusOK
+	)
+	seeded := strconv.Itoa(w.rand.Intn(w.config.Items))
+	switch sc.Op {
+	case OpGet:
+		url += "/" + seeded
+	case OpCreate:
+		method, expected = "POST", http.StatusCreated
+		body = newItem("", sc.PayloadSize)
+	case OpUpdate:
+		method, url = "PUT", url+"/"+seeded
+		body = newItem(seeded, sc.PayloadSize)
+	case OpPatch:
+		method, url, ct = "PATCH", url+"/"+seeded, luddite.ContentTypeMergePatch
+		data := strings.Repeat("p", sc.PayloadSize)
+		body = &itemPatch{Data: &data}
+	case OpDelete:
+		method, expected = "DELETE", http.StatusNoContent
+		id := "missing"
+		if n := len(w.created); n > 0 {
+			id, w.created = w.created[n-1], w.created[:n-1]
+		}
+		url += "/" + id
+	}
+
+	var r io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		r = bytes.NewReader(b)
+	}
+	req, _ := http.NewRequest(method, url, r)
+	req.Header.Set(luddite.HeaderAccept, luddite.ContentTypeJson)
+	req.Header.Set(luddite.HeaderSpirentApiVersion, w.version)
+	if body != nil {
+		req.Header.Set(luddite.HeaderContentType, ct)
+	}
+	return req, expected
+}
@@ -0,0 +1,60 @@
+package soak
+
+import (
+	"flag"
+	"testing"
+	"time"
+)
+
+var soakDuration = flag.Duration("soak.duration", 0, "run TestSoak for this long instead of a short smoke run")
+
+func TestSoak(t *testing.T) {
+	config := Config{Concurrency: 4, Requests: 400}
+	if *soakDuration > 0 {
+		config = Config{Concurrency: 32, Duration: *soakDuration}
+	}
+	report, err := Run(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sc := range DefaultMix {
+		if r := report.Scenarios[sc.Name]; r == nil || r.Requests == 0 {
+			t.Errorf("expected %s requests to be made", sc.Name)
+		}
+	}
+	t.Logf("%d requests in %s: %.0f allocs/request, %.0f bytes/request", report.Requests, report.Elapsed, report.AllocsPerRequest, report.BytesPerRequest)
+
+	// Latency budgets are generous since tests may run with the race detector
+	if err = report.Check(Budget{P99: 2 * time.Second}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	report := &Report{
+		Requests:         100,
+		Errors:           2,
+		Scenarios:        map[string]*Stats{"get": {Requests: 100, Errors: 2, P99: 50 * time.Millisecond}},
+		AllocsPerRequest: 150,
+		GoroutineLeak:    1,
+	}
+	if err := report.Check(Budget{P99: time.Second, AllocsPerRequest: 200, ErrorRate: 0.05, GoroutineLeak: 1}); err != nil {
+		t.Errorf("unexpected budget violation: %v", err)
+	}
+	for _, b := range []Budget{
+		{P99: 10 * time.Millisecond, ErrorRate: 0.05, GoroutineLeak: 1},
+		{AllocsPerRequest: 100, ErrorRate: 0.05, GoroutineLeak: 1},
+		{ErrorRate: 0.01, GoroutineLeak: 1},
+		{ErrorRate: 0.05},
+	} {
+		if err := report.Check(b); err == nil {
+			t.Errorf("expected %+v to be exceeded", b)
+		}
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	if _, err := Run(Config{Mix: []Scenario{{Op: "mkcol"}}}); err != ErrInvalidConfig {
+		t.Errorf("expected ErrInvalidConfig, got: %v", err)
+	}
+}
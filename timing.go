@@ -0,0 +1,115 @@
+package luddite
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Server-Timing entry names for the phases of request handling. Decode,
+// handler and encode entries are recorded automatically; services that
+// authenticate requests in their own middleware may record an auth entry
+// using AddServerTiming.
+const (
+	ServerTimingAuth    = "auth"
+	ServerTimingDecode  = "decode"
+	ServerTimingHandler = "handler"
+	ServerTimingEncode  = "encode"
+	ServerTimingTotal   = "total"
+)
+
+type serverTiming struct {
+	name string
+	dur  time.Duration
+}
+
+// requestTiming accumulates a response's Server-Timing entries.
+type requestTiming struct {
+	enabled    bool
+	start      time.Time
+	routeStart time.Time
+	entries    []serverTiming
+}
+
+func (t *requestTiming) init() {
+	t.enabled = false
+	t.start = time.Time{}
+	t.routeStart = time.Time{}
+	t.entries = t.entries[:0]
+}
+
+func (t *requestTiming) add(name string, dur time.Duration) {
+	for i := range t.entries {
+		if t.entries[i].name == name {
+			t.entries[i].dur += dur
+			return
+		}
+	}
+	t.entries = append(t.entries, serverTiming{name, dur})
+}
+
+func (t *requestTiming) get(name string) time.Duration {
+	for _, entry := range t.entries {
+		if entry.name == name {
+			return entry.dur
+		}
+	}
+	return 0
+}
+
+// setHeaders adds X-Response-Time and Server-Timing headers. The handler phase
+// covers the time spent in the route, less the time spent decoding requests
+// and encoding responses.
+func (t *requestTiming) setHeaders(header http.Header) {
+	now := time.Now()
+	if !t.routeStart.IsZero() {
+		if dur := now.Sub(t.routeStart) - t.get(ServerTimingDecode) - t.get(ServerTimingEncode); dur > 0 {
+			t.add(ServerTimingHandler, dur)
+		}
+	}
+	total := now.Sub(t.start)
+	t.add(ServerTimingTotal, total)
+
+	entries := make([]string, len(t.entries))
+	for i, entry := range t.entries {
+		entries[i] = fmt.Sprintf("%s;dur=%.3f", entry.name, durationMillis(entry.dur))
+	}
+	header.Set(HeaderResponseTime, fmt.Sprintf("%.3fms", durationMillis(total)))
+	header.Set(HeaderServerTiming, strings.Join(entries, ", "))
+}
+
+func durationMillis(dur time.Duration) float64 {
+	return float64(dur) / float64(time.Millisecond)
+}
+
+// contextTiming returns the request's timing state if timing is enabled.
+func contextTiming(rw http.ResponseWriter) *requestTiming {
+	if res, ok := rw.(*responseWriter); ok && res.timing.enabled && !res.Written() {
+		return &res.timing
+	}
+	return nil
+}
+
+// AddServerTiming adds a duration to the named Server-Timing entry of the
+// response. It has no effect unless timing is enabled or once the response
+// headers have been written.
+func AddServerTiming(ctx context.Context, name string, dur time.Duration) {
+	if t := contextTiming(ContextResponseWriter(ctx)); t != nil {
+		t.add(name, dur)
+	}
+}
+
+type timingHandler struct{}
+
+func newTimingHandler() http.Handler {
+	return &timingHandler{}
+}
+
+func (h *timingHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if res, ok := rw.(*responseWriter); ok {
+		res.timing.enabled = true
+		res.timing.start = time.Now()
+	}
+}
@@ -0,0 +1,56 @@
+package luddite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerTiming(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Timing.Enabled = true
+	})
+	r := &versionedResource{current: &versionedSample{Id: "a", Rev: "1"}}
+	mustAddResource(t, s, 1, "/samples", r)
+
+	req, _ := http.NewRequest("PUT", "/samples/a", strings.NewReader(`{"id":"a"}`))
+	req.Header.Set(HeaderAccept, ContentTypeJson)
+	req.Header.Set(HeaderContentType, ContentTypeJson)
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", rw.Code)
+	}
+	if v := rw.Header().Get(HeaderResponseTime); !strings.HasSuffix(v, "ms") {
+		t.Errorf("incorrect X-Response-Time: %s", v)
+	}
+	timing := rw.Header().Get(HeaderServerTiming)
+	for _, name := range []string{ServerTimingDecode, ServerTimingHandler, ServerTimingEncode, ServerTimingTotal} {
+		if !strings.Contains(timing, name+";dur=") {
+			t.Errorf("Server-Timing missing %s entry: %s", name, timing)
+		}
+	}
+}
+
+func TestAddServerTiming(t *testing.T) {
+	res := &responseWriter{}
+	res.init(httptest.NewRecorder())
+	ctx := withHandlerDetails(context.Background(), &handlerDetails{rw: res})
+
+	AddServerTiming(ctx, ServerTimingAuth, time.Millisecond)
+	if len(res.timing.entries) != 0 {
+		t.Error("timing recorded while disabled")
+	}
+
+	res.timing.enabled = true
+	res.timing.start = time.Now()
+	AddServerTiming(ctx, ServerTimingAuth, time.Millisecond)
+	AddServerTiming(ctx, ServerTimingAuth, time.Millisecond)
+	res.WriteHeader(http.StatusNoContent)
+	if timing := res.Header().Get(HeaderServerTiming); !strings.HasPrefix(timing, "auth;dur=2.000, total;dur=") {
+		t.Errorf("incorrect Server-Timing: %s", timing)
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 const (
 	TraceKindAWS     = "aws"
+	TraceKindHTTP    = "http"
 	TraceKindProcess = "process"
 	TraceKindRequest = "request"
 	TraceKindWorker  = "worker"
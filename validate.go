@@ -0,0 +1,60 @@
+package luddite
+
+import (
+	"net/http"
+)
+
+// Validatable is implemented by request bodies that check their own semantic
+// validity, e.g. required fields and value ranges. Collection and singleton
+// routes validate request bodies after deserializing them.
+type Validatable interface {
+	Validate() error
+}
+
+// ValidateRequest validates a deserialized request body if it implements
+// Validatable. Validation errors are returned as VALIDATION_FAILED errors
+// unless they're already *Error values.
+func ValidateRequest(v interface{}) error {
+	x, ok := v.(Validatable)
+	if !ok {
+		return nil
+	}
+	err := x.Validate()
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return NewError(nil, EcodeValidationFailed, err)
+}
+
+// RequestErrorStatus returns the response status for an error returned by
+// ReadRequest or ValidateRequest. Decode and validation failures receive the
+// statuses configured by the service handling the request (by default, 400),
+// and unsupported content types receive 415.
+func RequestErrorStatus(req *http.Request, err error) int {
+	if e, ok := err.(*Error); ok {
+		if status, ok := ContextService(req.Context()).ecodeStatus(e.Code); ok {
+			return status
+		}
+	}
+	return http.StatusBadRequest
+}
+
+// readRequestBody deserializes and validates a route's request body. It
+// returns false if the body was rejected.
+func readRequestBody(rw http.ResponseWriter, req *http.Request, v interface{}, route string) bool {
+	ctx := req.Context()
+	if err := ReadRequest(req, v); err != nil {
+		SetContextRequestProgress(ctx, route+".body_error")
+		_ = WriteResponse(rw, RequestErrorStatus(req, err), err)
+		return false
+	}
+	if err := ValidateRequest(v); err != nil {
+		SetContextRequestProgress(ctx, route+".validation_error")
+		_ = WriteResponse(rw, RequestErrorStatus(req, err), err)
+		return false
+	}
+	return true
+}
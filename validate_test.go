@@ -0,0 +1,68 @@
+package luddite
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type widget struct {
+	Id   string `json:"id"`
+	Size int    `json:"size"`
+}
+
+func (w *widget) Validate() error {
+	if w.Size < 1 {
+		return errors.New("size must be positive")
+	}
+	return nil
+}
+
+type widgetResource struct{}
+
+func (r *widgetResource) New() interface{} { return &widget{} }
+
+func (r *widgetResource) Id(v interface{}) string { return v.(*widget).Id }
+
+func (r *widgetResource) Create(req *http.Request, v interface{}) (int, interface{}) {
+	return http.StatusCreated, v
+}
+
+func TestRequestErrorStatuses(t *testing.T) {
+	s := newTestService(t, func(config *ServiceConfig) {
+		config.Errors.ValidationStatus = http.StatusUnprocessableEntity
+	})
+	mustAddResource(t, s, 1, "/widgets", &widgetResource{})
+
+	tests := []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{ContentTypeJson, `{"id":"w1","size":3}`, http.StatusCreated},
+		{ContentTypeJson, `{"id":`, http.StatusBadRequest},
+		{ContentTypeJson, `{"id":"w1","size":0}`, http.StatusUnprocessableEntity},
+		{ContentTypeCsv, `id,size`, http.StatusUnsupportedMediaType},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/widgets", strings.NewReader(test.body))
+		req.Header.Set(HeaderAccept, ContentTypeJson)
+		req.Header.Set(HeaderContentType, test.contentType)
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, req)
+		if rw.Code != test.status {
+			t.Errorf("%s: expected %d response, got: %d", test.body, test.status, rw.Code)
+		}
+	}
+
+	if status, _, ok := recoveredResponse(s, NewError(nil, EcodeValidationFailed, "size")); !ok || status != http.StatusUnprocessableEntity {
+		t.Errorf("expected panics with validation errors to use the configured status, got: %d", status)
+	}
+
+	s.config.Errors.DecodeStatus = http.StatusOK
+	if err := s.config.Validate(); err != ErrInvalidErrorStatus {
+		t.Errorf("expected ErrInvalidErrorStatus, got: %v", err)
+	}
+}
@@ -6,15 +6,40 @@ import (
 )
 
 type version struct {
-	minVersion int
-	maxVersion int
+	minVersion   int
+	maxVersion   int
+	contentTypes map[int]MediaType
 }
 
-func newVersionHandler(minVersion, maxVersion int) http.Handler {
-	return &version{
-		minVersion: minVersion,
-		maxVersion: maxVersion,
+// newVersionHandler returns a handler that negotiates the API version of
+// requests. Requests without an Accept header receive the default content type
+// configured for their API version, if any: each entry of contentTypes applies
+// to its API version and later ones, up to the next entry.
+func newVersionHandler(minVersion, maxVersion int, contentTypes map[int]string) http.Handler {
+	v := &version{
+		minVersion:   minVersion,
+		maxVersion:   maxVersion,
+		contentTypes: make(map[int]MediaType),
 	}
+	for i := minVersion; i <= maxVersion; i++ {
+		if ct, ok := versionEntry(contentTypes, i); ok && ct != "" {
+			// Content types are validated with the service config
+			v.contentTypes[i], _ = ParseMediaType(ct)
+		}
+	}
+	return v
+}
+
+// versionEntry returns the entry of a per-version config map that applies to
+// an API version: each entry applies to its API version and later ones, up to
+// the next entry.
+func versionEntry(entries map[int]string, version int) (string, bool) {
+	for v := version; v > 0; v-- {
+		if x, ok := entries[v]; ok {
+			return x, true
+		}
+	}
+	return "", false
 }
 
 func (v *version) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
@@ -42,6 +67,17 @@ func (v *version) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// Apply the version's default content type to requests that don't state
+	// what they accept, e.g. from legacy integrations
+	if mt, ok := v.contentTypes[version]; ok && req.Header.Get(HeaderAccept) == "" {
+		SetContentType(rw, mt)
+		if res, ok := rw.(*responseWriter); ok {
+			if mt.Is(ContentTypeJson) || mt.Is(ContentTypeXml) {
+				res.errorContentType = mt.String()
+			}
+		}
+	}
+
 	// Add the requested API version to response headers (useful for clients when a default version was negotiated)
 	rw.Header().Add(HeaderSpirentApiVersion, strconv.Itoa(version))
 
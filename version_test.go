@@ -12,7 +12,7 @@ func TestMinApiVersionConstraint(t *testing.T) {
 	rw := httptest.NewRecorder()
 	rw.Header().Set(HeaderContentType, ContentTypeJson)
 
-	v := newVersionHandler(2, 42)
+	v := newVersionHandler(2, 42, nil)
 	v.ServeHTTP(rw, req)
 	if rw.Code != http.StatusGone {
 		t.Error("expected 410/Gone response for outdated version")
@@ -25,7 +25,7 @@ func TestMaxApiVersionConstraint(t *testing.T) {
 	rw := httptest.NewRecorder()
 	rw.Header().Set(HeaderContentType, ContentTypeJson)
 
-	v := newVersionHandler(2, 42)
+	v := newVersionHandler(2, 42, nil)
 	v.ServeHTTP(rw, req)
 	if rw.Code != http.StatusNotImplemented {
 		t.Error("expected 501/Not Implemented response for future version")
@@ -38,7 +38,7 @@ func TestApiVersionContext(t *testing.T) {
 	req = req.WithContext(withHandlerDetails(req.Context(), &handlerDetails{}))
 	rw := httptest.NewRecorder()
 
-	v := newVersionHandler(1, 1)
+	v := newVersionHandler(1, 1, nil)
 	v.ServeHTTP(rw, req)
 	if ContextApiVersion(req.Context()) != 1 {
 		t.Error("missing API version in request context")
@@ -47,3 +47,46 @@ func TestApiVersionContext(t *testing.T) {
 		t.Errorf("missing %s header in response", HeaderSpirentApiVersion)
 	}
 }
+
+func TestApiVersionDefaultContentType(t *testing.T) {
+	v := newVersionHandler(1, 4, map[int]string{1: ContentTypeXml, 3: ContentTypeJson})
+	tests := []struct {
+		version string
+		accept  string
+		ct      string
+	}{
+		{"1", "", ContentTypeXml},
+		{"2", "", ContentTypeXml},
+		{"3", "", ContentTypeJson},
+		{"4", "", ContentTypeJson},
+		{"1", ContentTypeJson, ContentTypeJson},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set(HeaderSpirentApiVersion, test.version)
+		if test.accept != "" {
+			req.Header.Set(HeaderAccept, test.accept)
+		}
+		req = req.WithContext(withHandlerDetails(req.Context(), &handlerDetails{}))
+		rw := httptest.NewRecorder()
+		rw.Header().Set(HeaderContentType, ContentTypeJson)
+		v.ServeHTTP(rw, req)
+		if ct := rw.Header().Get(HeaderContentType); ct != test.ct {
+			t.Errorf("v%s with Accept %q: expected %s content type, got: %s", test.version, test.accept, test.ct, ct)
+		}
+	}
+}
+
+func TestApiVersionDefaultContentTypeParams(t *testing.T) {
+	config := &ServiceConfig{}
+	config.Version.Min = 1
+	config.Version.Max = 1
+	config.Version.DefaultContentTypes = map[int]string{1: "Application/JSON; charset=utf-8"}
+	if _, err := NewService(config); err != nil {
+		t.Fatalf("expected default content type with parameters to be accepted, got: %v", err)
+	}
+	config.Version.DefaultContentTypes = map[int]string{1: "json"}
+	if _, err := NewService(config); err != ErrInvalidVersionContentType {
+		t.Errorf("expected ErrInvalidVersionContentType, got: %v", err)
+	}
+}